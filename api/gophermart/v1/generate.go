@@ -0,0 +1,4 @@
+// Package gophermartv1 holds the generated gRPC API, see gophermart.proto.
+package gophermartv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative gophermart/v1/gophermart.proto
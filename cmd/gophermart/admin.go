@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/logger"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"os"
+	"strings"
+)
+
+// createAdmin bootstraps an administrator. An existing user is promoted,
+// otherwise a new one is registered with the given password.
+//
+//	gophermart create-admin -d <database uri> -login <login> [-password <password>]
+func createAdmin(args []string) error {
+	const op = "main.createAdmin"
+
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	databaseURI := fs.String("d", os.Getenv("DATABASE_URI"), "connection string to postgres db")
+	env := fs.String("e", "dev", "environment")
+	login := fs.String("login", os.Getenv("ADMIN_LOGIN"), "admin login")
+	password := fs.String("password", os.Getenv("ADMIN_PASSWORD"), "admin password, required when the user doesn't exist")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if *login == "" {
+		return fmt.Errorf("%s: login is required", op)
+	}
+
+	log := logger.SetupLogger(*env, logger.DefaultLevel(*env))
+	db, err := postgresql.New(*databaseURI, log)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx := context.Background()
+
+	_, err = db.GetUser(ctx, *login)
+	switch {
+	case err == nil:
+		if err = db.SetUserRole(ctx, *login, user.RoleAdmin); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("existing user promoted to admin")
+		return nil
+	case !errors.Is(err, postgresql.ErrorUserNotFound):
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	violations := append(validation.ValidateLogin(*login), validation.DefaultPasswordPolicy().Validate(*password, *login)...)
+	if len(violations) > 0 {
+		messages := make([]string, len(violations))
+		for i, v := range violations {
+			messages[i] = v.Message
+		}
+		return fmt.Errorf("%s: %s", op, strings.Join(messages, "; "))
+	}
+
+	hashPass, err := utils.GenerateHashPassword(*password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = db.AddUser(ctx, user.User{
+		Login:        *login,
+		HashPassword: hashPass,
+		Role:         user.RoleAdmin,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("admin user created")
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/config"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// loadConfig exits when the configuration is invalid, listing every
+// problem.
+func loadConfig(args []string) config.Config {
+	cfg, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// configCommand prints the configuration serve would run with, secrets
+// redacted, and then what is wrong with it.
+//
+//	gophermart config print [flags]
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: gophermart config print [flags]")
+	}
+
+	cfg, err := config.Load(args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	// Settings that don't parse leave nothing worth printing.
+	if err != nil && cfg == (config.Config{}) {
+		return err
+	}
+
+	out, marshalErr := yaml.Marshal(cfg.Redacted())
+	if marshalErr != nil {
+		return marshalErr
+	}
+	os.Stdout.Write(out)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
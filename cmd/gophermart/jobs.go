@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/scheduler"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"time"
+)
+
+const (
+	// outboxRetention is how long published events are kept, e.g. to be
+	// looked up from webhook deliveries.
+	outboxRetention = 7 * 24 * time.Hour
+	pruneBatch      = 1000
+)
+
+// maintenanceJobs clean up data nobody needs anymore. Two replicas
+// deleting the same rows only waste work, so they run on the leader.
+func maintenanceJobs(db storage.Storage) []scheduler.Job {
+	return []scheduler.Job{
+		{
+			Name:      "purge-password-resets",
+			Every:     time.Hour,
+			Singleton: true,
+			Run: func(ctx context.Context) error {
+				_, err := db.PurgePasswordResets(ctx, time.Now())
+				return err
+			},
+		},
+		{
+			Name:      "prune-outbox",
+			Every:     time.Hour,
+			Singleton: true,
+			Run: func(ctx context.Context) error {
+				before := time.Now().Add(-outboxRetention)
+				for ctx.Err() == nil {
+					n, err := db.PruneOutbox(ctx, before, pruneBatch)
+					if err != nil || n < pruneBatch {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
@@ -2,74 +2,417 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/auth"
 	"github.com/kholodmv/gophermart/internal/client"
 	"github.com/kholodmv/gophermart/internal/config"
+	"github.com/kholodmv/gophermart/internal/events"
+	grpcserver "github.com/kholodmv/gophermart/internal/grpc-server"
+	"github.com/kholodmv/gophermart/internal/health"
 	"github.com/kholodmv/gophermart/internal/http-server/handlers"
+	"github.com/kholodmv/gophermart/internal/http-server/openapi"
+	"github.com/kholodmv/gophermart/internal/leader"
+	"github.com/kholodmv/gophermart/internal/lifecycle"
+	"github.com/kholodmv/gophermart/internal/lockout"
 	"github.com/kholodmv/gophermart/internal/logger"
 	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/metrics"
+	"github.com/kholodmv/gophermart/internal/notifier"
+	"github.com/kholodmv/gophermart/internal/oidc"
+	"github.com/kholodmv/gophermart/internal/orderstream"
+	"github.com/kholodmv/gophermart/internal/scheduler"
+	"github.com/kholodmv/gophermart/internal/storage"
 	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/tlsconfig"
+	"github.com/kholodmv/gophermart/internal/tracing"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"github.com/kholodmv/gophermart/internal/webhooks"
 	_ "github.com/lib/pq"
 	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// exitStorage tells supervisors that the database is unreachable or
+// can't be migrated, as opposed to a bad configuration.
+const exitStorage = 3
+
 func main() {
-	cfg := config.UseServerStartParams()
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "config":
+		if err := configCommand(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	case "create-admin":
+		if err := createAdmin(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, want serve, config or create-admin\n", command)
+		os.Exit(2)
+	}
+}
+
+// serve runs the process in the configured role until it is signalled
+// to stop. Without a command the process serves in all roles. SIGHUP or a
+// change of the config file applies the settings of config.Runtime.
+//
+//	gophermart [serve] [-role api|worker|all] [flags]
+func serve(args []string) {
+	cfg := loadConfig(args)
+
+	level := new(slog.LevelVar)
+	level.Set(cfg.Runtime().LogLevel)
+	log := logger.SetupLogger(cfg.Env, level)
+	log = log.With(slog.String("env", cfg.Env), slog.String("role", cfg.Role))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: cfg.TraceExporter,
+		Endpoint: cfg.TraceEndpoint,
+		Insecure: cfg.TraceInsecure,
+	})
+	if err != nil {
+		log.Error("failed to set up tracing", sl.Err(err))
+		os.Exit(1)
+	}
 
-	log := logger.SetupLogger(cfg.Env)
-	log = log.With(slog.String("env", cfg.Env))
+	if cfg.TokenSecret != "" {
+		auth.SecretKey = []byte(cfg.TokenSecret)
+	} else {
+		// Validation only lets this happen in development.
+		log.Warn("token_secret is not set, sessions end on restart")
+	}
 
-	db, err := postgresql.New(cfg.DatabaseURI)
+	m := metrics.New()
+
+	pg, err := postgresql.New(cfg.DatabaseURI, log)
 	if err != nil {
 		log.Error("failed to initialize storage", sl.Err(err))
+		os.Exit(exitStorage)
 	}
+	m.RegisterDB(pg.DB())
+	db := tracing.InstrumentStorage(metrics.InstrumentStorage(pg, m))
 
-	router := chi.NewRouter()
+	c := client.New(cfg.AccrualSystemAddress, db, cfg.AccrualInterval, log, client.WithMetrics(m))
 
-	handler := handlers.NewHandler(router, log, db)
-	handler.RegisterRoutes()
+	checks := []health.Check{
+		{Name: "database", Critical: true, Check: pg.Ping},
+		{Name: "migrations", Critical: true, Check: pg.CheckMigrations},
+	}
+	// The API only calls the accrual system when an order is polled on
+	// demand, so it is ready without it.
+	if cfg.RunsWorker() {
+		checks = append(checks, health.Check{Name: "accrual", Check: c.Check})
+	}
+	hc := health.New(2*time.Second, checks...)
 
-	log.Info("initializing server", slog.String("address", cfg.RunAddress))
+	lc := lifecycle.New(log, cfg.ShutdownTimeout)
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	srv := &http.Server{
-		Addr:    cfg.RunAddress,
-		Handler: router,
+	guard := lockout.New(lockout.NewMemoryStore(), lockoutConfig(cfg.Runtime()))
+
+	var tlsCfg *tls.Config
+	if cfg.TLSCertFile != "" {
+		certs, err := tlsconfig.NewCertificates(cfg.TLSCertFile, cfg.TLSKeyFile, log)
+		if err != nil {
+			log.Error("failed to load certificate", sl.Err(err))
+			os.Exit(1)
+		}
+		lc.Go("tls-certificates", certs.Run)
+		tlsCfg = tlsconfig.Server(certs, tlsconfig.Versions[cfg.TLSMinVersion])
 	}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Error("failed to start server")
+	if cfg.RunsAPI() {
+		if err = startAPI(lc, cfg, log, db, c, guard, m, hc, tlsCfg.Clone()); err != nil {
+			log.Error("failed to start api", sl.Err(err))
+			os.Exit(1)
 		}
-	}()
+	}
+
+	// The admin server stops last, so that the shutdown can be watched.
+	// It is the only listener of a worker, so health is served here too.
+	if cfg.AdminAddress != "" {
+		adminTLS := tlsCfg.Clone()
+		var metricsHandler http.Handler = m.Handler()
+		if cfg.AdminClientCAFile != "" {
+			if err = tlsconfig.RequestClientCerts(adminTLS, cfg.AdminClientCAFile); err != nil {
+				log.Error("failed to load admin client CAs", sl.Err(err))
+				os.Exit(1)
+			}
+			metricsHandler = tlsconfig.RequireClientCert(metricsHandler)
+		}
+
+		adminRouter := chi.NewRouter()
+		adminRouter.Handle("/metrics", metricsHandler)
+		adminRouter.Get("/healthz", hc.Live)
+		adminRouter.Get("/readyz", hc.Ready)
+		adminSrv := newHTTPServer(cfg, cfg.AdminAddress, adminRouter, adminTLS)
+		lc.Go("admin", serveHTTP(adminSrv))
+		lc.OnStop("admin", stopHTTP(adminSrv))
+		log.Info("admin server started", slog.String("address", cfg.AdminAddress))
+	}
+
+	if cfg.RunsWorker() {
+		startWorker(lc, cfg, log, db, pg, c)
+	}
+
+	reloader := config.NewReloader(cfg, func() (config.Config, error) {
+		return config.Load(args, os.LookupEnv)
+	}, func(rt config.Runtime) {
+		level.Set(rt.LogLevel)
+		c.SetInterval(rt.AccrualInterval)
+		guard.SetConfig(lockoutConfig(rt))
+	}, log)
+	lc.Go("config-reloader", reloader.Run)
+
+	lc.OnCleanup("tracing", shutdownTracing)
+	lc.OnCleanup("database", func(context.Context) error {
+		return pg.DB().Close()
+	})
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// Abandoned work is picked up again on the next start and is only
+	// logged.
+	if report := lc.Wait(signals); len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+	log.Info("server stopped")
+}
+
+// startAPI starts the HTTP and gRPC servers.
+func startAPI(
+	lc *lifecycle.Manager,
+	cfg config.Config,
+	log *slog.Logger,
+	db storage.Storage,
+	c *client.Client,
+	guard *lockout.Guard,
+	m *metrics.Metrics,
+	hc *health.Health,
+	tlsCfg *tls.Config,
+) error {
+	const op = "main.startAPI"
+
+	policy := validation.DefaultPasswordPolicy()
+	policy.MinLength = cfg.PasswordMinLength
+	policy.MinClasses = cfg.PasswordMinClasses
+	if cfg.PasswordDenylistFile != "" {
+		// Running without the configured denylist would silently accept
+		// the passwords it is meant to reject.
+		if err := policy.LoadDenylist(cfg.PasswordDenylistFile); err != nil {
+			return fmt.Errorf("%s: load password denylist: %w", op, err)
+		}
+	}
+
+	opts := []handlers.Option{
+		handlers.WithLockout(guard),
+		handlers.WithPasswordPolicy(policy),
+		handlers.WithWithdrawalOTPThreshold(float32(cfg.WithdrawOTPThreshold)),
+		handlers.WithOrderPoller(c),
+		handlers.WithMetrics(m),
+		handlers.WithHealth(hc),
+		handlers.WithMaxBodySize(cfg.MaxBodySize),
+	}
+	switch {
+	case cfg.ResetNotifyFile != "":
+		opts = append(opts, handlers.WithNotifier(notifier.NewFileNotifier(cfg.ResetNotifyFile)))
+	case cfg.Dev():
+		// Logs live reset tokens, which is only acceptable in development.
+		opts = append(opts, handlers.WithNotifier(notifier.NewLogNotifier(log)))
+	default:
+		log.Warn("password reset tokens are not delivered, set reset-notify-file")
+	}
+
+	if cfg.OIDCIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+		}, nil)
+		cancel()
+		if err != nil {
+			log.Error("failed to discover oidc provider", sl.Err(err))
+		} else {
+			opts = append(opts, handlers.WithOIDC(provider))
+		}
+	}
+
+	doc, err := openapi.Load()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	validator, err := openapi.NewValidator(doc, log, cfg.ValidateResponses)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	opts = append(opts, handlers.WithValidator(validator))
+
+	// Orders are updated by the accrual poller of whichever worker gets
+	// to them first, so updates come back through the database.
+	hub := orderstream.NewHub()
+	lc.Go("order-updates", func(ctx context.Context) error {
+		return postgresql.ListenOrderUpdates(ctx, cfg.DatabaseURI, log, hub.Publish)
+	})
+	opts = append(opts, handlers.WithOrderStream(hub))
+
+	router := chi.NewRouter()
+	handler := handlers.NewHandler(router, log, db, opts...)
+	handler.RegisterRoutes()
+
+	log.Info("initializing server", slog.String("address", cfg.RunAddress), slog.Bool("tls", tlsCfg != nil))
+
+	srv := newHTTPServer(cfg, cfg.RunAddress, router, tlsCfg)
+	// Requests still running at the shutdown deadline are cancelled along
+	// with the workers.
+	srv.BaseContext = func(net.Listener) context.Context { return lc.Context() }
+	// Streams never finish on their own.
+	srv.RegisterOnShutdown(hub.Close)
+	lc.Go("http", serveHTTP(srv))
+	lc.OnStop("http", stopHTTP(srv))
 	log.Info("server started")
 
-	done := make(chan struct{})
-	c := client.New(cfg.AccrualSystemAddress, db, cfg.IntervalAccrualSystem, log)
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		c.ReportOrders(done)
-		wg.Done()
-	}()
+	if cfg.GRPCAddress != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		grpcSrv := grpcserver.New(log, db,
+			grpcserver.WithLockout(guard),
+			grpcserver.WithPasswordPolicy(policy),
+			grpcserver.WithWithdrawalOTPThreshold(float32(cfg.WithdrawOTPThreshold)),
+		).GRPCServer(grpcServerOptions(tlsCfg)...)
+		lc.Go("grpc", func(context.Context) error {
+			return grpcSrv.Serve(lis)
+		})
+		lc.OnStop("grpc", func(ctx context.Context) error {
+			return grpcserver.Shutdown(ctx, grpcSrv)
+		})
+		log.Info("grpc server started", slog.String("address", cfg.GRPCAddress), slog.Bool("tls", tlsCfg != nil))
+	}
+	return nil
+}
+
+func lockoutConfig(rt config.Runtime) lockout.Config {
+	cfg := lockout.DefaultConfig()
+	cfg.MaxLoginAttempts = rt.LoginMaxAttempts
+	cfg.MaxIPAttempts = rt.IPMaxAttempts
+	cfg.LockoutDuration = rt.LockoutDuration
+	return cfg
+}
+
+// startWorker starts polling the accrual system, delivering domain events
+// and webhooks, and the scheduled jobs.
+func startWorker(
+	lc *lifecycle.Manager,
+	cfg config.Config,
+	log *slog.Logger,
+	db storage.Storage,
+	pg *postgresql.Storage,
+	c *client.Client,
+) {
+	lc.Go("accrual-poller", c.ReportOrders)
 
-	<-stop
+	bus := events.NewBus()
+	bus.Subscribe(webhooks.Schedule(db))
+	sinks := []events.Sink{bus}
+	if cfg.EventsLog {
+		sinks = append(sinks, events.NewLogSink(log))
+	}
+	if cfg.EventsFile != "" {
+		sinks = append(sinks, events.NewFileSink(cfg.EventsFile))
+	}
+	if cfg.EventsURL != "" {
+		sinks = append(sinks, events.NewHTTPSink(cfg.EventsURL, 10*time.Second))
+	}
+	lc.Go("event-relay", events.NewRelay(db, log, events.DefaultConfig(), sinks...).Run)
+	lc.Go("webhook-dispatcher", webhooks.NewDispatcher(db, log, webhooks.DefaultConfig()).Run)
 
-	close(done)
-	wg.Wait()
+	elector := leader.New(func(ctx context.Context) (leader.Session, bool, error) {
+		lock, ok, err := pg.TryLock(ctx, postgresql.LeaderLockID)
+		if !ok {
+			return nil, false, err
+		}
+		return lock, true, nil
+	}, log, leader.DefaultConfig())
+	lc.Go("scheduler", scheduler.New(log, elector, maintenanceJobs(db)...).Run)
+	log.Info("workers started")
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Error("error", err)
+// newHTTPServer returns a server with the configured timeouts, which
+// disconnect clients that send or read too slowly.
+func newHTTPServer(cfg config.Config, addr string, handler http.Handler, tlsCfg *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsCfg,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
 	}
+}
 
-	log.Info("stopping server")
+// maxHeaderBytes leaves room for a few cookies and a bearer token.
+const maxHeaderBytes = 64 << 10
+
+// grpcServerOptions serves gRPC over TLS if it is configured, like the
+// HTTP API.
+func grpcServerOptions(tlsCfg *tls.Config) []grpc.ServerOption {
+	if tlsCfg == nil {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsCfg.Clone()))}
+}
+
+// serveHTTP runs srv until it is stopped, over TLS if it has a
+// configuration for it.
+func serveHTTP(srv *http.Server) func(context.Context) error {
+	return func(context.Context) error {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// stopHTTP lets the requests in flight finish and drops those left at the
+// deadline.
+func stopHTTP(srv *http.Server) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			return err
+		}
+		return nil
+	}
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	pb "github.com/kholodmv/gophermart/api/gophermart/v1"
+	"github.com/kholodmv/gophermart/internal/config"
+	grpcserver "github.com/kholodmv/gophermart/internal/grpc-server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// dribble writes data a byte at a time, the way a slowloris client keeps
+// connections open, until the server hangs up.
+func dribble(conn net.Conn, data string) {
+	for i := 0; ; i = (i + 1) % len(data) {
+		if _, err := conn.Write([]byte{data[i]}); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hangsUp reports how long it took the server to close conn, failing
+// after a generous limit.
+func hangsUp(t *testing.T, conn net.Conn) time.Duration {
+	t.Helper()
+	start := time.Now()
+	require.NoError(t, conn.SetReadDeadline(start.Add(5*time.Second)))
+	_, err := io.Copy(io.Discard, conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("the server kept the connection open")
+	}
+	return time.Since(start)
+}
+
+func TestSlowClientsAreDisconnected(t *testing.T) {
+	cfg := config.Default()
+	cfg.ReadHeaderTimeout = 100 * time.Millisecond
+	cfg.ReadTimeout = 300 * time.Millisecond
+	cfg.IdleTimeout = 200 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		request string
+		// slow is sent after the request, byte by byte.
+		slow    string
+		timeout time.Duration
+	}{
+		{
+			name:    "Slow headers",
+			request: "GET / HTTP/1.1\r\nHost: gophermart\r\n",
+			slow:    "X-Padding: slow\r\n",
+			timeout: cfg.ReadHeaderTimeout,
+		},
+		{
+			name:    "Slow body",
+			request: "POST / HTTP/1.1\r\nHost: gophermart\r\nContent-Length: 1000\r\n\r\n",
+			slow:    "{",
+			timeout: cfg.ReadTimeout,
+		},
+		{
+			name:    "Idle keep-alive",
+			request: "GET / HTTP/1.1\r\nHost: gophermart\r\n\r\n",
+			timeout: cfg.IdleTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			srv := newHTTPServer(cfg, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+			}), nil)
+			go srv.Serve(l)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = io.WriteString(conn, test.request)
+			require.NoError(t, err)
+			if test.slow != "" {
+				go dribble(conn, test.slow)
+			}
+
+			elapsed := hangsUp(t, conn)
+
+			assert.GreaterOrEqual(t, elapsed, test.timeout/2)
+			assert.Less(t, elapsed, test.timeout+time.Second)
+		})
+	}
+}
+
+// selfSigned returns a TLS configuration serving a certificate for
+// localhost and a pool trusting it.
+func selfSigned(t *testing.T) (*tls.Config, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, pool
+}
+
+func TestGRPCServesTLS(t *testing.T) {
+	tlsCfg, pool := selfSigned(t)
+	srv := grpcserver.New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil).GRPCServer(grpcServerOptions(tlsCfg)...)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	call := func(creds credentials.TransportCredentials) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		defer conn.Close()
+		// The invalid request is refused by the server, after the handshake.
+		_, err = pb.NewGophermartClient(conn).Register(ctx, &pb.RegisterRequest{})
+		return err
+	}
+
+	err = call(credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "localhost"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+
+	err = call(insecure.NewCredentials())
+	assert.Equal(t, codes.Unavailable, status.Code(err), "plaintext is refused")
+}
+
+func TestStartAPIFailsWithoutDenylist(t *testing.T) {
+	cfg := config.Default()
+	cfg.PasswordDenylistFile = t.TempDir() + "/missing.txt"
+
+	err := startAPI(nil, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil, nil, nil, nil, nil)
+	assert.ErrorContains(t, err, "password denylist")
+}
@@ -1,22 +1,38 @@
 package auth
 
 import (
+	"crypto/rand"
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/kholodmv/gophermart/internal/models/user"
 	"time"
 )
 
-var SecretKey = []byte("secret-key")
+// SecretKey signs session tokens. It is set from the configuration on
+// start; until then it is random, so tokens don't outlive the process.
+var SecretKey = newSecretKey()
+
+func newSecretKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
 
 type Claims struct {
-	Login string `json:"login"`
+	Login   string `json:"login"`
+	Version int    `json:"ver"`
+	Role    string `json:"role"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(login string) (string, error) {
+func GenerateToken(u user.User) (string, error) {
 	expirationTime := time.Now().Add(5 * time.Minute)
 	claims := &Claims{
-		Login: login,
+		Login:   u.Login,
+		Version: u.TokenVersion,
+		Role:    u.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -26,7 +42,7 @@ func GenerateToken(login string) (string, error) {
 	return tokenString, err
 }
 
-func GetLogin(tokenString string) (string, error) {
+func ParseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims,
 		func(t *jwt.Token) (interface{}, error) {
@@ -36,12 +52,12 @@ func GetLogin(tokenString string) (string, error) {
 			return []byte(SecretKey), nil
 		})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", errors.New("token is not valid")
+		return nil, errors.New("token is not valid")
 	}
 
-	return claims.Login, nil
+	return claims, nil
 }
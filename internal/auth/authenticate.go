@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/kholodmv/gophermart/internal/storage"
+)
+
+var (
+	ErrNoCredentials  = errors.New("no credentials")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrSessionRevoked = errors.New("session has been revoked")
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Login string
+	Role  string
+	// Scopes are set for API keys only; nil means a session, which is
+	// not limited by scopes.
+	Scopes []string
+}
+
+func (p *Principal) IsAPIKey() bool {
+	return p.Scopes != nil
+}
+
+// HasScope reports whether the caller may use the scope.
+func (p *Principal) HasScope(scope string) bool {
+	if !p.IsAPIKey() {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate resolves either an API key or a token, the key taking
+// precedence, and is shared by all transports.
+//
+// A token is valid only while its version matches the user's current one,
+// so bumping the version revokes older sessions. The role is taken from
+// storage rather than from the token so that a demotion takes effect
+// immediately.
+func Authenticate(ctx context.Context, db storage.Storage, token string, apiKey string) (*Principal, error) {
+	if apiKey != "" {
+		k, err := db.GetAPIKeyByHash(ctx, HashToken(apiKey))
+		if err != nil {
+			return nil, ErrInvalidAPIKey
+		}
+		u, err := db.GetUser(ctx, k.User)
+		if err != nil {
+			return nil, ErrInvalidAPIKey
+		}
+		// Usage tracking must not fail the request.
+		_ = db.TouchAPIKey(ctx, k.ID)
+		return &Principal{Login: u.Login, Role: u.Role, Scopes: append([]string{}, k.Scopes...)}, nil
+	}
+
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := db.GetUser(ctx, claims.Login)
+	if err != nil || u.TokenVersion != claims.Version {
+		return nil, ErrSessionRevoked
+	}
+	return &Principal{Login: u.Login, Role: u.Role}, nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/lockout"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/totp"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username/password pair")
+	ErrOTPRequired        = errors.New("two-factor code required")
+	ErrInvalidOTP         = errors.New("invalid two-factor code")
+)
+
+// LockedError is returned while failed attempts block the login.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// Login checks the password and, if enabled, the second factor of a
+// login attempt from the address. Failures count towards the lockout;
+// the attempt is counted before the password is checked, so parallel
+// guesses are throttled like sequential ones.
+func Login(ctx context.Context, db storage.Storage, guard *lockout.Guard, log *slog.Logger, credentials user.User, ip string) (*user.User, error) {
+	wait, err := guard.Begin(ctx, credentials.Login, ip)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &LockedError{RetryAfter: wait}
+	}
+
+	u, err := db.GetUser(ctx, credentials.Login)
+	if err == nil {
+		err = ComparePassword(u.HashPassword, credentials.Password)
+	}
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	t, err := EnabledTOTP(ctx, db, u.Login)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		if credentials.OTP == "" {
+			// Asking for the code is not a failure.
+			if err = guard.Cancel(ctx, credentials.Login, ip); err != nil {
+				log.Error("error cancel login attempt", sl.Err(err))
+			}
+			return nil, ErrOTPRequired
+		}
+		ok, err := VerifySecondFactor(ctx, db, t, credentials.OTP)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrInvalidOTP
+		}
+	}
+
+	if err = guard.Succeed(ctx, credentials.Login, ip); err != nil {
+		log.Error("error reset failed login attempts", sl.Err(err))
+	}
+	return u, nil
+}
+
+// EnabledTOTP returns the user's TOTP settings if two-factor
+// authentication is enabled and nil otherwise.
+func EnabledTOTP(ctx context.Context, db storage.Storage, login string) (*user.TOTP, error) {
+	t, err := db.GetTOTP(ctx, login)
+	switch {
+	case errors.Is(err, postgresql.ErrorTOTPNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case !t.Enabled:
+		return nil, nil
+	}
+	return t, nil
+}
+
+// VerifySecondFactor accepts either a current TOTP code or one of the
+// unused recovery codes, which is consumed on success.
+func VerifySecondFactor(ctx context.Context, db storage.Storage, t *user.TOTP, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	ok, err := VerifyTOTP(ctx, db, t, code)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	err = db.UseRecoveryCode(ctx, t.Login, HashToken(totp.NormalizeRecoveryCode(code)))
+	switch {
+	case errors.Is(err, postgresql.ErrorRecoveryCode):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// VerifyTOTP accepts a current TOTP code and records its time step, so
+// that neither it nor an earlier code can be used again.
+func VerifyTOTP(ctx context.Context, db storage.Storage, t *user.TOTP, code string) (bool, error) {
+	step, ok := totp.Match(t.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err := db.UseTOTPStep(ctx, t.Login, step)
+	switch {
+	case errors.Is(err, postgresql.ErrorTOTPReplay):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckSecondFactor runs verify for the login from the address and
+// returns ErrInvalidOTP if it rejects the code. Failures count towards
+// the lockout like failed logins, so a stolen session can't be used to
+// guess codes.
+func CheckSecondFactor(ctx context.Context, guard *lockout.Guard, log *slog.Logger, login string, ip string, verify func() (bool, error)) error {
+	wait, err := guard.Begin(ctx, login, ip)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &LockedError{RetryAfter: wait}
+	}
+
+	ok, err := verify()
+	if err != nil {
+		// Not the client's fault, so it doesn't count.
+		if cerr := guard.Cancel(ctx, login, ip); cerr != nil {
+			log.Error("error cancel second factor attempt", sl.Err(cerr))
+		}
+		return err
+	}
+	if !ok {
+		return ErrInvalidOTP
+	}
+
+	if err = guard.Succeed(ctx, login, ip); err != nil {
+		log.Error("error reset failed login attempts", sl.Err(err))
+	}
+	return nil
+}
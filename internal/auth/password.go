@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"encoding/hex"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword returns the hex-encoded bcrypt hash stored for users.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+func ComparePassword(hash string, password string) error {
+	decHash, _ := hex.DecodeString(hash)
+	return bcrypt.CompareHashAndPassword(decHash, []byte(password))
+}
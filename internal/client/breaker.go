@@ -0,0 +1,80 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// breaker stops polling the accrual system after consecutive failures,
+// and lets a single request through once the cooldown has passed to see
+// whether it is back.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request may be sent.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state() {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return false
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+func (b *breaker) state() string {
+	if b.failures < b.threshold {
+		return CircuitClosed
+	}
+	if b.now().Sub(b.openedAt) < b.cooldown {
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.True(t, b.allow())
+
+	b.failure()
+	assert.Equal(t, CircuitOpen, b.State())
+	assert.False(t, b.allow())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.True(t, b.allow(), "a probe is let through")
+	assert.False(t, b.allow(), "only one probe at a time")
+
+	b.failure()
+	assert.Equal(t, CircuitOpen, b.State(), "a failed probe opens the circuit again")
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	b.success()
+	assert.Equal(t, CircuitClosed, b.State())
+	assert.True(t, b.allow())
+}
@@ -5,12 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
+	"github.com/kholodmv/gophermart/internal/lifecycle"
 	"github.com/kholodmv/gophermart/internal/models/order"
 	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 	"golang.org/x/sync/errgroup"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,88 +27,227 @@ type Client struct {
 	client   *resty.Client
 	address  string
 	db       storage.Storage
-	interval int
-	log      *slog.Logger
+	interval atomic.Int64
+	// reset wakes up ReportOrders when the interval changes.
+	reset   chan struct{}
+	log     *slog.Logger
+	metrics Metrics
+	breaker *breaker
 }
 
-func New(address string, db storage.Storage, interval int, log *slog.Logger) *Client {
-	return &Client{
-		client:   resty.New().SetDebug(true),
-		address:  address,
-		db:       db,
-		interval: interval,
-		log:      log,
+// Metrics records how the accrual system answers and how many orders
+// wait for it.
+type Metrics interface {
+	ObserveAccrualRequest(status int, err error)
+	SetPollQueue(n int)
+}
+
+type Option func(c *Client)
+
+func WithMetrics(m Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// WithCircuitBreaker stops requests to the accrual system for cooldown
+// after threshold consecutive failures.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breaker = newBreaker(threshold, cooldown)
+	}
+}
+
+func New(address string, db storage.Storage, interval time.Duration, log *slog.Logger, opts ...Option) *Client {
+	c := &Client{
+		client:  resty.New().SetDebug(true).OnBeforeRequest(injectTraceContext),
+		address: address,
+		db:      db,
+		reset:   make(chan struct{}, 1),
+		log:     log,
+		breaker: newBreaker(5, 30*time.Second),
+	}
+	c.interval.Store(int64(interval))
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetInterval changes how often orders are polled, starting with the
+// next poll.
+func (c *Client) SetInterval(interval time.Duration) {
+	if time.Duration(c.interval.Swap(int64(interval))) == interval {
+		return
+	}
+	select {
+	case c.reset <- struct{}{}:
+	default:
 	}
 }
 
 var (
 	ErrorOrderNotRegistered = errors.New(`order isn't registered in system`)
 	ErrorInvalidStatusCode  = errors.New("invalid status code")
+	ErrorCircuitOpen        = errors.New("accrual system is failing, requests are paused")
+	ErrorPollsAbandoned     = fmt.Errorf("order polls %w", lifecycle.ErrAbandoned)
 )
 
-func (c *Client) ReportOrders(done <-chan struct{}) {
-	orders := make(chan order.Number)
-	go func() {
-		t := time.NewTicker(time.Duration(c.interval) * time.Second)
-		for {
-			select {
-			case <-done:
-				close(orders)
-				return
-			case <-t.C:
-				ordersStatus, err := c.db.GetOrderWithStatuses(context.Background(), order.StatusProcessing, order.StatusNew)
-				if err != nil {
-					c.log.Error("there are no orders with status PROCESSING or status NEW in the database", err)
-					continue
-				}
-
-				for _, number := range ordersStatus {
-					orders <- number
-				}
+// CircuitState is closed while the accrual system answers, open while
+// requests are paused and half-open while one request checks whether it
+// is back.
+func (c *Client) CircuitState() string {
+	return c.breaker.State()
+}
+
+// Check fails unless the circuit is closed.
+func (c *Client) Check(context.Context) error {
+	if state := c.CircuitState(); state != CircuitClosed {
+		return fmt.Errorf("accrual circuit is %s", state)
+	}
+	return nil
+}
+
+// ReportOrders polls the orders waiting for an accrual every interval
+// until ctx is cancelled. Polls in flight are cancelled along with it
+// and reported as abandoned, see lifecycle.ErrAbandoned; their orders are
+// picked up on the next run.
+func (c *Client) ReportOrders(ctx context.Context) error {
+	t := time.NewTicker(time.Duration(c.interval.Load()))
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.reset:
+			t.Reset(time.Duration(c.interval.Load()))
+		case <-t.C:
+			if abandoned := c.pollOrders(ctx); abandoned > 0 {
+				return fmt.Errorf("%w: %d", ErrorPollsAbandoned, abandoned)
 			}
 		}
-	}()
-	g, _ := errgroup.WithContext(context.Background())
-	for n := range orders {
-		nn := n
-		o := order.Order{
-			Number: nn,
+	}
+}
+
+// pollOrders polls a batch of orders concurrently and returns how many
+// polls were cut short by ctx.
+func (c *Client) pollOrders(ctx context.Context) int {
+	numbers, err := c.db.GetOrderWithStatuses(ctx, order.StatusProcessing, order.StatusNew)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.log.Error("there are no orders with status PROCESSING or status NEW in the database", err)
 		}
-		g.Go(func() error {
+		return 0
+	}
+	if c.metrics != nil {
+		c.metrics.SetPollQueue(len(numbers))
+	}
 
-			a, err := c.GetStatusOrderFromAccrualSystem(nn)
-			switch err {
-			case nil:
-				o.Status = accrualToOrderStatus(a.Status)
-				o.Accrual = a.Accrual
-			case ErrorOrderNotRegistered:
-				o.Status = order.StatusInvalid
-			default:
-				c.log.Error("default error - ", err)
-				return err
-			}
-			err = c.db.UpdateOrder(context.Background(), o)
-			if err != nil {
-				c.log.Error("can not update order in database", err)
+	var abandoned atomic.Int32
+	g := &errgroup.Group{}
+	for _, number := range numbers {
+		number := number
+		g.Go(func() error {
+			if _, err := c.PollOrder(ctx, number); err != nil && ctx.Err() != nil {
+				abandoned.Add(1)
 			}
-			return err
+			return nil
 		})
 	}
-
 	g.Wait()
+	return int(abandoned.Load())
 }
 
-func (c *Client) GetStatusOrderFromAccrualSystem(number order.Number) (*Accrual, error) {
+// PollOrder asks the accrual system about the order and stores the result.
+func (c *Client) PollOrder(ctx context.Context, number order.Number) (*order.Order, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.PollOrder",
+		trace.WithAttributes(attribute.String("order.number", string(number))),
+	)
+	defer span.End()
+
+	o, err := c.CheckOrder(ctx, number)
+	if err != nil {
+		if err != ErrorCircuitOpen && ctx.Err() == nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("order.status", string(o.Status)))
+
+	err = c.db.UpdateOrder(ctx, *o)
+	if err != nil {
+		c.log.ErrorContext(ctx, "can not update order in database", err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return o, nil
+}
+
+// CheckOrder asks the accrual system about the order without storing the
+// result.
+func (c *Client) CheckOrder(ctx context.Context, number order.Number) (*order.Order, error) {
+	o := order.Order{
+		Number: number,
+	}
+
+	a, err := c.GetStatusOrderFromAccrualSystem(ctx, number)
+	switch err {
+	case nil:
+		o.Status = accrualToOrderStatus(a.Status)
+		o.Accrual = a.Accrual
+	case ErrorOrderNotRegistered:
+		o.Status = order.StatusInvalid
+	case ErrorCircuitOpen:
+		return nil, err
+	default:
+		if ctx.Err() == nil {
+			c.log.ErrorContext(ctx, "default error - ", err)
+		}
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (c *Client) GetStatusOrderFromAccrualSystem(ctx context.Context, number order.Number) (*Accrual, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.GetOrder",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("order.number", string(number))),
+	)
+	defer span.End()
+
+	if !c.breaker.allow() {
+		span.SetStatus(codes.Error, ErrorCircuitOpen.Error())
+		return nil, ErrorCircuitOpen
+	}
+
 	endpoint := fmt.Sprintf("%s%s", c.address, APIGetAccrual)
 	a := &Accrual{}
 	resp, err := c.client.R().
+		SetContext(ctx).
 		SetPathParam("number", string(number)).
 		SetResult(a).
 		Get(endpoint)
+	if c.metrics != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode()
+		}
+		c.metrics.ObserveAccrualRequest(status, err)
+	}
+	// Rate limiting is not a failure, the system is up.
+	if err != nil || resp.StatusCode() >= http.StatusInternalServerError {
+		c.breaker.failure()
+	} else {
+		c.breaker.success()
+	}
 	if err != nil {
-		c.log.Error("error client response - ", err)
+		c.log.ErrorContext(ctx, "error client response - ", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode()))
 
 	switch resp.StatusCode() {
 	case http.StatusOK:
@@ -110,7 +258,13 @@ func (c *Client) GetStatusOrderFromAccrualSystem(number order.Number) (*Accrual,
 		if err != nil {
 			return nil, err
 		}
-		time.Sleep(time.Duration(retryAfter) * time.Second)
+		wait := time.NewTimer(time.Duration(retryAfter) * time.Second)
+		defer wait.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait.C:
+		}
 	case http.StatusNoContent:
 		c.log.Info("no content")
 		return nil, ErrorOrderNotRegistered
@@ -118,6 +272,13 @@ func (c *Client) GetStatusOrderFromAccrualSystem(number order.Number) (*Accrual,
 	return nil, ErrorInvalidStatusCode
 }
 
+// injectTraceContext lets the accrual system continue the trace of the
+// poll.
+func injectTraceContext(_ *resty.Client, r *resty.Request) error {
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	return nil
+}
+
 func accrualToOrderStatus(status string) order.Status {
 	switch status {
 	case StatusRegistered:
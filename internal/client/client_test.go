@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pollCounter has no orders to poll and counts how often it is asked.
+type pollCounter struct {
+	storage.Storage
+	polls atomic.Int32
+}
+
+func (s *pollCounter) GetOrderWithStatuses(context.Context, order.Status, order.Status) ([]order.Number, error) {
+	s.polls.Add(1)
+	return nil, nil
+}
+
+func TestSetInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := &pollCounter{}
+	c := New("http://accrual", db, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	go c.ReportOrders(ctx)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Zero(t, db.polls.Load())
+
+	c.SetInterval(time.Millisecond)
+	assert.Eventually(t, func() bool { return db.polls.Load() > 2 }, time.Second, time.Millisecond,
+		"the new interval applies without waiting for the old one")
+}
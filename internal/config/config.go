@@ -1,45 +1,374 @@
+// Package config loads the server configuration.
+//
+// Settings are taken, each overriding the previous, from the defaults, a
+// YAML or JSON file given by -config or CONFIG_FILE, environment variables
+// and command line flags. Durations are written as "1m30s"; the accrual
+// interval also takes whole seconds, as it used to. Load reports every
+// invalid setting at once.
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
 	"os"
 	"strconv"
+	"time"
+)
+
+// Roles a process can serve in. The API role serves HTTP and gRPC, the
+// worker role polls the accrual system and delivers events and webhooks.
+const (
+	RoleAPI    = "api"
+	RoleWorker = "worker"
+	RoleAll    = "all"
 )
 
 type Config struct {
-	RunAddress            string
-	DatabaseURI           string
-	AccrualSystemAddress  string
-	Env                   string
-	IntervalAccrualSystem int
+	// File is the config file read, if any.
+	File string `yaml:"-"`
+	// Role is api, worker or all.
+	Role       string `yaml:"role"`
+	RunAddress string `yaml:"run_address"`
+	// GRPCAddress is where the gRPC API listens, empty disables it.
+	GRPCAddress string `yaml:"grpc_address"`
+	// AdminAddress is where /metrics and the health endpoints are served,
+	// empty disables it.
+	AdminAddress string `yaml:"admin_address"`
+	// TLSCertFile and TLSKeyFile enable TLS and HTTP/2 on the HTTP and
+	// admin listeners. The files are read again when they change.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// TLSMinVersion is 1.2 or 1.3.
+	TLSMinVersion string `yaml:"tls_min_version"`
+	// AdminClientCAFile makes /metrics require a client certificate issued
+	// by one of its CAs; the health endpoints stay open for probes.
+	AdminClientCAFile    string `yaml:"admin_client_ca_file"`
+	DatabaseURI          string `yaml:"database_uri"`
+	AccrualSystemAddress string `yaml:"accrual_system_address"`
+	Env                  string `yaml:"env"`
+	// LogLevel is debug, info, warn or error; empty means the default of
+	// the environment.
+	LogLevel             string        `yaml:"log_level"`
+	AccrualInterval      time.Duration `yaml:"accrual_interval"`
+	ResetNotifyFile      string        `yaml:"reset_notify_file"`
+	LoginMaxAttempts     int           `yaml:"login_max_attempts"`
+	IPMaxAttempts        int           `yaml:"ip_max_attempts"`
+	LockoutDuration      time.Duration `yaml:"lockout_duration"`
+	PasswordMinLength    int           `yaml:"password_min_length"`
+	PasswordMinClasses   int           `yaml:"password_min_classes"`
+	PasswordDenylistFile string        `yaml:"password_denylist_file"`
+	WithdrawOTPThreshold float64       `yaml:"withdraw_otp_threshold"`
+	OIDCIssuer           string        `yaml:"oidc_issuer"`
+	OIDCClientID         string        `yaml:"oidc_client_id"`
+	OIDCClientSecret     string        `yaml:"oidc_client_secret"`
+	OIDCRedirectURL      string        `yaml:"oidc_redirect_url"`
+	// TokenSecret signs session tokens and login cookies. It is required
+	// outside development, where a random one is used if it is empty, so
+	// that sessions end on restart.
+	TokenSecret string `yaml:"token_secret"`
+	// EventsLog, EventsFile and EventsURL add sinks that domain events
+	// are published to besides the in-process bus.
+	EventsLog  bool   `yaml:"events_log"`
+	EventsFile string `yaml:"events_file"`
+	EventsURL  string `yaml:"events_url"`
+	// TraceExporter is none, stdout or otlp.
+	TraceExporter string `yaml:"trace_exporter"`
+	// TraceEndpoint is the host:port of the OTLP collector.
+	TraceEndpoint string `yaml:"trace_endpoint"`
+	TraceInsecure bool   `yaml:"trace_insecure"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout keep
+	// slow clients from holding connections of the HTTP listeners; zero
+	// disables a timeout, except for the header one. Order streams lift
+	// the read and write timeouts for themselves.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// MaxBodySize is the largest request body accepted, in bytes. Routes
+	// that take small bodies accept less.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// ShutdownTimeout limits how long requests in flight and workers are
+	// waited for on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ValidateResponses checks responses against the OpenAPI document and
+	// logs mismatches, meant for development and tests.
+	ValidateResponses bool `yaml:"validate_responses"`
+}
+
+func Default() Config {
+	return Config{
+		Role:               RoleAll,
+		RunAddress:         "localhost:8080",
+		GRPCAddress:        "localhost:3200",
+		AdminAddress:       "localhost:9090",
+		TLSMinVersion:      "1.2",
+		Env:                "dev",
+		AccrualInterval:    time.Second,
+		LoginMaxAttempts:   5,
+		IPMaxAttempts:      20,
+		LockoutDuration:    15 * time.Minute,
+		PasswordMinLength:  8,
+		PasswordMinClasses: 1,
+		TraceExporter:      "none",
+		TraceEndpoint:      "localhost:4317",
+		ReadHeaderTimeout:  5 * time.Second,
+		ReadTimeout:        30 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		IdleTimeout:        2 * time.Minute,
+		MaxBodySize:        1 << 20,
+		ShutdownTimeout:    30 * time.Second,
+	}
+}
+
+// Dev reports whether the process runs in a development environment,
+// where convenience may win over safety.
+func (c Config) Dev() bool {
+	return c.Env == "local" || c.Env == "dev"
+}
+
+// RunsAPI reports whether the process serves the HTTP and gRPC APIs.
+func (c Config) RunsAPI() bool {
+	return c.Role == RoleAPI || c.Role == RoleAll
 }
 
-func UseServerStartParams() Config {
-	var c Config
+// RunsWorker reports whether the process runs the background workers.
+func (c Config) RunsWorker() bool {
+	return c.Role == RoleWorker || c.Role == RoleAll
+}
 
-	flag.StringVar(&c.RunAddress, "a", "localhost:8080", "address and port to run server")
-	flag.StringVar(&c.DatabaseURI, "d", "", "connection string to postgres db")
-	flag.StringVar(&c.AccrualSystemAddress, "r", "", "billing system address")
-	flag.StringVar(&c.Env, "e", "dev", "environment")
-	flag.IntVar(&c.IntervalAccrualSystem, "i", 1, "interval for get accruals")
+// Load reads the configuration from the command line arguments, without
+// the program name and command, the environment and the config file. The
+// error lists every invalid setting, which the returned configuration
+// still holds; it is flag.ErrHelp when usage was asked for.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	// Flags are parsed first to find the file, but applied last.
+	parsed := Default()
+	fs := flag.NewFlagSet("gophermart", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML or JSON file to read the configuration from")
+	bind(fs, &parsed)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
 
-	flag.Parse()
+	c := Default()
 
-	if envRunAddr := os.Getenv("RUN_ADDRESS"); envRunAddr != "" {
-		c.RunAddress = envRunAddr
+	path, ok := lookupEnv("CONFIG_FILE")
+	if isSet(fs, "config") || !ok {
+		path = *configFile
 	}
-	if envDatabaseURI := os.Getenv("DATABASE_URI"); envDatabaseURI != "" {
-		c.DatabaseURI = envDatabaseURI
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return Config{}, err
+		}
+		c.File = path
 	}
-	if envAccrualSystemAddress := os.Getenv("ACCRUAL_SYSTEM_ADDRESS"); envAccrualSystemAddress != "" {
-		c.AccrualSystemAddress = envAccrualSystemAddress
+
+	e := &env{lookup: lookupEnv}
+	e.apply(&c)
+
+	// Flags bound to c default to what it holds, so only those given
+	// change it.
+	given := flag.NewFlagSet("", flag.ContinueOnError)
+	given.String("config", "", "")
+	bind(given, &c)
+	fs.Visit(func(f *flag.Flag) {
+		given.Set(f.Name, f.Value.String())
+	})
+
+	if err := errors.Join(append(e.errs, c.Validate())...); err != nil {
+		return c, err
 	}
-	if envEnv := os.Getenv("ENVIRONMENT"); envEnv != "" {
-		c.Env = envEnv
+	return c, nil
+}
+
+func bind(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Role, "role", c.Role, "what the process runs: api, worker or all")
+	fs.StringVar(&c.RunAddress, "a", c.RunAddress, "address and port to run server")
+	fs.StringVar(&c.GRPCAddress, "grpc-address", c.GRPCAddress, "address and port of the gRPC API, empty disables it")
+	fs.StringVar(&c.AdminAddress, "admin-address", c.AdminAddress, "address and port of the admin listener serving metrics and health, empty disables it")
+	fs.StringVar(&c.TLSCertFile, "tls-cert", c.TLSCertFile, "certificate file, enables TLS with -tls-key")
+	fs.StringVar(&c.TLSKeyFile, "tls-key", c.TLSKeyFile, "private key file of the certificate")
+	fs.StringVar(&c.TLSMinVersion, "tls-min-version", c.TLSMinVersion, "minimal TLS version: 1.2 or 1.3")
+	fs.StringVar(&c.AdminClientCAFile, "admin-client-ca", c.AdminClientCAFile, "CA file to verify client certificates for /metrics against")
+	fs.StringVar(&c.DatabaseURI, "d", c.DatabaseURI, "connection string to postgres db")
+	fs.StringVar(&c.AccrualSystemAddress, "r", c.AccrualSystemAddress, "billing system address")
+	fs.StringVar(&c.Env, "e", c.Env, "environment")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error, the default depends on the environment")
+	fs.Var((*interval)(&c.AccrualInterval), "i", "interval for get accruals, in seconds or as a duration")
+	fs.StringVar(&c.ResetNotifyFile, "reset-notify-file", c.ResetNotifyFile, "file to write password reset notifications to; if empty, the log is used in dev and nothing elsewhere")
+
+	fs.IntVar(&c.LoginMaxAttempts, "login-max-attempts", c.LoginMaxAttempts, "failed logins before a login is locked")
+	fs.IntVar(&c.IPMaxAttempts, "ip-max-attempts", c.IPMaxAttempts, "failed logins before a client address is locked")
+	fs.DurationVar(&c.LockoutDuration, "lockout-duration", c.LockoutDuration, "how long a lock lasts")
+	fs.IntVar(&c.PasswordMinLength, "password-min-length", c.PasswordMinLength, "minimal password length")
+	fs.IntVar(&c.PasswordMinClasses, "password-min-classes", c.PasswordMinClasses, "minimal number of character classes in a password")
+	fs.StringVar(&c.PasswordDenylistFile, "password-denylist", c.PasswordDenylistFile, "file with additional forbidden passwords, one per line")
+	fs.Float64Var(&c.WithdrawOTPThreshold, "withdraw-otp-threshold", c.WithdrawOTPThreshold, "withdrawal sum above which a two-factor code is required, 0 disables")
+	fs.StringVar(&c.OIDCIssuer, "oidc-issuer", c.OIDCIssuer, "OpenID Connect issuer URL, oidc login is disabled if empty")
+	fs.StringVar(&c.OIDCClientID, "oidc-client-id", c.OIDCClientID, "OpenID Connect client id")
+	fs.StringVar(&c.OIDCClientSecret, "oidc-client-secret", c.OIDCClientSecret, "OpenID Connect client secret")
+	fs.StringVar(&c.OIDCRedirectURL, "oidc-redirect-url", c.OIDCRedirectURL, "OpenID Connect redirect URL pointing to /api/user/oidc/callback")
+	fs.StringVar(&c.TokenSecret, "token-secret", c.TokenSecret, "secret of at least 32 bytes that signs session tokens, required outside dev")
+	fs.BoolVar(&c.EventsLog, "events-log", c.EventsLog, "write domain events to the log")
+	fs.StringVar(&c.EventsFile, "events-file", c.EventsFile, "file to append domain events to as JSON lines")
+	fs.StringVar(&c.EventsURL, "events-url", c.EventsURL, "URL to post domain events to")
+	fs.StringVar(&c.TraceExporter, "trace-exporter", c.TraceExporter, "where to export traces: none, stdout or otlp")
+	fs.StringVar(&c.TraceEndpoint, "trace-endpoint", c.TraceEndpoint, "host and port of the OTLP collector")
+	fs.BoolVar(&c.TraceInsecure, "trace-insecure", c.TraceInsecure, "connect to the OTLP collector without TLS")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", c.ReadHeaderTimeout, "how long a client may take to send the request headers")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "how long a client may take to send a request, 0 disables")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "how long writing a response may take, 0 disables")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "how long an idle keep-alive connection is kept, 0 disables")
+	fs.Int64Var(&c.MaxBodySize, "max-body-size", c.MaxBodySize, "largest request body accepted, in bytes")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long to wait for requests and workers on shutdown")
+	fs.BoolVar(&c.ValidateResponses, "validate-responses", c.ValidateResponses, "log responses that don't match the OpenAPI document")
+}
+
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// readFile applies a YAML or JSON file, which is a subset of YAML.
+// Unknown keys are errors, so that typos don't go unnoticed.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
 	}
-	if envIntervalAccrualSystem := os.Getenv("ACCRUAL_INTERVAL"); envIntervalAccrualSystem != "" {
-		c.IntervalAccrualSystem, _ = strconv.Atoi(envIntervalAccrualSystem)
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err = dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
 	}
+	return nil
+}
+
+// env applies environment variables, collecting those that don't parse.
+// Empty variables are ignored, except for the addresses empty disables.
+type env struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *env) apply(c *Config) {
+	e.string("ROLE", &c.Role)
+	e.string("RUN_ADDRESS", &c.RunAddress)
+	e.optional("GRPC_ADDRESS", &c.GRPCAddress)
+	e.optional("ADMIN_ADDRESS", &c.AdminAddress)
+	e.string("TLS_CERT_FILE", &c.TLSCertFile)
+	e.string("TLS_KEY_FILE", &c.TLSKeyFile)
+	e.string("TLS_MIN_VERSION", &c.TLSMinVersion)
+	e.string("ADMIN_CLIENT_CA_FILE", &c.AdminClientCAFile)
+	e.string("DATABASE_URI", &c.DatabaseURI)
+	e.string("ACCRUAL_SYSTEM_ADDRESS", &c.AccrualSystemAddress)
+	e.string("ENVIRONMENT", &c.Env)
+	e.string("LOG_LEVEL", &c.LogLevel)
+	e.parse("ACCRUAL_INTERVAL", (*interval)(&c.AccrualInterval).Set)
+	e.string("RESET_NOTIFY_FILE", &c.ResetNotifyFile)
+	e.int("LOGIN_MAX_ATTEMPTS", &c.LoginMaxAttempts)
+	e.int("IP_MAX_ATTEMPTS", &c.IPMaxAttempts)
+	e.duration("LOCKOUT_DURATION", &c.LockoutDuration)
+	e.int("PASSWORD_MIN_LENGTH", &c.PasswordMinLength)
+	e.int("PASSWORD_MIN_CLASSES", &c.PasswordMinClasses)
+	e.string("PASSWORD_DENYLIST_FILE", &c.PasswordDenylistFile)
+	e.parse("WITHDRAW_OTP_THRESHOLD", func(v string) (err error) {
+		c.WithdrawOTPThreshold, err = strconv.ParseFloat(v, 64)
+		return err
+	})
+	e.string("OIDC_ISSUER", &c.OIDCIssuer)
+	e.string("OIDC_CLIENT_ID", &c.OIDCClientID)
+	e.string("OIDC_CLIENT_SECRET", &c.OIDCClientSecret)
+	e.string("OIDC_REDIRECT_URL", &c.OIDCRedirectURL)
+	e.string("TOKEN_SECRET", &c.TokenSecret)
+	e.bool("EVENTS_LOG", &c.EventsLog)
+	e.string("EVENTS_FILE", &c.EventsFile)
+	e.string("EVENTS_URL", &c.EventsURL)
+	e.string("TRACE_EXPORTER", &c.TraceExporter)
+	e.string("TRACE_ENDPOINT", &c.TraceEndpoint)
+	e.bool("TRACE_INSECURE", &c.TraceInsecure)
+	e.duration("READ_HEADER_TIMEOUT", &c.ReadHeaderTimeout)
+	e.duration("READ_TIMEOUT", &c.ReadTimeout)
+	e.duration("WRITE_TIMEOUT", &c.WriteTimeout)
+	e.duration("IDLE_TIMEOUT", &c.IdleTimeout)
+	e.parse("MAX_BODY_SIZE", func(v string) (err error) {
+		c.MaxBodySize, err = strconv.ParseInt(v, 10, 64)
+		return err
+	})
+	e.duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	e.bool("VALIDATE_RESPONSES", &c.ValidateResponses)
+}
+
+func (e *env) parse(name string, set func(string) error) {
+	v, ok := e.lookup(name)
+	if !ok || v == "" {
+		return
+	}
+	if err := set(v); err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid value %q", name, v))
+	}
+}
+
+func (e *env) string(name string, dst *string) {
+	e.parse(name, func(v string) error {
+		*dst = v
+		return nil
+	})
+}
+
+func (e *env) optional(name string, dst *string) {
+	if v, ok := e.lookup(name); ok {
+		*dst = v
+	}
+}
+
+func (e *env) int(name string, dst *int) {
+	e.parse(name, func(v string) (err error) {
+		*dst, err = strconv.Atoi(v)
+		return err
+	})
+}
+
+func (e *env) bool(name string, dst *bool) {
+	e.parse(name, func(v string) (err error) {
+		*dst, err = strconv.ParseBool(v)
+		return err
+	})
+}
+
+func (e *env) duration(name string, dst *time.Duration) {
+	e.parse(name, func(v string) (err error) {
+		*dst, err = time.ParseDuration(v)
+		return err
+	})
+}
+
+// interval is a duration that may be given in whole seconds.
+type interval time.Duration
+
+func (i *interval) Set(v string) error {
+	if n, err := strconv.Atoi(v); err == nil {
+		*i = interval(time.Duration(n) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*i = interval(d)
+	return nil
+}
 
-	return c
+func (i *interval) String() string {
+	return time.Duration(*i).String()
 }
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/logger"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"golang.org/x/exp/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// Runtime holds the settings that are applied without a restart.
+type Runtime struct {
+	LogLevel         slog.Level
+	AccrualInterval  time.Duration
+	LoginMaxAttempts int
+	IPMaxAttempts    int
+	LockoutDuration  time.Duration
+}
+
+// runtimeKeys are the file keys of the runtime settings.
+var runtimeKeys = map[string]bool{
+	"log_level":          true,
+	"accrual_interval":   true,
+	"login_max_attempts": true,
+	"ip_max_attempts":    true,
+	"lockout_duration":   true,
+}
+
+func (c Config) Runtime() Runtime {
+	level := logger.DefaultLevel(c.Env)
+	if c.LogLevel != "" {
+		// Validated on load.
+		level.UnmarshalText([]byte(c.LogLevel))
+	}
+	return Runtime{
+		LogLevel:         level,
+		AccrualInterval:  c.AccrualInterval,
+		LoginMaxAttempts: c.LoginMaxAttempts,
+		IPMaxAttempts:    c.IPMaxAttempts,
+		LockoutDuration:  c.LockoutDuration,
+	}
+}
+
+// withRuntime returns c with the runtime settings of next.
+func (c Config) withRuntime(next Config) Config {
+	c.LogLevel = next.LogLevel
+	c.AccrualInterval = next.AccrualInterval
+	c.LoginMaxAttempts = next.LoginMaxAttempts
+	c.IPMaxAttempts = next.IPMaxAttempts
+	c.LockoutDuration = next.LockoutDuration
+	return c
+}
+
+// changed returns the file keys of the settings that differ.
+func changed(a, b Config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var keys []string
+	for i := 0; i < va.NumField(); i++ {
+		key := va.Type().Field(i).Tag.Get("yaml")
+		if key == "-" {
+			continue
+		}
+		if va.Field(i).Interface() != vb.Field(i).Interface() {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// filePollInterval is how often the config file is checked for changes.
+const filePollInterval = 5 * time.Second
+
+// Reloader loads the configuration again on SIGHUP or when the config
+// file changes and applies the runtime settings. A configuration that
+// doesn't load or validate is rejected as a whole, and so the process
+// keeps running with what it has.
+type Reloader struct {
+	current Config
+	load    func() (Config, error)
+	apply   func(Runtime)
+	log     *slog.Logger
+
+	pollInterval time.Duration
+	modTime      time.Time
+}
+
+// NewReloader returns a reloader of the running configuration. Apply is
+// called with all runtime settings whenever any of them changes.
+func NewReloader(current Config, load func() (Config, error), apply func(Runtime), log *slog.Logger) *Reloader {
+	return &Reloader{
+		current:      current,
+		load:         load,
+		apply:        apply,
+		log:          log.With(slog.String("component", "config")),
+		pollInterval: filePollInterval,
+	}
+}
+
+// Run reloads until ctx is done.
+func (r *Reloader) Run(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if r.current.File != "" {
+		r.modTime = modTime(r.current.File)
+		t := time.NewTicker(r.pollInterval)
+		defer t.Stop()
+		poll = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			r.log.Info("reloading configuration on SIGHUP")
+			r.Reload()
+		case <-poll:
+			if m := modTime(r.current.File); !m.Equal(r.modTime) {
+				r.modTime = m
+				r.log.Info("reloading configuration, file changed", slog.String("file", r.current.File))
+				r.Reload()
+			}
+		}
+	}
+}
+
+// Reload loads the configuration and applies what changed.
+func (r *Reloader) Reload() error {
+	next, err := r.load()
+	if err != nil {
+		r.log.Error("configuration rejected", sl.Err(err))
+		return err
+	}
+
+	var applied, ignored []string
+	for _, key := range changed(r.current, next) {
+		if runtimeKeys[key] {
+			applied = append(applied, key)
+		} else {
+			ignored = append(ignored, key)
+		}
+	}
+	if len(ignored) > 0 {
+		r.log.Warn("configuration changes need a restart", slog.Any("settings", ignored))
+	}
+	if len(applied) == 0 {
+		r.log.Info("runtime configuration unchanged")
+		return nil
+	}
+
+	r.apply(next.Runtime())
+	r.current = r.current.withRuntime(next)
+	r.log.Info("configuration reloaded", slog.Any("settings", applied))
+	return nil
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type reloadTest struct {
+	file    string
+	current Config
+	logs    strings.Builder
+
+	mu      sync.Mutex
+	applied []Runtime
+}
+
+func newReloadTest(t *testing.T, content string) *reloadTest {
+	rt := &reloadTest{file: writeFile(t, "config.yaml", content)}
+	var err error
+	rt.current, err = Load(rt.args(), lookup(nil))
+	require.NoError(t, err)
+	return rt
+}
+
+func (rt *reloadTest) args() []string {
+	return []string{"-config", rt.file, "-grpc-address", ""}
+}
+
+func (rt *reloadTest) reloader() *Reloader {
+	r := NewReloader(rt.current, func() (Config, error) {
+		return Load(rt.args(), lookup(nil))
+	}, func(runtime Runtime) {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		rt.applied = append(rt.applied, runtime)
+	}, slog.New(slog.NewTextHandler(&rt.logs, nil)))
+	r.pollInterval = time.Millisecond
+	return r
+}
+
+func (rt *reloadTest) write(t *testing.T, content string) {
+	require.NoError(t, os.WriteFile(rt.file, []byte(content), 0o600))
+	// Make sure the modification time differs on coarse clocks.
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(rt.file, later, later))
+}
+
+func (rt *reloadTest) appliedRuntimes() []Runtime {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]Runtime(nil), rt.applied...)
+}
+
+func TestReload(t *testing.T) {
+	rt := newReloadTest(t, required+"log_level: info\n")
+	r := rt.reloader()
+
+	rt.write(t, required+"log_level: warn\naccrual_interval: 5s\nrun_address: localhost:9000\ngrpc_address: localhost:1\n")
+	require.NoError(t, r.Reload())
+
+	applied := rt.appliedRuntimes()
+	require.Len(t, applied, 1)
+	assert.Equal(t, slog.LevelWarn, applied[0].LogLevel)
+	assert.Equal(t, 5*time.Second, applied[0].AccrualInterval)
+	assert.Contains(t, rt.logs.String(), "configuration changes need a restart")
+	assert.Contains(t, rt.logs.String(), "run_address")
+	assert.NotContains(t, rt.logs.String(), "grpc_address", "the flag overrides the file")
+
+	require.NoError(t, r.Reload())
+	assert.Len(t, rt.appliedRuntimes(), 1, "nothing changed since")
+}
+
+func TestReloadRejectsInvalid(t *testing.T) {
+	rt := newReloadTest(t, required+"log_level: info\n")
+	r := rt.reloader()
+
+	rt.write(t, required+"log_level: verbose\naccrual_interval: 5s\n")
+
+	assert.Error(t, r.Reload())
+	assert.Empty(t, rt.appliedRuntimes(), "nothing of an invalid config is applied")
+	assert.Contains(t, rt.logs.String(), "configuration rejected")
+
+	rt.write(t, required+"accrual_interval: 5s\n")
+	require.NoError(t, r.Reload())
+	assert.Len(t, rt.appliedRuntimes(), 1)
+}
+
+func TestReloadTriggers(t *testing.T) {
+	rt := newReloadTest(t, required)
+	r := rt.reloader()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+	time.Sleep(10 * time.Millisecond)
+
+	rt.write(t, required+"login_max_attempts: 3\n")
+	assert.Eventually(t, func() bool { return len(rt.appliedRuntimes()) == 1 }, time.Second, time.Millisecond,
+		"a file change is picked up")
+
+	// The file is rewritten without touching the modification time.
+	info, err := os.Stat(rt.file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(rt.file, []byte(required+"login_max_attempts: 4\n"), 0o600))
+	require.NoError(t, os.Chtimes(rt.file, info.ModTime(), info.ModTime()))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	assert.Eventually(t, func() bool { return len(rt.appliedRuntimes()) == 2 }, time.Second, time.Millisecond,
+		"SIGHUP reloads")
+	assert.Equal(t, 4, rt.appliedRuntimes()[1].LoginMaxAttempts)
+}
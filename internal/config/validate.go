@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/tlsconfig"
+	"golang.org/x/exp/slog"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Validate reports every invalid setting.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Role == RoleAPI || c.Role == RoleWorker || c.Role == RoleAll,
+		"role: %q is not api, worker or all", c.Role)
+	check(c.DatabaseURI != "", "database_uri: is required")
+	if c.LogLevel != "" {
+		var level slog.Level
+		check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level: %q is not debug, info, warn or error", c.LogLevel)
+	}
+
+	if c.RunsAPI() {
+		check(validAddress(c.RunAddress), "run_address: %q is not a host:port", c.RunAddress)
+	}
+	if c.GRPCAddress != "" {
+		check(validAddress(c.GRPCAddress), "grpc_address: %q is not a host:port", c.GRPCAddress)
+	}
+	if c.AdminAddress != "" {
+		check(validAddress(c.AdminAddress), "admin_address: %q is not a host:port", c.AdminAddress)
+	}
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "tls_cert_file, tls_key_file: are required together")
+	_, ok := tlsconfig.Versions[c.TLSMinVersion]
+	check(ok, "tls_min_version: %q is not 1.2 or 1.3", c.TLSMinVersion)
+	if c.AdminClientCAFile != "" {
+		check(c.TLSCertFile != "", "admin_client_ca_file: needs tls_cert_file")
+	}
+	if c.RunsWorker() {
+		check(validURL(c.AccrualSystemAddress), "accrual_system_address: %q is not an http(s) URL", c.AccrualSystemAddress)
+	}
+	check(c.AccrualInterval >= minAccrualInterval, "accrual_interval: %s is shorter than %s", c.AccrualInterval, minAccrualInterval)
+
+	check(c.LoginMaxAttempts > 0, "login_max_attempts: must be positive")
+	check(c.IPMaxAttempts > 0, "ip_max_attempts: must be positive")
+	check(c.LockoutDuration > 0, "lockout_duration: must be positive")
+	check(c.PasswordMinLength > 0, "password_min_length: must be positive")
+	check(c.PasswordMinClasses >= 1 && c.PasswordMinClasses <= 4, "password_min_classes: must be between 1 and 4")
+	check(c.WithdrawOTPThreshold >= 0, "withdraw_otp_threshold: must not be negative")
+
+	if c.OIDCIssuer != "" {
+		check(validURL(c.OIDCIssuer), "oidc_issuer: %q is not an http(s) URL", c.OIDCIssuer)
+		check(c.OIDCClientID != "", "oidc_client_id: is required with oidc_issuer")
+		check(validURL(c.OIDCRedirectURL), "oidc_redirect_url: %q is not an http(s) URL", c.OIDCRedirectURL)
+	}
+	if c.RunsAPI() && !c.Dev() {
+		check(c.TokenSecret != "", "token_secret: is required outside dev")
+	}
+	if c.TokenSecret != "" {
+		check(len(c.TokenSecret) >= minTokenSecret, "token_secret: must be at least %d bytes", minTokenSecret)
+	}
+	if c.EventsURL != "" {
+		check(validURL(c.EventsURL), "events_url: is not an http(s) URL")
+	}
+
+	check(c.TraceExporter == "none" || c.TraceExporter == "stdout" || c.TraceExporter == "otlp",
+		"trace_exporter: %q is not none, stdout or otlp", c.TraceExporter)
+	if c.TraceExporter == "otlp" {
+		check(validAddress(c.TraceEndpoint), "trace_endpoint: %q is not a host:port", c.TraceEndpoint)
+	}
+	check(c.ReadHeaderTimeout > 0, "read_header_timeout: must be positive")
+	check(c.ReadTimeout >= 0, "read_timeout: must not be negative")
+	check(c.WriteTimeout >= 0, "write_timeout: must not be negative")
+	check(c.IdleTimeout >= 0, "idle_timeout: must not be negative")
+	check(c.MaxBodySize > 0, "max_body_size: must be positive")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout: must be positive")
+
+	return errors.Join(errs...)
+}
+
+// minTokenSecret is the size of the SHA-256 tokens are signed with.
+const minTokenSecret = 32
+
+// minAccrualInterval catches intervals given in the wrong unit.
+const minAccrualInterval = 100 * time.Millisecond
+
+func validAddress(address string) bool {
+	_, _, err := net.SplitHostPort(address)
+	return err == nil
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const redacted = "REDACTED"
+
+// dsnPassword matches the password of a key=value connection string.
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns a copy of the configuration that is safe to print.
+func (c Config) Redacted() Config {
+	c.DatabaseURI = redactURL(c.DatabaseURI)
+	c.DatabaseURI = dsnPassword.ReplaceAllString(c.DatabaseURI, "${1}"+redacted)
+	c.EventsURL = redactURL(c.EventsURL)
+	if c.OIDCClientSecret != "" {
+		c.OIDCClientSecret = redacted
+	}
+	if c.TokenSecret != "" {
+		c.TokenSecret = redacted
+	}
+	return c
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"sync"
+)
+
+// Handler processes an event within the application.
+type Handler func(ctx context.Context, e event.Event) error
+
+// Bus is a sink passing events to in-process handlers. An event is
+// published once every handler subscribed to its type has succeeded, so
+// handlers see events again after any of them fails.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []subscription
+}
+
+type subscription struct {
+	handler Handler
+	types   map[string]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe calls handler for events of the given types, or for every
+// event if none are given.
+func (b *Bus) Subscribe(handler Handler, types ...string) {
+	sub := subscription{handler: handler}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, sub)
+}
+
+func (b *Bus) Publish(ctx context.Context, e event.Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var errs []error
+	for _, sub := range b.handlers {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+		if err := sub.handler(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,30 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+
+	var all, processed []string
+	b.Subscribe(func(_ context.Context, e event.Event) error {
+		all = append(all, e.Type)
+		return nil
+	})
+	b.Subscribe(func(_ context.Context, e event.Event) error {
+		processed = append(processed, e.Type)
+		return errors.New("unavailable")
+	}, event.TypeOrderProcessed)
+
+	ctx := context.Background()
+	assert.NoError(t, b.Publish(ctx, event.Event{ID: 1, Type: event.TypeOrderAdded}))
+	assert.Error(t, b.Publish(ctx, event.Event{ID: 2, Type: event.TypeOrderProcessed}))
+
+	assert.Equal(t, []string{event.TypeOrderAdded, event.TypeOrderProcessed}, all)
+	assert.Equal(t, []string{event.TypeOrderProcessed}, processed)
+}
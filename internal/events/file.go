@@ -0,0 +1,38 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"os"
+	"sync"
+)
+
+// FileSink appends every event as a JSON line to a file.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Publish(_ context.Context, e event.Event) error {
+	const op = "events.FileSink.Publish"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	if err = json.NewEncoder(f).Encode(e); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
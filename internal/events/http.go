@@ -0,0 +1,51 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPSink posts every event as JSON to a URL. Any 2xx response counts as
+// published.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, e event.Event) error {
+	const op = "events.HTTPSink.Publish"
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(e.ID, 10))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected status %d", op, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package events
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"golang.org/x/exp/slog"
+)
+
+// LogSink writes events to the application log.
+type LogSink struct {
+	log *slog.Logger
+}
+
+func NewLogSink(log *slog.Logger) *LogSink {
+	return &LogSink{
+		log: log.With(slog.String("component", "events/log")),
+	}
+}
+
+func (s *LogSink) Publish(_ context.Context, e event.Event) error {
+	s.log.Info("event",
+		slog.Int64("id", e.ID),
+		slog.String("type", e.Type),
+		slog.String("user", e.User),
+		slog.String("data", string(e.Data)),
+	)
+	return nil
+}
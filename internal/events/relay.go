@@ -0,0 +1,163 @@
+// Package events publishes the domain events recorded in the outbox.
+//
+// Events are written to the outbox in the same transaction as the change
+// they describe. The Relay claims them in order and passes each to every
+// sink; an event is marked as published only once all sinks accepted it.
+// Delivery is at least once: an event is published again after a failure
+// or a crash, so sinks deduplicate by event ID. The events of a user are
+// published in the order they happened, since a failed event holds back
+// the later events of the same user until it goes through. An event that
+// keeps failing is parked after Config.MaxAttempts, letting the user's
+// later events go ahead.
+package events
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+type Config struct {
+	// Interval is how often the outbox is checked.
+	Interval  time.Duration
+	BatchSize int
+	// Lease is how long claimed events are reserved for publishing. It
+	// must outlast publishing a batch, or events are published twice.
+	Lease time.Duration
+	// MaxAttempts is after how many failures an event is parked.
+	MaxAttempts int
+	// The wait between attempts doubles from MinBackoff up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:    time.Second,
+		BatchSize:   100,
+		Lease:       5 * time.Minute,
+		MaxAttempts: 10,
+		MinBackoff:  time.Second,
+		MaxBackoff:  10 * time.Minute,
+	}
+}
+
+type Relay struct {
+	db    storage.Storage
+	log   *slog.Logger
+	sinks []Sink
+	cfg   Config
+}
+
+func NewRelay(db storage.Storage, log *slog.Logger, cfg Config, sinks ...Sink) *Relay {
+	return &Relay{
+		db:    db,
+		log:   log.With(slog.String("component", "events/relay")),
+		sinks: sinks,
+		cfg:   cfg,
+	}
+}
+
+// Run relays events until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) error {
+	t := time.NewTicker(r.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if _, err := r.RelayOnce(ctx); err != nil && ctx.Err() == nil {
+				r.log.Error("error relay events", sl.Err(err))
+			}
+		}
+	}
+}
+
+// RelayOnce publishes a batch of events and returns how many went
+// through. The batch is published outside of any transaction, and the
+// outcomes are recorded afterwards.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	claims, err := r.db.ClaimEvents(ctx, r.cfg.BatchSize, r.cfg.Lease)
+	if err != nil || len(claims) == 0 {
+		return 0, err
+	}
+
+	outcomes := r.publish(ctx, claims)
+	if err = r.db.RecordEvents(ctx, outcomes); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, o := range outcomes {
+		if o.Status == event.StatusPublished {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// publish returns the outcome of every claimed event. Once an event of a
+// user fails, the rest of the user's events are skipped until it goes
+// through or is parked.
+func (r *Relay) publish(ctx context.Context, claims []*event.Claim) []event.Outcome {
+	outcomes := make([]event.Outcome, 0, len(claims))
+	blocked := make(map[string]bool)
+	for _, c := range claims {
+		o := event.Outcome{EventID: c.ID, Status: event.StatusPublished}
+		if blocked[c.User] {
+			o.Status = event.StatusSkipped
+			outcomes = append(outcomes, o)
+			continue
+		}
+
+		if err := r.publishEvent(ctx, c.Event); err != nil {
+			o.Error = err.Error()
+			attempts := c.Attempts + 1
+			if attempts >= r.cfg.MaxAttempts {
+				o.Status = event.StatusParked
+				r.log.Error("event parked",
+					slog.Int64("id", c.ID),
+					slog.String("type", c.Type),
+					slog.Int("attempts", attempts),
+					sl.Err(err),
+				)
+			} else {
+				o.Status = event.StatusFailed
+				o.RetryIn = backoff(attempts, r.cfg.MinBackoff, r.cfg.MaxBackoff)
+				blocked[c.User] = true
+				r.log.Warn("error publish event",
+					slog.Int64("id", c.ID),
+					slog.String("type", c.Type),
+					sl.Err(err),
+				)
+			}
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
+
+func (r *Relay) publishEvent(ctx context.Context, e event.Event) error {
+	for _, s := range r.sinks {
+		if err := s.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff is the wait after the given number of failed attempts.
+func backoff(attempts int, min time.Duration, max time.Duration) time.Duration {
+	wait := min
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
@@ -0,0 +1,158 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"testing"
+	"time"
+)
+
+// outboxStorage keeps the unpublished events in order. Failed events are
+// due again right away.
+type outboxStorage struct {
+	storage.Storage
+
+	pending  []*event.Claim
+	parked   []int64
+	outcomes []event.Outcome
+}
+
+func newOutboxStorage(events ...event.Event) *outboxStorage {
+	s := &outboxStorage{}
+	for _, e := range events {
+		s.pending = append(s.pending, &event.Claim{Event: e})
+	}
+	return s
+}
+
+func (s *outboxStorage) ClaimEvents(_ context.Context, limit int, _ time.Duration) ([]*event.Claim, error) {
+	batch := s.pending
+	if len(batch) > limit {
+		batch = batch[:limit]
+	}
+	claims := make([]*event.Claim, 0, len(batch))
+	for _, c := range batch {
+		c := *c
+		claims = append(claims, &c)
+	}
+	return claims, nil
+}
+
+func (s *outboxStorage) RecordEvents(_ context.Context, outcomes []event.Outcome) error {
+	s.outcomes = append(s.outcomes, outcomes...)
+	done := make(map[int64]bool)
+	for _, o := range outcomes {
+		switch o.Status {
+		case event.StatusPublished:
+			done[o.EventID] = true
+		case event.StatusParked:
+			done[o.EventID] = true
+			s.parked = append(s.parked, o.EventID)
+		case event.StatusFailed:
+			for _, c := range s.pending {
+				if c.ID == o.EventID {
+					c.Attempts++
+				}
+			}
+		}
+	}
+
+	var rest []*event.Claim
+	for _, c := range s.pending {
+		if !done[c.ID] {
+			rest = append(rest, c)
+		}
+	}
+	s.pending = rest
+	return nil
+}
+
+// recordingSink fails the events in fail and records the rest.
+type recordingSink struct {
+	fail      map[int64]bool
+	published []int64
+}
+
+func (s *recordingSink) Publish(_ context.Context, e event.Event) error {
+	if s.fail[e.ID] {
+		return errors.New("unavailable")
+	}
+	s.published = append(s.published, e.ID)
+	return nil
+}
+
+func newRelay(db storage.Storage, cfg Config, sinks ...Sink) *Relay {
+	return NewRelay(db, slog.New(slog.NewTextHandler(io.Discard, nil)), cfg, sinks...)
+}
+
+func TestRelay(t *testing.T) {
+	db := newOutboxStorage(
+		event.Event{ID: 1, Type: event.TypeOrderAdded, User: "gopher"},
+		event.Event{ID: 2, Type: event.TypeOrderAdded, User: "alice"},
+		event.Event{ID: 3, Type: event.TypeOrderStatusChanged, User: "gopher"},
+		event.Event{ID: 4, Type: event.TypeOrderStatusChanged, User: "alice"},
+		event.Event{ID: 5, Type: event.TypeOrderProcessed, User: "gopher"},
+	)
+	first := &recordingSink{}
+	second := &recordingSink{fail: map[int64]bool{3: true}}
+	r := newRelay(db, DefaultConfig(), first, second)
+
+	n, err := r.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []int64{1, 2, 4}, second.published, "later events of the user wait for the failed one")
+	require.Len(t, db.pending, 2)
+	assert.Equal(t, int64(3), db.pending[0].ID)
+	assert.Equal(t, []event.Outcome{
+		{EventID: 1, Status: event.StatusPublished},
+		{EventID: 2, Status: event.StatusPublished},
+		{EventID: 3, Status: event.StatusFailed, Error: "unavailable", RetryIn: time.Second},
+		{EventID: 4, Status: event.StatusPublished},
+		{EventID: 5, Status: event.StatusSkipped},
+	}, db.outcomes)
+
+	second.fail = nil
+	n, err = r.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []int64{1, 2, 4, 3, 5}, second.published)
+	assert.Equal(t, []int64{1, 2, 3, 4, 3, 5}, first.published, "failed events are published again")
+	assert.Empty(t, db.pending)
+}
+
+func TestRelayParksFailingEvents(t *testing.T) {
+	db := newOutboxStorage(
+		event.Event{ID: 1, Type: event.TypeOrderAdded, User: "gopher"},
+		event.Event{ID: 2, Type: event.TypeOrderStatusChanged, User: "gopher"},
+	)
+	sink := &recordingSink{fail: map[int64]bool{1: true}}
+	cfg := DefaultConfig()
+	cfg.MaxAttempts = 3
+	r := newRelay(db, cfg, sink)
+
+	for i := 0; i < cfg.MaxAttempts-1; i++ {
+		n, err := r.RelayOnce(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	}
+	assert.Empty(t, sink.published, "the user's later events wait while the event is retried")
+
+	n, err := r.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []int64{1}, db.parked)
+	assert.Equal(t, []int64{2}, sink.published, "the user's later events go ahead once it is parked")
+	assert.Empty(t, db.pending)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(1, time.Second, time.Minute))
+	assert.Equal(t, 4*time.Second, backoff(3, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, backoff(10, time.Second, time.Minute))
+}
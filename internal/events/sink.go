@@ -0,0 +1,13 @@
+package events
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/models/event"
+)
+
+// Sink publishes events somewhere. A failed publish is retried, so sinks
+// must tolerate receiving an event more than once; the event ID is
+// stable across retries.
+type Sink interface {
+	Publish(ctx context.Context, e event.Event) error
+}
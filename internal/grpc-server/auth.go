@@ -0,0 +1,83 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	pb "github.com/kholodmv/gophermart/api/gophermart/v1"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+type principalKey struct{}
+
+// publicMethods don't need a caller. Every other method does, so a new
+// method is protected unless it is listed here.
+var publicMethods = map[string]bool{
+	pb.Gophermart_Register_FullMethodName: true,
+	pb.Gophermart_Login_FullMethodName:    true,
+}
+
+// methodScopes lists the scope an API key needs for each method, the
+// same as for the matching HTTP route. API keys can't call protected
+// methods missing here.
+var methodScopes = map[string]string{
+	pb.Gophermart_UploadOrder_FullMethodName:     apikey.ScopeOrdersWrite,
+	pb.Gophermart_ListOrders_FullMethodName:      apikey.ScopeOrdersRead,
+	pb.Gophermart_GetBalance_FullMethodName:      apikey.ScopeBalanceRead,
+	pb.Gophermart_Withdraw_FullMethodName:        apikey.ScopeBalanceWithdraw,
+	pb.Gophermart_ListWithdrawals_FullMethodName: apikey.ScopeWithdrawalsRead,
+}
+
+// authenticate resolves the caller from the "authorization" or
+// "x-api-key" metadata, see auth.Authenticate.
+func (s *Server) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	token, ok := strings.CutPrefix(first(md, "authorization"), "Bearer ")
+	if !ok {
+		token = ""
+	}
+
+	p, err := auth.Authenticate(ctx, s.db, token, first(md, "x-api-key"))
+	switch {
+	case errors.Is(err, auth.ErrInvalidAPIKey), errors.Is(err, auth.ErrSessionRevoked):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, auth.ErrNoCredentials):
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token or api key")
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	scope, ok := methodScopes[info.FullMethod]
+	if !ok && p.IsAPIKey() {
+		return nil, status.Error(codes.PermissionDenied, "method is not available to api keys")
+	}
+	if ok && !p.HasScope(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "api key lacks the %s scope", scope)
+	}
+	return handler(context.WithValue(ctx, principalKey{}, p), req)
+}
+
+// principal returns the caller of a protected method.
+func principal(ctx context.Context) *auth.Principal {
+	p, _ := ctx.Value(principalKey{}).(*auth.Principal)
+	if p == nil {
+		return &auth.Principal{}
+	}
+	return p
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package grpcserver
+
+import (
+	"errors"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storageStatuses maps storage errors to the statuses reported to
+// clients, like the problems of the HTTP API. Errors not listed here are
+// internal.
+var storageStatuses = []struct {
+	err     error
+	code    codes.Code
+	message string
+}{
+	{postgresql.ErrorUserNotFound, codes.NotFound, "user not found"},
+	{postgresql.ErrorUserExists, codes.AlreadyExists, "login is already taken"},
+	{postgresql.ErrorOrderNotFound, codes.NotFound, "order not found"},
+	{postgresql.ErrorNotFound, codes.NotFound, "order not found"},
+	{postgresql.ErrorOrderExist, codes.AlreadyExists, "order number has already been added by another user"},
+	{postgresql.ErrorNotEnoughFunds, codes.FailedPrecondition, "there are not enough funds on the account"},
+	{postgresql.ErrorAddWithdrawal, codes.AlreadyExists, "order number has already been used for a withdrawal"},
+}
+
+// storageError reports a storage error, logging it if it is internal.
+func (s *Server) storageError(err error) error {
+	for _, st := range storageStatuses {
+		if errors.Is(err, st.err) {
+			return status.Error(st.code, st.message)
+		}
+	}
+	return s.internalError("internal error", err)
+}
+
+// violationsError reports rejected fields as a BadRequest detail.
+func violationsError(violations []validation.Violation) error {
+	st := status.New(codes.InvalidArgument, "validation failed")
+	br := &errdetails.BadRequest{}
+	for _, v := range violations {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Code + ": " + v.Message,
+		})
+	}
+	detailed, err := st.WithDetails(br)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,321 @@
+// Package grpcserver serves the gRPC API, which mirrors the user facing
+// part of the HTTP API on top of the same storage and auth logic.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	pb "github.com/kholodmv/gophermart/api/gophermart/v1"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/lockout"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+type Server struct {
+	pb.UnimplementedGophermartServer
+
+	log    *slog.Logger
+	db     storage.Storage
+	guard  *lockout.Guard
+	policy validation.PasswordPolicy
+	// otpThreshold is the withdrawal sum above which a second factor is
+	// required, zero disables the check.
+	otpThreshold float32
+}
+
+type Option func(s *Server)
+
+// WithLockout sets the guard that throttles failed logins. Share it with
+// the HTTP handler so that both transports count the same attempts.
+func WithLockout(g *lockout.Guard) Option {
+	return func(s *Server) {
+		s.guard = g
+	}
+}
+
+// WithPasswordPolicy sets the policy new passwords have to satisfy.
+func WithPasswordPolicy(p validation.PasswordPolicy) Option {
+	return func(s *Server) {
+		s.policy = p
+	}
+}
+
+// WithWithdrawalOTPThreshold requires a second factor for withdrawals
+// larger than the threshold.
+func WithWithdrawalOTPThreshold(threshold float32) Option {
+	return func(s *Server) {
+		s.otpThreshold = threshold
+	}
+}
+
+func New(log *slog.Logger, db storage.Storage, opts ...Option) *Server {
+	s := &Server{
+		log:    log,
+		db:     db,
+		guard:  lockout.New(lockout.NewMemoryStore(), lockout.DefaultConfig()),
+		policy: validation.DefaultPasswordPolicy(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// GRPCServer returns a gRPC server with the service and the auth
+// interceptor registered.
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.recoverer, s.authenticate))
+	srv := grpc.NewServer(opts...)
+	pb.RegisterGophermartServer(srv, s)
+	return srv
+}
+
+// Shutdown stops srv gracefully, or abruptly once ctx is done.
+func Shutdown(ctx context.Context, srv *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	newUser := user.User{Login: req.GetLogin(), Password: req.GetPassword()}
+
+	violations := append(validation.ValidateLogin(newUser.Login), s.policy.Validate(newUser.Password, newUser.Login)...)
+	if len(violations) > 0 {
+		return nil, violationsError(violations)
+	}
+
+	var err error
+	newUser.Role = user.RoleUser
+	newUser.HashPassword, err = auth.HashPassword(newUser.Password)
+	if err != nil {
+		return nil, s.internalError("error generate hash password", err)
+	}
+
+	if err = s.db.AddUser(ctx, newUser); err != nil {
+		return nil, s.storageError(err)
+	}
+
+	token, err := s.issueToken(ctx, newUser)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RegisterResponse{Token: token}, nil
+}
+
+func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	credentials := user.User{Login: req.GetLogin(), Password: req.GetPassword(), OTP: req.GetOtp()}
+
+	u, err := auth.Login(ctx, s.db, s.guard, s.log, credentials, clientIP(ctx))
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		retryAfter := strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds())))
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+		return nil, status.Error(codes.ResourceExhausted, "too many failed login attempts")
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrOTPRequired), errors.Is(err, auth.ErrInvalidOTP):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case err != nil:
+		return nil, s.internalError("error login", err)
+	}
+
+	token, err := s.issueToken(ctx, *u)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LoginResponse{Token: token}, nil
+}
+
+// issueToken creates a session token, returning it both in the response
+// and in the header like the HTTP API does.
+func (s *Server) issueToken(ctx context.Context, u user.User) (string, error) {
+	token, err := auth.GenerateToken(u)
+	if err != nil {
+		return "", s.internalError("error creating token", err)
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	return token, nil
+}
+
+func (s *Server) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	number, err := strconv.ParseInt(req.GetNumber(), 10, 64)
+	if err != nil || number < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid order number format")
+	}
+	if !utils.IsValidLuhnNumber(req.GetNumber()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid order number")
+	}
+
+	err = s.db.AddOrder(ctx, order.NewOrder(order.Order{}, principal(ctx).Login, number))
+	switch {
+	case errors.Is(err, postgresql.ErrorOrderAdded):
+		return &pb.UploadOrderResponse{Created: false}, nil
+	case err != nil:
+		return nil, s.storageError(err)
+	}
+	return &pb.UploadOrderResponse{Created: true}, nil
+}
+
+func (s *Server) ListOrders(ctx context.Context, _ *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	orders, err := s.db.GetOrders(ctx, principal(ctx).Login)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+
+	resp := &pb.ListOrdersResponse{Orders: make([]*pb.Order, 0, len(orders))}
+	for _, o := range orders {
+		resp.Orders = append(resp.Orders, &pb.Order{
+			Number:     string(o.Number),
+			Status:     orderStatus(o.Status),
+			Accrual:    o.Accrual,
+			UploadedAt: timestamppb.New(o.UploadedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, _ *pb.GetBalanceRequest) (*pb.GetBalanceResponse, error) {
+	login := principal(ctx).Login
+
+	accruals, err := s.db.GetAccruals(ctx, login)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	withdrawn, err := s.db.GetWithdrawn(ctx, login)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+
+	return &pb.GetBalanceResponse{Current: accruals - withdrawn, Withdrawn: withdrawn}, nil
+}
+
+func (s *Server) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*pb.WithdrawResponse, error) {
+	if !utils.IsValidLuhnNumber(req.GetOrder()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid order number")
+	}
+	if req.GetSum() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "sum must be positive")
+	}
+
+	login := principal(ctx).Login
+
+	if s.otpThreshold > 0 && req.GetSum() > s.otpThreshold {
+		t, err := auth.EnabledTOTP(ctx, s.db, login)
+		if err != nil {
+			return nil, s.internalError("error get totp", err)
+		}
+		if t == nil {
+			return nil, status.Error(codes.PermissionDenied, "two-factor authentication must be enabled for this withdrawal")
+		}
+		err = auth.CheckSecondFactor(ctx, s.guard, s.log, login, clientIP(ctx), func() (bool, error) {
+			return auth.VerifySecondFactor(ctx, s.db, t, req.GetOtp())
+		})
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			retryAfter := strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds())))
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+			return nil, status.Error(codes.ResourceExhausted, "too many failed two-factor attempts")
+		case errors.Is(err, auth.ErrInvalidOTP):
+			return nil, status.Error(codes.PermissionDenied, "invalid two-factor code")
+		case err != nil:
+			return nil, s.internalError("error verify two-factor code", err)
+		}
+	}
+
+	processedAt := time.Now()
+	wd := withdraw.Withdraw{
+		User:        login,
+		Order:       req.GetOrder(),
+		Sum:         req.GetSum(),
+		ProcessedAt: &processedAt,
+	}
+	if _, err := s.db.AddWithdrawal(ctx, wd, login); err != nil {
+		return nil, s.storageError(err)
+	}
+	return &pb.WithdrawResponse{}, nil
+}
+
+func (s *Server) ListWithdrawals(ctx context.Context, _ *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	withdrawals, err := s.db.GetWithdrawals(ctx, principal(ctx).Login)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+
+	resp := &pb.ListWithdrawalsResponse{Withdrawals: make([]*pb.Withdrawal, 0, len(withdrawals))}
+	for _, w := range withdrawals {
+		item := &pb.Withdrawal{Order: w.Order, Sum: w.Sum}
+		if w.ProcessedAt != nil {
+			item.ProcessedAt = timestamppb.New(*w.ProcessedAt)
+		}
+		resp.Withdrawals = append(resp.Withdrawals, item)
+	}
+	return resp, nil
+}
+
+func (s *Server) recoverer(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.Error("panic in grpc handler", slog.String("method", info.FullMethod), slog.Any("panic", r))
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+var orderStatuses = map[order.Status]pb.OrderStatus{
+	order.StatusNew:        pb.OrderStatus_ORDER_STATUS_NEW,
+	order.StatusProcessing: pb.OrderStatus_ORDER_STATUS_PROCESSING,
+	order.StatusInvalid:    pb.OrderStatus_ORDER_STATUS_INVALID,
+	order.StatusProcessed:  pb.OrderStatus_ORDER_STATUS_PROCESSED,
+}
+
+func orderStatus(s order.Status) pb.OrderStatus {
+	return orderStatuses[s]
+}
+
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func (s *Server) internalError(msg string, err error) error {
+	s.log.Error(msg, sl.Err(err))
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,227 @@
+package grpcserver
+
+import (
+	"context"
+	pb "github.com/kholodmv/gophermart/api/gophermart/v1"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+const (
+	testLogin    = "gopher"
+	testPassword = "Tunnel-Digger-42"
+)
+
+func newClient(t *testing.T, db storage.Storage) pb.GophermartClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := New(slog.New(slog.NewTextHandler(io.Discard, nil)), db).GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewGophermartClient(conn)
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func TestServer(t *testing.T) {
+	db := storagetest.NewMemory()
+	c := newClient(t, db)
+
+	var header metadata.MD
+	reg, err := c.Register(context.Background(), &pb.RegisterRequest{Login: testLogin, Password: testPassword}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bearer " + reg.Token}, header.Get("authorization"))
+
+	login, err := c.Login(context.Background(), &pb.LoginRequest{Login: testLogin, Password: testPassword})
+	require.NoError(t, err)
+	ctx := withToken(context.Background(), login.Token)
+
+	uploaded, err := c.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "12345678903"})
+	require.NoError(t, err)
+	assert.True(t, uploaded.Created)
+
+	uploaded, err = c.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "12345678903"})
+	require.NoError(t, err)
+	assert.False(t, uploaded.Created)
+
+	orders, err := c.ListOrders(ctx, &pb.ListOrdersRequest{})
+	require.NoError(t, err)
+	require.Len(t, orders.Orders, 1)
+	assert.Equal(t, "12345678903", orders.Orders[0].Number)
+	assert.Equal(t, pb.OrderStatus_ORDER_STATUS_NEW, orders.Orders[0].Status)
+
+	db.SetAccrual(testLogin, 500)
+
+	_, err = c.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624", Sum: 120})
+	require.NoError(t, err)
+
+	balance, err := c.GetBalance(ctx, &pb.GetBalanceRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, float32(380), balance.Current)
+	assert.Equal(t, float32(120), balance.Withdrawn)
+
+	withdrawals, err := c.ListWithdrawals(ctx, &pb.ListWithdrawalsRequest{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals.Withdrawals, 1)
+	assert.Equal(t, "2377225624", withdrawals.Withdrawals[0].Order)
+	assert.NotNil(t, withdrawals.Withdrawals[0].ProcessedAt)
+}
+
+var errorTests = []struct {
+	name string
+	call func(ctx context.Context, c pb.GophermartClient) error
+	code codes.Code
+}{
+	{
+		name: "Login taken",
+		call: func(ctx context.Context, c pb.GophermartClient) error {
+			_, err := c.Register(ctx, &pb.RegisterRequest{Login: testLogin, Password: testPassword})
+			return err
+		},
+		code: codes.AlreadyExists,
+	},
+	{
+		name: "Weak password",
+		call: func(ctx context.Context, c pb.GophermartClient) error {
+			_, err := c.Register(ctx, &pb.RegisterRequest{Login: "mole", Password: "123"})
+			return err
+		},
+		code: codes.InvalidArgument,
+	},
+	{
+		name: "Wrong password",
+		call: func(ctx context.Context, c pb.GophermartClient) error {
+			_, err := c.Login(ctx, &pb.LoginRequest{Login: testLogin, Password: "Not-The-Password-1"})
+			return err
+		},
+		code: codes.Unauthenticated,
+	},
+	{
+		name: "Invalid order number",
+		call: func(ctx context.Context, c pb.GophermartClient) error {
+			_, err := c.UploadOrder(ctx, &pb.UploadOrderRequest{Number: "12345678900"})
+			return err
+		},
+		code: codes.InvalidArgument,
+	},
+	{
+		name: "Not enough funds",
+		call: func(ctx context.Context, c pb.GophermartClient) error {
+			_, err := c.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624", Sum: 1000})
+			return err
+		},
+		code: codes.FailedPrecondition,
+	},
+	{
+		name: "Missing credentials",
+		call: func(_ context.Context, c pb.GophermartClient) error {
+			_, err := c.GetBalance(context.Background(), &pb.GetBalanceRequest{})
+			return err
+		},
+		code: codes.Unauthenticated,
+	},
+	{
+		name: "Invalid token",
+		call: func(_ context.Context, c pb.GophermartClient) error {
+			_, err := c.GetBalance(withToken(context.Background(), "garbage"), &pb.GetBalanceRequest{})
+			return err
+		},
+		code: codes.Unauthenticated,
+	},
+}
+
+func TestServerErrors(t *testing.T) {
+	c := newClient(t, storagetest.NewMemory())
+
+	reg, err := c.Register(context.Background(), &pb.RegisterRequest{Login: testLogin, Password: testPassword})
+	require.NoError(t, err)
+	ctx := withToken(context.Background(), reg.Token)
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call(ctx, c)
+			assert.Equal(t, test.code, status.Code(err), err)
+		})
+	}
+}
+
+func TestServerAPIKeyScopes(t *testing.T) {
+	db := storagetest.NewMemory()
+	c := newClient(t, db)
+
+	_, err := c.Register(context.Background(), &pb.RegisterRequest{Login: testLogin, Password: testPassword})
+	require.NoError(t, err)
+	db.AddKey("gmk_reader", testLogin, apikey.ScopeBalanceRead)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "gmk_reader")
+
+	_, err = c.GetBalance(ctx, &pb.GetBalanceRequest{})
+	assert.NoError(t, err)
+
+	_, err = c.ListOrders(ctx, &pb.ListOrdersRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "gmk_unknown")
+	_, err = c.GetBalance(ctx, &pb.GetBalanceRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+}
+
+func TestAuthenticateUnlistedMethod(t *testing.T) {
+	db := storagetest.NewMemory()
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), db)
+	require.NoError(t, db.AddUser(context.Background(), user.User{Login: testLogin}))
+	db.AddKey("gmk_all", testLogin, apikey.ScopeOrdersRead, apikey.ScopeOrdersWrite, apikey.ScopeBalanceRead, apikey.ScopeBalanceWithdraw, apikey.ScopeWithdrawalsRead)
+	token, err := auth.GenerateToken(user.User{Login: testLogin})
+	require.NoError(t, err)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/gophermart.v1.Gophermart/Unlisted"}
+	handler := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	call := func(md ...string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(md...))
+		_, err := s.authenticate(ctx, nil, info, handler)
+		return err
+	}
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(call()))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("x-api-key", "gmk_all")))
+	assert.NoError(t, call("authorization", "Bearer "+token))
+}
+
+func TestGetBalanceOfNewUser(t *testing.T) {
+	c := newClient(t, storagetest.NewMemory())
+
+	reg, err := c.Register(context.Background(), &pb.RegisterRequest{Login: testLogin, Password: testPassword})
+	require.NoError(t, err)
+
+	balance, err := c.GetBalance(withToken(context.Background(), reg.Token), &pb.GetBalanceRequest{})
+	require.NoError(t, err)
+	assert.Zero(t, balance.GetCurrent())
+	assert.Zero(t, balance.GetWithdrawn())
+}
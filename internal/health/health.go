@@ -0,0 +1,106 @@
+// Package health serves the liveness and readiness endpoints.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	StatusFail        = "fail"
+)
+
+type Check struct {
+	Name string
+	// Critical checks make the service unready when they fail, the others
+	// only mark it as degraded.
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+type Health struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// New returns health endpoints running the checks, each limited to
+// timeout.
+func New(timeout time.Duration, checks ...Check) *Health {
+	return &Health{checks: checks, timeout: timeout}
+}
+
+// Live answers as long as the process serves requests.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+// Ready runs the checks and answers 503 if a critical one fails.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.Run(r.Context())
+	status := http.StatusOK
+	if report.Status == StatusUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+// Run runs the checks concurrently.
+func (h *Health) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(h.checks))
+	wg := &sync.WaitGroup{}
+	for i, c := range h.checks {
+		i, c := i, c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = CheckResult{Status: StatusOK, Critical: c.Critical}
+			if err := c.Check(ctx); err != nil {
+				results[i].Status = StatusFail
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(h.checks))}
+	for i, c := range h.checks {
+		res := results[i]
+		report.Checks[c.Name] = res
+		if res.Status == StatusOK {
+			continue
+		}
+		if c.Critical {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
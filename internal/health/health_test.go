@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func check(err error) func(context.Context) error {
+	return func(context.Context) error { return err }
+}
+
+var readyTests = []struct {
+	name   string
+	checks []Check
+	status int
+	want   string
+}{
+	{
+		name: "All checks pass",
+		checks: []Check{
+			{Name: "database", Critical: true, Check: check(nil)},
+			{Name: "accrual", Check: check(nil)},
+		},
+		status: http.StatusOK,
+		want:   StatusOK,
+	},
+	{
+		name: "Optional check fails",
+		checks: []Check{
+			{Name: "database", Critical: true, Check: check(nil)},
+			{Name: "accrual", Check: check(errors.New("circuit open"))},
+		},
+		status: http.StatusOK,
+		want:   StatusDegraded,
+	},
+	{
+		name: "Critical check fails",
+		checks: []Check{
+			{Name: "database", Critical: true, Check: check(errors.New("connection refused"))},
+			{Name: "accrual", Check: check(errors.New("circuit open"))},
+		},
+		status: http.StatusServiceUnavailable,
+		want:   StatusUnavailable,
+	},
+	{
+		name: "Check times out",
+		checks: []Check{
+			{Name: "database", Critical: true, Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}},
+		},
+		status: http.StatusServiceUnavailable,
+		want:   StatusUnavailable,
+	},
+}
+
+func TestReady(t *testing.T) {
+	for _, test := range readyTests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			New(10*time.Millisecond, test.checks...).Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, test.status, rec.Code)
+			var report Report
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+			assert.Equal(t, test.want, report.Status)
+			assert.Len(t, report.Checks, len(test.checks))
+			for _, c := range test.checks {
+				assert.Equal(t, c.Critical, report.Checks[c.Name].Critical)
+			}
+			if test.want != StatusOK {
+				failed := report.Checks[test.checks[len(test.checks)-1].Name]
+				assert.Equal(t, StatusFail, failed.Status)
+				assert.NotEmpty(t, failed.Error)
+			}
+		})
+	}
+}
+
+func TestLive(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(time.Second, Check{Name: "database", Critical: true, Check: check(errors.New("down"))}).
+		Live(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
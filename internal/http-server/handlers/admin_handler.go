@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/client"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/limit"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// OrderPoller re-checks a single order in the accrual system without
+// storing the result.
+type OrderPoller interface {
+	CheckOrder(ctx context.Context, number order.Number) (*order.Order, error)
+}
+
+type adminUser struct {
+	Login   string           `json:"login"`
+	Role    string           `json:"role"`
+	Balance withdraw.Balance `json:"balance"`
+}
+
+type adminOrderAction struct {
+	Reason string `json:"reason"`
+}
+
+func (mh *Handler) UnlockLogin(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.UnlockLogin"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	login := chi.URLParam(req, "login")
+
+	if err := mh.guard.Unlock(req.Context(), login); err != nil {
+		mh.log.Error("error unlock login", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+	mh.audit(req.Context(), audit.ActionLoginUnlock, login, nil)
+
+	res.WriteHeader(http.StatusNoContent)
+	mh.log.Info("Login unlocked", slog.String("login", login))
+}
+
+func (mh *Handler) SearchUsers(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.SearchUsers"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	limit := queryInt(req, "limit", defaultPageSize)
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+	offset := queryInt(req, "offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	users, err := mh.db.SearchUsers(req.Context(), req.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		mh.log.Error("error search users", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, users)
+}
+
+func (mh *Handler) GetUser(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.GetUser"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	u, err := mh.db.GetUser(req.Context(), chi.URLParam(req, "login"))
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	balance, err := mh.balance(req.Context(), u.Login)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, adminUser{
+		Login:   u.Login,
+		Role:    u.Role,
+		Balance: balance,
+	})
+}
+
+func (mh *Handler) GetUserOrders(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.GetUserOrders"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	orders, err := mh.db.GetOrders(req.Context(), chi.URLParam(req, "login"))
+	if err != nil {
+		mh.log.Error("error get orders", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, orders)
+}
+
+func (mh *Handler) GetUserWithdrawals(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.GetUserWithdrawals"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	withdrawals, err := mh.db.GetWithdrawals(req.Context(), chi.URLParam(req, "login"))
+	if err != nil {
+		mh.log.Error("error get withdrawals", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, withdrawals)
+}
+
+func (mh *Handler) GetUserBalance(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.GetUserBalance"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	u, err := mh.db.GetUser(req.Context(), chi.URLParam(req, "login"))
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	balance, err := mh.balance(req.Context(), u.Login)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, balance)
+}
+
+// AdjustBalance posts a manual credit (positive amount) or debit (negative
+// amount). The reason is mandatory and ends up in the audit log.
+func (mh *Handler) AdjustBalance(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.AdjustBalance"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var a withdraw.Adjustment
+	if err := decodeJSON(req, &a); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	var violations []validation.Violation
+	if a.Amount == 0 {
+		violations = append(violations, validation.Violation{
+			Field:   "amount",
+			Code:    validation.CodeRequired,
+			Message: "amount must be a non-zero number",
+		})
+	}
+	if strings.TrimSpace(a.Reason) == "" {
+		violations = append(violations, validation.Violation{
+			Field:   "reason",
+			Code:    validation.CodeRequired,
+			Message: "reason is required",
+		})
+	}
+	if len(violations) > 0 {
+		writeViolations(res, req, violations)
+		return
+	}
+
+	a.User = chi.URLParam(req, "login")
+	a.Actor = utils.GetLogin(req.Context())
+	a.CreatedAt = time.Now()
+
+	adjustment, err := mh.db.AddBalanceAdjustment(req.Context(), a)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	mh.log.Info("Balance adjusted",
+		slog.String("login", a.User),
+		slog.String("actor", a.Actor),
+		slog.Float64("amount", float64(a.Amount)),
+	)
+	writeJSON(res, http.StatusOK, adjustment)
+}
+
+func (mh *Handler) RepollOrder(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.RepollOrder"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	if mh.poller == nil {
+		problem.Error(res, req, http.StatusServiceUnavailable, problem.CodeAccrualUnavailable, "Accrual system is not configured")
+		return
+	}
+
+	number := order.Number(chi.URLParam(req, "number"))
+	if _, err := mh.db.GetOrder(req.Context(), number); err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	o, err := mh.poller.CheckOrder(req.Context(), number)
+	if errors.Is(err, client.ErrorCircuitOpen) {
+		problem.Error(res, req, http.StatusServiceUnavailable, problem.CodeAccrualUnavailable, "Accrual system is failing, requests are paused")
+		return
+	}
+	if err != nil {
+		mh.log.Error("error poll order", sl.Err(err))
+		problem.Error(res, req, http.StatusBadGateway, problem.CodeAccrualFailed, "Accrual system request failed")
+		return
+	}
+
+	err = mh.db.UpdateOrderAudited(req.Context(), *o, mh.auditEntry(req.Context(), audit.ActionOrderRepoll, string(number), o))
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	updated, err := mh.db.GetOrder(req.Context(), number)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+	writeJSON(res, http.StatusOK, updated)
+}
+
+func (mh *Handler) InvalidateOrder(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.InvalidateOrder"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var action adminOrderAction
+	err := decodeJSON(req, &action)
+	if limit.IsTooLarge(err) {
+		limit.TooLarge(res, req)
+		return
+	}
+	if err != nil || strings.TrimSpace(action.Reason) == "" {
+		writeViolations(res, req, []validation.Violation{{
+			Field:   "reason",
+			Code:    validation.CodeRequired,
+			Message: "reason is required",
+		}})
+		return
+	}
+
+	number := order.Number(chi.URLParam(req, "number"))
+	o, err := mh.db.GetOrder(req.Context(), number)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	o.Status = order.StatusInvalid
+	o.Accrual = 0
+	err = mh.db.UpdateOrderAudited(req.Context(), *o, mh.auditEntry(req.Context(), audit.ActionOrderInvalid, string(number), action))
+	if err != nil {
+		mh.log.Error("error update order", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, o)
+}
+
+func (mh *Handler) GetAuditLog(res http.ResponseWriter, req *http.Request) {
+	const op = "admin_handler.GetAuditLog"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	limit := queryInt(req, "limit", defaultPageSize)
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	entries, err := mh.db.GetAuditLog(req.Context(), limit)
+	if err != nil {
+		mh.log.Error("error get audit log", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, entries)
+}
+
+// audit records an admin action. The action has already happened, so a
+// failure is logged rather than reported to the caller.
+func (mh *Handler) audit(ctx context.Context, action string, target string, details interface{}) {
+	err := mh.db.AddAuditEntry(ctx, mh.auditEntry(ctx, action, target, details))
+	if err != nil {
+		mh.log.Error("error add audit entry", sl.Err(err), slog.String("action", action), slog.String("target", target))
+	}
+}
+
+// auditEntry describes an action of the current user.
+func (mh *Handler) auditEntry(ctx context.Context, action string, target string, details interface{}) audit.Entry {
+	raw, err := json.Marshal(details)
+	if err != nil {
+		mh.log.Error("error marshal audit details", sl.Err(err))
+	}
+
+	return audit.Entry{
+		Actor:     utils.GetLogin(ctx),
+		Action:    action,
+		Target:    target,
+		Details:   raw,
+		CreatedAt: time.Now(),
+	}
+}
+
+func queryInt(req *http.Request, name string, def int) int {
+	v, err := strconv.Atoi(req.URL.Query().Get(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
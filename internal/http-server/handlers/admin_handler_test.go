@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/client"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type adminStorage struct {
+	storage.Storage
+	orders       map[order.Number]order.Order
+	updateErr    error
+	withdrawnErr error
+	audited      []audit.Entry
+}
+
+func (s *adminStorage) GetUser(_ context.Context, login string) (*user.User, error) {
+	if login != "gopher" {
+		return nil, postgresql.ErrorUserNotFound
+	}
+	return &user.User{Login: login}, nil
+}
+
+func (s *adminStorage) GetAccruals(_ context.Context, _ string) (float32, error) {
+	return 700, nil
+}
+
+func (s *adminStorage) GetWithdrawn(_ context.Context, _ string) (float32, error) {
+	if s.withdrawnErr != nil {
+		return 0, s.withdrawnErr
+	}
+	return 200, nil
+}
+
+func (s *adminStorage) GetOrder(_ context.Context, number order.Number) (*order.Order, error) {
+	o, ok := s.orders[number]
+	if !ok {
+		return nil, postgresql.ErrorOrderNotFound
+	}
+	return &o, nil
+}
+
+func (s *adminStorage) AddAuditEntry(_ context.Context, e audit.Entry) error {
+	s.audited = append(s.audited, e)
+	return nil
+}
+
+func (s *adminStorage) UpdateOrderAudited(_ context.Context, o order.Order, e audit.Entry) error {
+	if s.updateErr != nil {
+		return s.updateErr
+	}
+	s.orders[o.Number] = o
+	s.audited = append(s.audited, e)
+	return nil
+}
+
+type fakePoller struct {
+	o   *order.Order
+	err error
+}
+
+func (p fakePoller) CheckOrder(_ context.Context, _ order.Number) (*order.Order, error) {
+	return p.o, p.err
+}
+
+func newAdminStorage() *adminStorage {
+	return &adminStorage{orders: map[order.Number]order.Order{
+		"2377225624": {Number: "2377225624", UserLogin: "gopher", Status: order.StatusProcessing},
+	}}
+}
+
+func serveAdmin(h http.HandlerFunc, method string, target string, body string, params map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+	req = req.WithContext(context.WithValue(ctx, auth.LoginKey, "admin"))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestGetUserBalance(t *testing.T) {
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), newAdminStorage())
+
+	rec := serveAdmin(h.GetUserBalance, http.MethodGet, "/api/admin/users/gopher/balance", "", map[string]string{"login": "gopher"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var b withdraw.Balance
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&b))
+	assert.Equal(t, withdraw.Balance{Current: 500, Withdrawn: 200}, b)
+
+	rec = serveAdmin(h.GetUserBalance, http.MethodGet, "/api/admin/users/nobody/balance", "", map[string]string{"login": "nobody"})
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeUserNotFound, problemCode(t, rec))
+}
+
+func TestAdminBalanceFailure(t *testing.T) {
+	db := newAdminStorage()
+	db.withdrawnErr = errors.New("boom")
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db)
+
+	// A balance that can't be read isn't shown as zero.
+	for name, handler := range map[string]http.HandlerFunc{"GetUser": h.GetUser, "GetUserBalance": h.GetUserBalance} {
+		rec := serveAdmin(handler, http.MethodGet, "/api/admin/users/gopher", "", map[string]string{"login": "gopher"})
+		require.Equal(t, http.StatusInternalServerError, rec.Code, name)
+		assert.Equal(t, problem.CodeInternal, problemCode(t, rec), name)
+	}
+}
+
+func TestRepollOrder(t *testing.T) {
+	processed := &order.Order{Number: "2377225624", Status: order.StatusProcessed, Accrual: 500}
+
+	tests := []struct {
+		name      string
+		number    string
+		poller    fakePoller
+		updateErr error
+		status    int
+		code      string
+	}{
+		{name: "Processed", number: "2377225624", poller: fakePoller{o: processed}, status: http.StatusOK},
+		{name: "Unknown order", number: "79927398713", poller: fakePoller{o: processed}, status: http.StatusNotFound, code: problem.CodeOrderNotFound},
+		{name: "Circuit open", number: "2377225624", poller: fakePoller{err: client.ErrorCircuitOpen}, status: http.StatusServiceUnavailable, code: problem.CodeAccrualUnavailable},
+		{name: "Accrual failed", number: "2377225624", poller: fakePoller{err: errors.New("boom")}, status: http.StatusBadGateway, code: problem.CodeAccrualFailed},
+		{name: "Update failed", number: "2377225624", poller: fakePoller{o: processed}, updateErr: errors.New("boom"), status: http.StatusInternalServerError, code: problem.CodeInternal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newAdminStorage()
+			db.updateErr = test.updateErr
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, WithOrderPoller(test.poller))
+
+			rec := serveAdmin(h.RepollOrder, http.MethodPost, "/api/admin/orders/"+test.number+"/repoll", "", map[string]string{"number": test.number})
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.code != "" {
+				assert.Equal(t, test.code, problemCode(t, rec))
+				assert.Empty(t, db.audited)
+				return
+			}
+			var o order.Order
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&o))
+			assert.Equal(t, order.StatusProcessed, o.Status)
+			require.Len(t, db.audited, 1)
+			assert.Equal(t, audit.ActionOrderRepoll, db.audited[0].Action)
+			assert.Equal(t, "admin", db.audited[0].Actor)
+			assert.Equal(t, test.number, db.audited[0].Target)
+		})
+	}
+}
+
+func TestInvalidateOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "Invalidated", number: "2377225624", body: `{"reason":"fraud"}`, status: http.StatusOK},
+		{name: "Missing reason", number: "2377225624", body: `{"reason":" "}`, status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+		{name: "Unknown order", number: "79927398713", body: `{"reason":"fraud"}`, status: http.StatusNotFound, code: problem.CodeOrderNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newAdminStorage()
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db)
+
+			rec := serveAdmin(h.InvalidateOrder, http.MethodPost, "/api/admin/orders/"+test.number+"/invalidate", test.body, map[string]string{"number": test.number})
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.code != "" {
+				assert.Equal(t, test.code, problemCode(t, rec))
+				assert.Empty(t, db.audited)
+				return
+			}
+			assert.Equal(t, order.StatusInvalid, db.orders[order.Number(test.number)].Status)
+			require.Len(t, db.audited, 1)
+			assert.Equal(t, audit.ActionOrderInvalid, db.audited[0].Action)
+			assert.JSONEq(t, `{"reason":"fraud"}`, string(db.audited[0].Details))
+		})
+	}
+}
+
+func TestUnlockLogin(t *testing.T) {
+	db := newAdminStorage()
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, newTestLockout())
+
+	rec := serveAdmin(h.UnlockLogin, http.MethodDelete, "/api/admin/lockouts/gopher", "", map[string]string{"login": "gopher"})
+
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	require.Len(t, db.audited, 1)
+	assert.Equal(t, audit.ActionLoginUnlock, db.audited[0].Action)
+	assert.Equal(t, "admin", db.audited[0].Actor)
+	assert.Equal(t, "gopher", db.audited[0].Target)
+}
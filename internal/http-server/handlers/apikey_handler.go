@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (mh *Handler) CreateAPIKey(res http.ResponseWriter, req *http.Request) {
+	const op = "apikey_handler.CreateAPIKey"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var create apikey.CreateRequest
+	if err := decodeJSON(req, &create); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	if violations := validateAPIKey(create); len(violations) > 0 {
+		writeViolations(res, req, violations)
+		return
+	}
+
+	secret, err := apikey.GenerateSecret()
+	if err != nil {
+		mh.log.Error("error generate api key", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	k, err := mh.db.AddAPIKey(req.Context(), apikey.Key{
+		User:      utils.GetLogin(req.Context()),
+		Name:      strings.TrimSpace(create.Name),
+		Prefix:    apikey.PrefixOf(secret),
+		Hash:      auth.HashToken(secret),
+		Scopes:    create.Scopes,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		mh.log.Error("error add api key", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	mh.log.Info("API key created", slog.Int64("id", k.ID))
+	writeJSON(res, http.StatusCreated, apikey.Created{Key: *k, Secret: secret})
+}
+
+func (mh *Handler) GetAPIKeys(res http.ResponseWriter, req *http.Request) {
+	const op = "apikey_handler.GetAPIKeys"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	keys, err := mh.db.GetAPIKeys(req.Context(), utils.GetLogin(req.Context()))
+	if err != nil {
+		mh.log.Error("error get api keys", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	if len(keys) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(res, http.StatusOK, keys)
+}
+
+func (mh *Handler) RevokeAPIKey(res http.ResponseWriter, req *http.Request) {
+	const op = "apikey_handler.RevokeAPIKey"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	id, err := strconv.ParseInt(chi.URLParam(req, "id"), 10, 64)
+	if err != nil {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid api key id")
+		return
+	}
+
+	err = mh.db.RevokeAPIKey(req.Context(), utils.GetLogin(req.Context()), id)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+	mh.log.Info("API key revoked", slog.Int64("id", id))
+}
+
+func validateAPIKey(create apikey.CreateRequest) []validation.Violation {
+	var violations []validation.Violation
+
+	switch name := strings.TrimSpace(create.Name); {
+	case name == "":
+		violations = append(violations, validation.Violation{
+			Field:   "name",
+			Code:    validation.CodeRequired,
+			Message: "name is required",
+		})
+	case len(name) > apikey.MaxNameLength:
+		violations = append(violations, validation.Violation{
+			Field:   "name",
+			Code:    validation.CodeTooLong,
+			Message: "name must be at most " + strconv.Itoa(apikey.MaxNameLength) + " characters long",
+		})
+	}
+
+	if len(create.Scopes) == 0 {
+		violations = append(violations, validation.Violation{
+			Field:   "scopes",
+			Code:    validation.CodeRequired,
+			Message: "at least one scope is required, known scopes: " + strings.Join(apikey.Scopes, ", "),
+		})
+	}
+	for _, s := range create.Scopes {
+		if !apikey.ValidScope(s) {
+			violations = append(violations, validation.Violation{
+				Field:   "scopes",
+				Code:    validation.CodeInvalid,
+				Message: "unknown scope " + s + ", known scopes: " + strings.Join(apikey.Scopes, ", "),
+			})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/limit"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"io"
+	"net/http"
+)
+
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// decodeJSON reads a single JSON value from the request body into v.
+// Fields v doesn't have and anything but whitespace after the value are
+// errors, so that typos don't go unnoticed and nothing rides along.
+func decodeJSON(req *http.Request, v interface{}) error {
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		if err == nil {
+			return errTrailingData
+		}
+		return err
+	}
+	return nil
+}
+
+// writeDecodeError reports a body that failed to decode.
+func writeDecodeError(res http.ResponseWriter, req *http.Request, err error) {
+	if limit.IsTooLarge(err) {
+		limit.TooLarge(res, req)
+		return
+	}
+	problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request format")
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/http-server/openapi"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var decodeJSONTests = []struct {
+	name string
+	body string
+	ok   bool
+}{
+	{name: "Single value", body: `{"login":"gopher","password":"secret"}`, ok: true},
+	{name: "Trailing whitespace", body: "{\"login\":\"gopher\"}\n\t ", ok: true},
+	{name: "Unknown field", body: `{"login":"gopher","pasword":"secret"}`},
+	{name: "Second value", body: `{"login":"gopher"}{"login":"mole"}`},
+	{name: "Trailing garbage", body: `{"login":"gopher"}]`},
+	{name: "Empty body", body: ``},
+}
+
+func TestDecodeJSON(t *testing.T) {
+	for _, test := range decodeJSONTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			var v struct {
+				Login    string `json:"login"`
+				Password string `json:"password"`
+			}
+
+			err := decodeJSON(req, &v)
+
+			assert.Equal(t, test.ok, err == nil, "error: %v", err)
+		})
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestBodyLimits(t *testing.T) {
+	// Bodies that pass the limits fail the login on the missing storage
+	// later, so only rejections are checked.
+	padded := `{"login":"` + strings.Repeat("g", 2*smallBodySize) + `"}`
+	bomb := gzipped(t, []byte(`{"login":"`+strings.Repeat("g", DefaultMaxBodySize)+`"}`))
+	require.Less(t, len(bomb), smallBodySize)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		gzip      bool
+		chunked   bool
+		validator bool
+		status    int
+		code      string
+	}{
+		{name: "Unknown field", body: []byte(`{"login":"gopher","admin":true}`), status: http.StatusBadRequest, code: problem.CodeInvalidRequest},
+		{name: "Trailing data", body: []byte(`{"login":"gopher"} {}`), status: http.StatusBadRequest, code: problem.CodeInvalidRequest},
+		{name: "Declared length over the route limit", body: []byte(padded), status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+		{name: "Chunked body over the route limit", body: []byte(padded), chunked: true, status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+		{name: "Decompressed body over the limit", body: bomb, gzip: true, status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+		{name: "Body over the limit with the validator", body: bomb, gzip: true, validator: true, status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := slog.New(slog.NewTextHandler(io.Discard, nil))
+			var opts []Option
+			if test.validator {
+				doc, err := openapi.Load()
+				require.NoError(t, err)
+				v, err := openapi.NewValidator(doc, log, false)
+				require.NoError(t, err)
+				opts = append(opts, WithValidator(v))
+			}
+			router := chi.NewRouter()
+			NewHandler(router, log, nil, opts...).RegisterRoutes()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			if test.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			require.Equal(t, test.status, rec.Code)
+			var body io.Reader = rec.Body
+			if test.gzip {
+				zr, err := gzip.NewReader(rec.Body)
+				require.NoError(t, err)
+				body = zr
+			}
+			var p problem.Problem
+			require.NoError(t, json.NewDecoder(body).Decode(&p))
+			assert.Equal(t, test.code, p.Code)
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/oidc"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	oidcCookie     = "gophermart_oidc"
+	oidcCookiePath = "/api/user/oidc"
+	oidcSessionTTL = 10 * time.Minute
+	// oidcOTPTTL is how long a user signed in at the provider has to
+	// enter the two-factor code.
+	oidcOTPTTL       = 5 * time.Minute
+	provisionRetries = 5
+)
+
+func (mh *Handler) OIDCLogin(res http.ResponseWriter, req *http.Request) {
+	const op = "oidc_handler.OIDCLogin"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	authURL, ok := mh.startOIDC(res, req, "")
+	if !ok {
+		return
+	}
+	http.Redirect(res, req, authURL, http.StatusFound)
+}
+
+// OIDCLink starts linking an identity at the provider to the current
+// user, who can then sign in with it. The client sends the user to the
+// returned URL; the callback completes the link.
+func (mh *Handler) OIDCLink(res http.ResponseWriter, req *http.Request) {
+	const op = "oidc_handler.OIDCLink"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	authURL, ok := mh.startOIDC(res, req, utils.GetLogin(req.Context()))
+	if !ok {
+		return
+	}
+	writeJSON(res, http.StatusOK, oidcLink{AuthorizationURL: authURL})
+}
+
+type oidcLink struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// startOIDC starts a login at the provider and returns the URL to send
+// the user to. The identity is linked to the user afterwards if link is
+// set.
+func (mh *Handler) startOIDC(res http.ResponseWriter, req *http.Request, link string) (string, bool) {
+	if mh.oidc == nil {
+		problem.NotFound(res, req)
+		return "", false
+	}
+
+	s, challenge, err := oidc.NewSession()
+	if err != nil {
+		mh.log.Error("error create oidc session", sl.Err(err))
+		writeInternalError(res, req)
+		return "", false
+	}
+	s.Link = link
+
+	if err = setOIDCSession(res, req, *s, oidcSessionTTL); err != nil {
+		mh.log.Error("error encode oidc session", sl.Err(err))
+		writeInternalError(res, req)
+		return "", false
+	}
+	return mh.oidc.AuthCodeURL(s.State, s.Nonce, challenge), true
+}
+
+// OIDCCallback completes the login at the identity provider. The external
+// identity is mapped to a local user, who is provisioned on first login,
+// and the usual token is issued unless the user has a second factor to
+// pass first. When linking, the identity is linked to the user instead.
+func (mh *Handler) OIDCCallback(res http.ResponseWriter, req *http.Request) {
+	const op = "oidc_handler.OIDCCallback"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	if mh.oidc == nil {
+		problem.NotFound(res, req)
+		return
+	}
+
+	q := req.URL.Query()
+	if e := q.Get("error"); e != "" {
+		clearOIDCSession(res)
+		mh.log.Error("Identity provider returned an error", slog.String("error", e))
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeOIDCFailed, "Login at identity provider failed: "+e)
+		return
+	}
+
+	clearOIDCSession(res)
+	s, ok := mh.oidcSession(res, req)
+	if !ok {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(s.State), []byte(q.Get("state"))) != 1 || s.Login != "" {
+		mh.log.Error("Invalid oidc state")
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeOIDCSession, "Invalid login session")
+		return
+	}
+
+	token, err := mh.oidc.Exchange(req.Context(), q.Get("code"), s.Verifier, s.Nonce)
+	if err != nil {
+		mh.log.Error("error exchange authorization code", sl.Err(err))
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeOIDCFailed, "Login at identity provider failed")
+		return
+	}
+
+	if s.Link != "" {
+		err = mh.db.LinkIdentity(req.Context(), mh.oidc.Issuer(), token.Subject, s.Link)
+		if err != nil {
+			mh.writeError(res, req, err)
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+		mh.log.Info("Identity linked via oidc", slog.String("login", s.Link))
+		return
+	}
+
+	u, err := mh.identityUser(req.Context(), token)
+	if err != nil {
+		mh.log.Error("error resolve identity", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	// The provider replaces the password, not the second factor.
+	t, err := auth.EnabledTOTP(req.Context(), mh.db, u.Login)
+	if err != nil {
+		mh.log.Error("error get totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+	if t != nil {
+		s.Login = u.Login
+		if err = setOIDCSession(res, req, *s, oidcOTPTTL); err != nil {
+			mh.log.Error("error encode oidc session", sl.Err(err))
+			writeInternalError(res, req)
+			return
+		}
+		mh.log.Info("Two-factor code required")
+		res.Header().Set(otpRequiredHeader, "true")
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeOTPRequired, "Two-factor code required")
+		return
+	}
+
+	mh.writeOIDCToken(res, req, u)
+}
+
+// OIDCSecondFactor completes a login at the identity provider with the
+// user's two-factor code.
+func (mh *Handler) OIDCSecondFactor(res http.ResponseWriter, req *http.Request) {
+	const op = "oidc_handler.OIDCSecondFactor"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	if mh.oidc == nil {
+		problem.NotFound(res, req)
+		return
+	}
+
+	var code user.TOTPCode
+	if err := decodeJSON(req, &code); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	s, ok := mh.oidcSession(res, req)
+	if !ok {
+		return
+	}
+	if s.Login == "" {
+		mh.log.Error("Invalid oidc state")
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeOIDCSession, "Invalid login session")
+		return
+	}
+
+	t, err := auth.EnabledTOTP(req.Context(), mh.db, s.Login)
+	if err != nil {
+		mh.log.Error("error get totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+	if t != nil {
+		err = auth.CheckSecondFactor(req.Context(), mh.guard, mh.log, s.Login, utils.ClientIP(req), func() (bool, error) {
+			return auth.VerifySecondFactor(req.Context(), mh.db, t, code.Code)
+		})
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			mh.log.Error("Too many failed two-factor attempts", slog.String("login", s.Login))
+			writeLocked(res, req, locked)
+			return
+		case errors.Is(err, auth.ErrInvalidOTP):
+			mh.log.Error("Invalid two-factor code")
+			res.Header().Set(otpRequiredHeader, "true")
+			problem.Error(res, req, http.StatusUnauthorized, problem.CodeInvalidOTP, "Invalid two-factor code")
+			return
+		case err != nil:
+			mh.log.Error("error verify two-factor code", sl.Err(err))
+			writeInternalError(res, req)
+			return
+		}
+	}
+
+	u, err := mh.db.GetUser(req.Context(), s.Login)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+	clearOIDCSession(res)
+	mh.writeOIDCToken(res, req, u)
+}
+
+// oidcSession returns the session of the login in progress, reporting a
+// problem if there is none.
+func (mh *Handler) oidcSession(res http.ResponseWriter, req *http.Request) (*oidc.Session, bool) {
+	cookie, err := req.Cookie(oidcCookie)
+	if err != nil {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeOIDCSession, "Login session not found")
+		return nil, false
+	}
+	s, err := oidc.DecodeSession(auth.SecretKey, cookie.Value)
+	if err != nil {
+		mh.log.Error("Invalid oidc session", sl.Err(err))
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeOIDCSession, "Invalid login session")
+		return nil, false
+	}
+	return s, true
+}
+
+func (mh *Handler) writeOIDCToken(res http.ResponseWriter, req *http.Request, u *user.User) {
+	tokenString, err := auth.GenerateToken(*u)
+	if err != nil {
+		mh.log.Error("Error creating token")
+		writeInternalError(res, req)
+		return
+	}
+
+	res.Header().Set("Authorization", "Bearer "+tokenString)
+	res.WriteHeader(http.StatusOK)
+	mh.log.Info("User successfully authenticated via oidc")
+}
+
+func setOIDCSession(res http.ResponseWriter, req *http.Request, s oidc.Session, ttl time.Duration) error {
+	cookie, err := oidc.EncodeSession(auth.SecretKey, s, ttl)
+	if err != nil {
+		return err
+	}
+	// Replaces the session cleared when it was read.
+	res.Header().Del("Set-Cookie")
+	http.SetCookie(res, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    cookie,
+		Path:     oidcCookiePath,
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func clearOIDCSession(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// identityUser returns the user linked to the identity, provisioning one
+// if needed. Existing local accounts are never linked implicitly, as
+// matching them by login would let the provider take them over; their
+// users link the identity themselves, see OIDCLink.
+func (mh *Handler) identityUser(ctx context.Context, token *oidc.IDToken) (*user.User, error) {
+	issuer := mh.oidc.Issuer()
+
+	login, err := mh.db.GetIdentity(ctx, issuer, token.Subject)
+	if err == nil {
+		return mh.db.GetUser(ctx, login)
+	}
+	if !errors.Is(err, postgresql.ErrorNoIdentity) {
+		return nil, err
+	}
+
+	// Provisioned users sign in through the provider only, until they
+	// set a password with the reset flow.
+	password, err := auth.GenerateRandomToken(resetTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	hashPass, err := utils.GenerateHashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	base := identityLogin(issuer, token)
+	login = base
+	for i := 0; i < provisionRetries; i++ {
+		err = mh.db.AddIdentityUser(ctx, issuer, token.Subject, user.User{
+			Login:        login,
+			HashPassword: hashPass,
+		})
+		if !errors.Is(err, postgresql.ErrorUserExists) {
+			break
+		}
+		suffix, genErr := auth.GenerateRandomToken(2)
+		if genErr != nil {
+			return nil, genErr
+		}
+		login = base + "-" + suffix
+	}
+	if errors.Is(err, postgresql.ErrorIdentityLinked) {
+		// A concurrent first login provisioned the user already.
+		login, err = mh.db.GetIdentity(ctx, issuer, token.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return mh.db.GetUser(ctx, login)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mh.log.Info("User provisioned via oidc", slog.String("login", login))
+	return mh.db.GetUser(ctx, login)
+}
+
+func identityLogin(issuer string, token *oidc.IDToken) string {
+	candidates := []string{token.PreferredUsername}
+	if token.EmailVerified && token.Email != "" {
+		candidates = append(candidates, strings.SplitN(token.Email, "@", 2)[0])
+	}
+	for _, c := range candidates {
+		if len(validation.ValidateLogin(c)) == 0 {
+			return c
+		}
+	}
+	return "oidc-" + auth.HashToken(issuer + "|" + token.Subject)[:12]
+}
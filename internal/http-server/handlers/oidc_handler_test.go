@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	authn "github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/oidc"
+	"github.com/kholodmv/gophermart/internal/oidc/oidctest"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type oidcStorage struct {
+	*totpStorage
+	users      map[string]bool
+	identities map[string]string
+	// raced is who a concurrent first login provisions, see AddIdentityUser.
+	raced string
+}
+
+func newOIDCStorage(t *testing.T) (*oidcStorage, string) {
+	db, secret := newTOTPStorage(t, false)
+	db.totp = nil
+	return &oidcStorage{
+		totpStorage: db,
+		users:       map[string]bool{"gopher": true},
+		identities:  map[string]string{},
+	}, secret
+}
+
+func (s *oidcStorage) GetUser(_ context.Context, login string) (*user.User, error) {
+	if !s.users[login] {
+		return nil, postgresql.ErrorUserNotFound
+	}
+	return &user.User{Login: login}, nil
+}
+
+func (s *oidcStorage) GetIdentity(_ context.Context, _ string, subject string) (string, error) {
+	login, ok := s.identities[subject]
+	if !ok {
+		return "", postgresql.ErrorNoIdentity
+	}
+	return login, nil
+}
+
+func (s *oidcStorage) AddIdentityUser(_ context.Context, _ string, subject string, u user.User) error {
+	if s.raced != "" {
+		s.users[s.raced] = true
+		s.identities[subject] = s.raced
+		return postgresql.ErrorIdentityLinked
+	}
+	if s.users[u.Login] {
+		return postgresql.ErrorUserExists
+	}
+	s.users[u.Login] = true
+	s.identities[subject] = u.Login
+	return nil
+}
+
+func (s *oidcStorage) LinkIdentity(_ context.Context, _ string, subject string, login string) error {
+	if l, ok := s.identities[subject]; ok && l != login {
+		return postgresql.ErrorIdentityLinked
+	}
+	s.identities[subject] = login
+	return nil
+}
+
+func newOIDCHandler(t *testing.T, db *oidcStorage) (*Handler, *oidctest.IdP) {
+	idp := oidctest.NewIdP(t, "gophermart", "client-secret")
+	p, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  "http://gophermart.local/api/user/oidc/callback",
+	}, nil)
+	require.NoError(t, err)
+	return NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, WithOIDC(p), newTestLockout()), idp
+}
+
+// callback signs in at the provider and returns the callback's response
+// to the session cookie the start of the login set.
+func callback(t *testing.T, h *Handler, idp *oidctest.IdP, start *httptest.ResponseRecorder, authURL string) *httptest.ResponseRecorder {
+	t.Helper()
+	redirect := idp.Authorize(t, authURL)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/oidc/callback?"+redirect.RawQuery, nil)
+	for _, c := range start.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.OIDCCallback(rec, req)
+	return rec
+}
+
+func oidcLogin(t *testing.T, h *Handler, idp *oidctest.IdP) *httptest.ResponseRecorder {
+	t.Helper()
+	start := httptest.NewRecorder()
+	h.OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/api/user/oidc/login", nil))
+	require.Equal(t, http.StatusFound, start.Code, start.Body.String())
+	return callback(t, h, idp, start, start.Header().Get("Location"))
+}
+
+func tokenLogin(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	claims, err := authn.ParseToken(strings.TrimPrefix(rec.Header().Get("Authorization"), "Bearer "))
+	require.NoError(t, err)
+	return claims.Login
+}
+
+func TestOIDCCallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		identities map[string]string
+		raced      string
+		login      string
+	}{
+		{name: "First login", login: "john"},
+		{name: "Linked identity", identities: map[string]string{"subject-1": "gopher"}, login: "gopher"},
+		{name: "Concurrent first login", raced: "john-race", login: "john-race"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, _ := newOIDCStorage(t)
+			for subject, login := range test.identities {
+				db.identities[subject] = login
+			}
+			db.raced = test.raced
+			h, idp := newOIDCHandler(t, db)
+
+			rec := oidcLogin(t, h, idp)
+
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, test.login, tokenLogin(t, rec))
+			assert.Equal(t, test.login, db.identities["subject-1"])
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresSecondFactor(t *testing.T) {
+	db, secret := newOIDCStorage(t)
+	db.identities["subject-1"] = "gopher"
+	db.totp = &user.TOTP{Login: "gopher", Secret: secret, Enabled: true}
+	h, idp := newOIDCHandler(t, db)
+
+	rec := oidcLogin(t, h, idp)
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+	assert.Equal(t, "true", rec.Header().Get(otpRequiredHeader))
+	assert.Empty(t, rec.Header().Get("Authorization"))
+	cookies := rec.Result().Cookies()
+	assert.Equal(t, problem.CodeOTPRequired, problemCode(t, rec))
+
+	otp := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/oidc/otp", strings.NewReader(`{"code":"`+code+`"}`))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.OIDCSecondFactor(rec, req)
+		return rec
+	}
+
+	rec = otp("000000")
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeInvalidOTP, problemCode(t, rec))
+
+	code := currentCode(t, secret)
+	rec = otp(code)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "gopher", tokenLogin(t, rec))
+
+	// The code can't be used twice.
+	rec = otp(code)
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeInvalidOTP, problemCode(t, rec))
+}
+
+func TestOIDCSecondFactorWithoutLogin(t *testing.T) {
+	db, _ := newOIDCStorage(t)
+	h, _ := newOIDCHandler(t, db)
+
+	// The session of a login still at the provider doesn't get a token.
+	start := httptest.NewRecorder()
+	h.OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/api/user/oidc/login", nil))
+	req := httptest.NewRequest(http.MethodPost, "/api/user/oidc/otp", strings.NewReader(`{"code":"000000"}`))
+	for _, c := range start.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.OIDCSecondFactor(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeOIDCSession, problemCode(t, rec))
+	assert.Empty(t, rec.Header().Get("Authorization"))
+}
+
+func TestOIDCLink(t *testing.T) {
+	tests := []struct {
+		name       string
+		identities map[string]string
+		status     int
+		code       string
+	}{
+		{name: "Linked", status: http.StatusNoContent},
+		{name: "Already linked to the user", identities: map[string]string{"subject-1": "gopher"}, status: http.StatusNoContent},
+		{name: "Linked to another user", identities: map[string]string{"subject-1": "john"}, status: http.StatusConflict, code: problem.CodeIdentityLinked},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, _ := newOIDCStorage(t)
+			db.users["john"] = true
+			for subject, login := range test.identities {
+				db.identities[subject] = login
+			}
+			h, idp := newOIDCHandler(t, db)
+
+			start := serveAsGopher(h.OIDCLink, http.MethodPost, "/api/user/oidc/link", "", nil)
+			require.Equal(t, http.StatusOK, start.Code, start.Body.String())
+			var link oidcLink
+			require.NoError(t, json.NewDecoder(start.Body).Decode(&link))
+
+			rec := callback(t, h, idp, start, link.AuthorizationURL)
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			assert.Empty(t, rec.Header().Get("Authorization"))
+			if test.code != "" {
+				assert.Equal(t, test.code, problemCode(t, rec))
+				assert.Equal(t, "john", db.identities["subject-1"])
+				return
+			}
+			assert.Equal(t, "gopher", db.identities["subject-1"])
+
+			// The user now signs in with the identity.
+			rec = oidcLogin(t, h, idp)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, "gopher", tokenLogin(t, rec))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/http-server/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRoutesAreDocumented(t *testing.T) {
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	NewHandler(router, slog.New(slog.NewTextHandler(io.Discard, nil)), nil).RegisterRoutes()
+
+	registered := make(map[string]bool)
+	err = chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered[method+" "+route] = true
+
+		item := doc.Paths.Find(route)
+		if assert.NotNil(t, item, "route %s is not documented", route) {
+			assert.NotNil(t, item.GetOperation(method), "operation %s %s is not documented", method, route)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			assert.True(t, registered[strings.ToUpper(method)+" "+path], "documented operation %s %s is not registered", method, path)
+		}
+	}
+}
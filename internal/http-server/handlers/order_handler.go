@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
 	"github.com/kholodmv/gophermart/internal/models/order"
 	"github.com/kholodmv/gophermart/internal/storage/postgresql"
 	"github.com/kholodmv/gophermart/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slog"
 	"net/http"
 	"regexp"
@@ -21,29 +24,32 @@ func (mh *Handler) PostOrderNumber(res http.ResponseWriter, req *http.Request) {
 	)
 
 	var number int64
-	decoder := json.NewDecoder(req.Body)
-	err := decoder.Decode(&number)
+	err := decodeJSON(req, &number)
 	if err != nil {
 		mh.log.Error("Invalid request format")
-		http.Error(res, "Invalid request format", http.StatusBadRequest)
+		writeDecodeError(res, req, err)
 		return
 	}
 
 	validNumberPattern := regexp.MustCompile("^[0-9]+$")
 	if !validNumberPattern.MatchString(strconv.FormatInt(number, 10)) {
 		mh.log.Error("Invalid order number format")
-		http.Error(res, "Invalid order number format", http.StatusUnprocessableEntity)
+		problem.Error(res, req, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, "Invalid order number format")
 		return
 	}
 
 	if !utils.IsValidLuhnNumber(strconv.FormatInt(number, 10)) {
 		mh.log.Error("Invalid order number format")
-		http.Error(res, "Invalid order number format", http.StatusUnprocessableEntity)
+		problem.Error(res, req, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, "Invalid order number format")
 		return
 	}
 
 	login := utils.GetLogin(req.Context())
 
+	// The poller tags its spans the same way, which ties the upload to
+	// the accrual of the order.
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("order.number", strconv.FormatInt(number, 10)))
+
 	var orderNew order.Order
 	fullOrder := order.NewOrder(orderNew, login, number)
 	err = mh.db.AddOrder(req.Context(), fullOrder)
@@ -53,18 +59,13 @@ func (mh *Handler) PostOrderNumber(res http.ResponseWriter, req *http.Request) {
 			res.WriteHeader(http.StatusOK)
 			mh.log.Error("The order number has already been added by this user - ", res)
 			return
-		case errors.Is(err, postgresql.ErrorOrderExist):
-			res.WriteHeader(http.StatusConflict)
-			mh.log.Error("The order number has already been added by another user - ", res)
-			return
 		default:
-			res.WriteHeader(http.StatusInternalServerError)
-			mh.log.Error("Error adding order number - ", res)
+			mh.writeError(res, req, err)
 			return
 		}
 	}
 	res.WriteHeader(http.StatusAccepted)
-	mh.log.Info("New order number accepted for processing")
+	mh.log.InfoContext(req.Context(), "New order number accepted for processing")
 }
 
 func (mh *Handler) GetOrderNumbers(res http.ResponseWriter, req *http.Request) {
@@ -79,7 +80,7 @@ func (mh *Handler) GetOrderNumbers(res http.ResponseWriter, req *http.Request) {
 	orders, err := mh.db.GetOrders(req.Context(), login)
 	if err != nil {
 		mh.log.Error("New order number accepted for processing")
-		res.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(res, req)
 		return
 	}
 
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/notifier"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	resetTokenBytes = 32
+	resetTokenTTL   = 30 * time.Minute
+)
+
+func (mh *Handler) ChangePassword(res http.ResponseWriter, req *http.Request) {
+	const op = "password_handler.ChangePassword"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var change user.PasswordChange
+	if err := decodeJSON(req, &change); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	login := utils.GetLogin(req.Context())
+
+	u, err := mh.db.GetUser(req.Context(), login)
+	if err != nil {
+		mh.log.Error("error get user", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	if err = utils.CompareHashAndPassword(u.HashPassword, change.CurrentPassword); err != nil {
+		mh.log.Error("Invalid current password")
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid current password")
+		return
+	}
+
+	if violations := mh.policy.Validate(change.NewPassword, login); len(violations) > 0 {
+		mh.log.Error("New password violates password policy")
+		writeViolations(res, req, violations)
+		return
+	}
+
+	u.TokenVersion, err = mh.updatePassword(req, login, change.NewPassword)
+	if err != nil {
+		mh.log.Error("error update password", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	tokenString, err := auth.GenerateToken(*u)
+	if err != nil {
+		mh.log.Error("Error creating token")
+		writeInternalError(res, req)
+		return
+	}
+
+	res.Header().Set("Authorization", "Bearer "+tokenString)
+	res.WriteHeader(http.StatusOK)
+	mh.log.Info("Password successfully changed")
+}
+
+// RequestPasswordReset always answers 202 so that callers can't probe
+// which logins are registered.
+func (mh *Handler) RequestPasswordReset(res http.ResponseWriter, req *http.Request) {
+	const op = "password_handler.RequestPasswordReset"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var resetReq user.PasswordResetRequest
+	if err := decodeJSON(req, &resetReq); err != nil || resetReq.Login == "" {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	_, err := mh.db.GetUser(req.Context(), resetReq.Login)
+	switch {
+	case errors.Is(err, postgresql.ErrorUserNotFound):
+		mh.log.Info("Password reset requested for unknown login")
+		res.WriteHeader(http.StatusAccepted)
+		return
+	case err != nil:
+		mh.log.Error("error get user", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	token, err := auth.GenerateRandomToken(resetTokenBytes)
+	if err != nil {
+		mh.log.Error("error generate reset token", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	err = mh.db.AddPasswordReset(req.Context(), user.PasswordReset{
+		Login:     resetReq.Login,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	})
+	if err != nil {
+		mh.log.Error("error add password reset", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	err = mh.notifier.Notify(req.Context(), notifier.Message{
+		To:      resetReq.Login,
+		Subject: "Password reset",
+		Body:    "Use this token to reset your password within " + resetTokenTTL.String() + ": " + token,
+	})
+	if err != nil {
+		mh.log.Error("error deliver reset token", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+	mh.log.Info("Password reset token issued")
+}
+
+func (mh *Handler) ConfirmPasswordReset(res http.ResponseWriter, req *http.Request) {
+	const op = "password_handler.ConfirmPasswordReset"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var confirm user.PasswordResetConfirm
+	if err := decodeJSON(req, &confirm); err != nil || confirm.Token == "" {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	// The login is unknown until the token is consumed, so the policy is
+	// checked without it.
+	if violations := mh.policy.Validate(confirm.NewPassword, ""); len(violations) > 0 {
+		mh.log.Error("New password violates password policy")
+		writeViolations(res, req, violations)
+		return
+	}
+
+	hashPass, err := utils.GenerateHashPassword(confirm.NewPassword)
+	if err != nil {
+		mh.log.Error("error hash password", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	if _, err = mh.db.ResetPassword(req.Context(), auth.HashToken(confirm.Token), hashPass); err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+	mh.log.Info("Password successfully reset")
+}
+
+func (mh *Handler) updatePassword(req *http.Request, login string, password string) (int, error) {
+	hashPass, err := utils.GenerateHashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	return mh.db.UpdatePassword(req.Context(), login, hashPass)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-chi/chi/v5"
+	authn "github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/notifier"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type passwordStorage struct {
+	storage.Storage
+	resets    map[string]string
+	passwords map[string]string
+}
+
+func newPasswordStorage() *passwordStorage {
+	return &passwordStorage{resets: map[string]string{}, passwords: map[string]string{}}
+}
+
+func (s *passwordStorage) GetUser(_ context.Context, login string) (*user.User, error) {
+	if login != "gopher" {
+		return nil, postgresql.ErrorUserNotFound
+	}
+	return &user.User{Login: login}, nil
+}
+
+func (s *passwordStorage) AddPasswordReset(_ context.Context, r user.PasswordReset) error {
+	s.resets[r.TokenHash] = r.Login
+	return nil
+}
+
+func (s *passwordStorage) ResetPassword(_ context.Context, tokenHash string, hashPassword string) (string, error) {
+	login, ok := s.resets[tokenHash]
+	if !ok {
+		return "", postgresql.ErrorResetInvalid
+	}
+	for hash, l := range s.resets {
+		if l == login {
+			delete(s.resets, hash)
+		}
+	}
+	s.passwords[login] = hashPassword
+	return login, nil
+}
+
+type recordingNotifier struct {
+	messages []notifier.Message
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, msg notifier.Message) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		login string
+		sent  bool
+	}{
+		{name: "Known login", login: "gopher", sent: true},
+		{name: "Unknown login", login: "nobody"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newPasswordStorage()
+			n := &recordingNotifier{}
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, WithNotifier(n))
+			req := httptest.NewRequest(http.MethodPost, "/api/user/password/reset", strings.NewReader(`{"login":"`+test.login+`"}`))
+			rec := httptest.NewRecorder()
+
+			h.RequestPasswordReset(rec, req)
+
+			require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+			if !test.sent {
+				assert.Empty(t, n.messages)
+				assert.Empty(t, db.resets)
+				return
+			}
+			require.Len(t, n.messages, 1)
+			body := n.messages[0].Body
+			token := body[strings.LastIndex(body, " ")+1:]
+			assert.Equal(t, "gopher", db.resets[authn.HashToken(token)])
+		})
+	}
+}
+
+func TestRequestPasswordResetDoesNotLogToken(t *testing.T) {
+	var logs bytes.Buffer
+	db := newPasswordStorage()
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(&logs, nil)), db)
+	req := httptest.NewRequest(http.MethodPost, "/api/user/password/reset", strings.NewReader(`{"login":"gopher"}`))
+	rec := httptest.NewRecorder()
+
+	h.RequestPasswordReset(rec, req)
+
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	require.Len(t, db.resets, 1)
+	assert.NotContains(t, logs.String(), "Use this token")
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		password string
+		status   int
+		code     string
+	}{
+		{name: "Valid token", token: "valid", password: "correct-horse-battery", status: http.StatusOK},
+		{name: "Unknown token", token: "unknown", password: "correct-horse-battery", status: http.StatusBadRequest, code: problem.CodeResetTokenInvalid},
+		{name: "Weak password", token: "valid", password: "short", status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newPasswordStorage()
+			db.resets[authn.HashToken("valid")] = "gopher"
+			db.resets[authn.HashToken("other")] = "gopher"
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db)
+			body := `{"token":"` + test.token + `","new_password":"` + test.password + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/user/password/reset/confirm", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+
+			h.ConfirmPasswordReset(rec, req)
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.code != "" {
+				assert.Equal(t, test.code, problemCode(t, rec))
+				assert.Empty(t, db.passwords)
+				assert.Len(t, db.resets, 2)
+				return
+			}
+			require.NoError(t, utils.CompareHashAndPassword(db.passwords["gopher"], test.password))
+			assert.Empty(t, db.resets)
+		})
+	}
+}
@@ -3,24 +3,148 @@ package handlers
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/health"
 	"github.com/kholodmv/gophermart/internal/http-server/middleware/auth"
 	"github.com/kholodmv/gophermart/internal/http-server/middleware/gzip"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/limit"
 	mwLogger "github.com/kholodmv/gophermart/internal/http-server/middleware/logger"
+	"github.com/kholodmv/gophermart/internal/http-server/openapi"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/lockout"
+	"github.com/kholodmv/gophermart/internal/metrics"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/notifier"
+	"github.com/kholodmv/gophermart/internal/oidc"
 	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/tracing"
+	"github.com/kholodmv/gophermart/internal/validation"
 	"golang.org/x/exp/slog"
+	"time"
 )
 
 type Handler struct {
-	router chi.Router
-	log    *slog.Logger
-	db     storage.Storage
+	router   chi.Router
+	log      *slog.Logger
+	db       storage.Storage
+	notifier notifier.Notifier
+	guard    *lockout.Guard
+	policy   validation.PasswordPolicy
+	// otpThreshold is the withdrawal sum above which a second factor is
+	// required, zero disables the check.
+	otpThreshold float32
+	poller       OrderPoller
+	oidc         *oidc.Provider
+	validator    *openapi.Validator
+	stream       OrderStream
+	metrics      *metrics.Metrics
+	health       *health.Health
+	maxBodySize  int64
 }
 
-func NewHandler(router chi.Router, log *slog.Logger, db storage.Storage) *Handler {
+// DefaultMaxBodySize is the largest request body accepted unless
+// WithMaxBodySize says otherwise.
+const DefaultMaxBodySize = 1 << 20
+
+// smallBodySize bounds the bodies of routes that take a few short fields
+// and are open to anyone, or nearly so.
+const smallBodySize = 4 << 10
+
+type Option func(h *Handler)
+
+// WithNotifier sets the notifier used to deliver password reset tokens.
+func WithNotifier(n notifier.Notifier) Option {
+	return func(h *Handler) {
+		h.notifier = n
+	}
+}
+
+// WithLockout sets the guard that throttles failed logins.
+func WithLockout(g *lockout.Guard) Option {
+	return func(h *Handler) {
+		h.guard = g
+	}
+}
+
+// WithPasswordPolicy sets the policy new passwords have to satisfy.
+func WithPasswordPolicy(p validation.PasswordPolicy) Option {
+	return func(h *Handler) {
+		h.policy = p
+	}
+}
+
+// WithWithdrawalOTPThreshold requires a second factor for withdrawals
+// larger than the threshold.
+func WithWithdrawalOTPThreshold(threshold float32) Option {
+	return func(h *Handler) {
+		h.otpThreshold = threshold
+	}
+}
+
+// WithOrderPoller lets administrators force re-polling of an order.
+func WithOrderPoller(p OrderPoller) Option {
+	return func(h *Handler) {
+		h.poller = p
+	}
+}
+
+// WithOIDC enables login through an external OpenID Connect provider.
+func WithOIDC(p *oidc.Provider) Option {
+	return func(h *Handler) {
+		h.oidc = p
+	}
+}
+
+// WithValidator checks requests against the OpenAPI document.
+func WithValidator(v *openapi.Validator) Option {
+	return func(h *Handler) {
+		h.validator = v
+	}
+}
+
+// WithOrderStream enables streaming of order updates.
+func WithOrderStream(s OrderStream) Option {
+	return func(h *Handler) {
+		h.stream = s
+	}
+}
+
+// WithMetrics records request counts and latencies.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(h *Handler) {
+		h.metrics = m
+	}
+}
+
+// WithHealth sets the readiness checks, without them the service is ready
+// as long as it serves requests.
+func WithHealth(h *health.Health) Option {
+	return func(mh *Handler) {
+		mh.health = h
+	}
+}
+
+// WithMaxBodySize sets the largest request body accepted, in bytes.
+func WithMaxBodySize(n int64) Option {
+	return func(h *Handler) {
+		h.maxBodySize = n
+	}
+}
+
+func NewHandler(router chi.Router, log *slog.Logger, db storage.Storage, opts ...Option) *Handler {
 	h := &Handler{
-		router: router,
-		log:    log,
-		db:     db,
+		router:      router,
+		log:         log,
+		db:          db,
+		notifier:    notifier.Discard,
+		guard:       lockout.New(lockout.NewMemoryStore(), lockout.DefaultConfig()),
+		policy:      validation.DefaultPasswordPolicy(),
+		health:      health.New(time.Second),
+		maxBodySize: DefaultMaxBodySize,
+	}
+
+	for _, opt := range opts {
+		opt(h)
 	}
 
 	return h
@@ -28,21 +152,88 @@ func NewHandler(router chi.Router, log *slog.Logger, db storage.Storage) *Handle
 
 func (mh *Handler) RegisterRoutes() {
 	mh.router.Use(middleware.RequestID)
+	if mh.metrics != nil {
+		mh.router.Use(mh.metrics.Middleware)
+	}
+	mh.router.Use(tracing.Middleware)
 	mh.router.Use(mwLogger.New(mh.log))
 	mh.router.Use(middleware.Recoverer)
 	mh.router.Use(middleware.URLFormat)
 	mh.router.Use(gzip.GzipHandler)
+	mh.router.Use(limit.MaxBytes(mh.maxBodySize))
+	mh.router.NotFound(problem.NotFound)
+	mh.router.MethodNotAllowed(problem.MethodNotAllowed)
+	if mh.validator != nil {
+		mh.router.Use(mh.validator.Middleware)
+	}
+
+	mh.router.Get("/healthz", mh.health.Live)
+	mh.router.Get("/readyz", mh.health.Ready)
+	mh.router.Get("/api/openapi.json", openapi.Handler)
+
+	small := limit.MaxBytes(smallBodySize)
 
-	mh.router.Post("/api/user/register", mh.Register)
-	mh.router.Post("/api/user/login", mh.Login)
+	mh.router.With(small).Post("/api/user/register", mh.Register)
+	mh.router.With(small).Post("/api/user/login", mh.Login)
+	mh.router.With(small).Post("/api/user/password/reset", mh.RequestPasswordReset)
+	mh.router.With(small).Post("/api/user/password/reset/confirm", mh.ConfirmPasswordReset)
+	mh.router.Get("/api/user/oidc/login", mh.OIDCLogin)
+	mh.router.Get("/api/user/oidc/callback", mh.OIDCCallback)
+	mh.router.With(small).Post("/api/user/oidc/otp", mh.OIDCSecondFactor)
 
 	mh.router.Group(func(r chi.Router) {
-		r.Use(auth.AuthenticationMiddleware)
+		r.Use(auth.AuthenticationMiddleware(mh.db))
+
+		r.With(small, auth.RequireScope(apikey.ScopeOrdersWrite)).Post("/api/user/orders", mh.PostOrderNumber)
+		r.With(auth.RequireScope(apikey.ScopeOrdersRead)).Get("/api/user/orders", mh.GetOrderNumbers)
+		r.With(auth.RequireScope(apikey.ScopeOrdersRead)).Get("/api/user/orders/stream", mh.StreamOrders)
+		r.With(auth.RequireScope(apikey.ScopeBalanceRead)).Get("/api/user/balance", mh.GetBalance)
+		r.With(small, auth.RequireScope(apikey.ScopeBalanceWithdraw)).Post("/api/user/balance/withdraw", mh.PostWithdrawFromBalance)
+		r.With(auth.RequireScope(apikey.ScopeWithdrawalsRead)).Get("/api/user/withdrawals", mh.GetWithdrawals)
+	})
+
+	mh.router.Group(func(r chi.Router) {
+		r.Use(auth.AuthenticationMiddleware(mh.db))
+		r.Use(auth.SessionOnly)
+
+		r.Post("/api/user/password", mh.ChangePassword)
+		r.Post("/api/user/2fa/enroll", mh.EnrollTOTP)
+		r.Post("/api/user/2fa/confirm", mh.ConfirmTOTP)
+		r.Post("/api/user/2fa/disable", mh.DisableTOTP)
+		r.Post("/api/user/oidc/link", mh.OIDCLink)
+
+		r.Post("/api/user/keys", mh.CreateAPIKey)
+		r.Get("/api/user/keys", mh.GetAPIKeys)
+		r.Delete("/api/user/keys/{id}", mh.RevokeAPIKey)
+
+		r.Post("/api/user/webhooks", mh.CreateWebhook)
+		r.Get("/api/user/webhooks", mh.GetWebhooks)
+		r.Delete("/api/user/webhooks/{id}", mh.DeleteWebhook)
+		r.Get("/api/user/webhooks/{id}/deliveries", mh.GetWebhookDeliveries)
+	})
+
+	mh.router.Group(func(r chi.Router) {
+		r.Use(auth.AuthenticationMiddleware(mh.db))
+		r.Use(auth.SessionOnly)
+		r.Use(auth.RequireRole(user.RoleAdmin))
+
+		r.Delete("/api/admin/lockouts/{login}", mh.UnlockLogin)
+
+		r.Get("/api/admin/users", mh.SearchUsers)
+		r.Get("/api/admin/users/{login}", mh.GetUser)
+		r.Get("/api/admin/users/{login}/orders", mh.GetUserOrders)
+		r.Get("/api/admin/users/{login}/withdrawals", mh.GetUserWithdrawals)
+		r.Get("/api/admin/users/{login}/balance", mh.GetUserBalance)
+		r.Post("/api/admin/users/{login}/adjustments", mh.AdjustBalance)
+
+		r.Post("/api/admin/orders/{number}/repoll", mh.RepollOrder)
+		r.Post("/api/admin/orders/{number}/invalidate", mh.InvalidateOrder)
+
+		r.Post("/api/admin/webhooks", mh.CreateAdminWebhook)
+		r.Get("/api/admin/webhooks", mh.GetAdminWebhooks)
+		r.Delete("/api/admin/webhooks/{id}", mh.DeleteAdminWebhook)
+		r.Get("/api/admin/webhooks/{id}/deliveries", mh.GetAdminWebhookDeliveries)
 
-		r.Post("/api/user/orders", mh.PostOrderNumber)
-		r.Get("/api/user/orders", mh.GetOrderNumbers)
-		r.Get("/api/user/balance", mh.GetBalance)
-		r.Post("/api/user/balance/withdraw", mh.PostWithdrawFromBalance)
-		r.Get("/api/user/withdrawals", mh.GetWithdrawals)
+		r.Get("/api/admin/audit", mh.GetAuditLog)
 	})
 }
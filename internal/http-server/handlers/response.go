@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// storageProblems maps storage errors to the problems reported to
+// clients. Errors not listed here are internal.
+var storageProblems = []struct {
+	err    error
+	status int
+	code   string
+	detail string
+}{
+	{postgresql.ErrorUserNotFound, http.StatusNotFound, problem.CodeUserNotFound, "User not found"},
+	{postgresql.ErrorUserExists, http.StatusConflict, problem.CodeLoginTaken, "Login is already taken"},
+	{postgresql.ErrorOrderNotFound, http.StatusNotFound, problem.CodeOrderNotFound, "Order not found"},
+	{postgresql.ErrorNotFound, http.StatusNotFound, problem.CodeOrderNotFound, "Order not found"},
+	{postgresql.ErrorOrderExist, http.StatusConflict, problem.CodeOrderExists, "Order number has already been added by another user"},
+	{postgresql.ErrorNotEnoughFunds, http.StatusPaymentRequired, problem.CodeInsufficientFunds, "There are not enough funds on the account"},
+	{postgresql.ErrorAddWithdrawal, http.StatusConflict, problem.CodeWithdrawalConflict, "Order number has already been used for a withdrawal"},
+	{postgresql.ErrorResetInvalid, http.StatusBadRequest, problem.CodeResetTokenInvalid, "Invalid or expired reset token"},
+	{postgresql.ErrorTOTPNotFound, http.StatusConflict, problem.CodeTOTPNotEnrolled, "Two-factor authentication is not enrolled"},
+	{postgresql.ErrorAPIKeyNotFound, http.StatusNotFound, problem.CodeAPIKeyNotFound, "API key not found"},
+	{postgresql.ErrorWebhookNotFound, http.StatusNotFound, problem.CodeWebhookNotFound, "Webhook not found"},
+	{postgresql.ErrorIdentityLinked, http.StatusConflict, problem.CodeIdentityLinked, "Identity is already linked to another user"},
+	{postgresql.ErrorWebhookLimit, http.StatusConflict, problem.CodeWebhookLimit, "Too many webhooks, delete one first"},
+}
+
+// writeError reports a storage error, logging it if it is internal.
+func (mh *Handler) writeError(res http.ResponseWriter, req *http.Request, err error) {
+	for _, p := range storageProblems {
+		if errors.Is(err, p.err) {
+			problem.Error(res, req, p.status, p.code, p.detail)
+			return
+		}
+	}
+	mh.log.ErrorContext(req.Context(), "internal error", sl.Err(err))
+	writeInternalError(res, req)
+}
+
+// writeInternalError hides the cause, which only belongs in the logs.
+func writeInternalError(res http.ResponseWriter, req *http.Request) {
+	problem.Error(res, req, http.StatusInternalServerError, problem.CodeInternal, "")
+}
+
+// writeLocked reports that failed attempts block the login for now.
+func writeLocked(res http.ResponseWriter, req *http.Request, locked *auth.LockedError) {
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	problem.Error(res, req, http.StatusTooManyRequests, problem.CodeTooManyAttempts, "Too many failed attempts")
+}
+
+func writeViolations(res http.ResponseWriter, req *http.Request, violations []validation.Violation) {
+	problem.Write(res, req, problem.Validation(violations))
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"time"
+)
+
+// keepAliveInterval is how often an idle stream sends a comment, so that
+// proxies don't close the connection.
+const keepAliveInterval = 15 * time.Second
+
+// streamWriteTimeout is how long the next event may take to be written.
+// It covers the wait for the keep-alive, so a client that stops reading
+// is dropped soon after the send buffers fill up.
+const streamWriteTimeout = 2 * keepAliveInterval
+
+// OrderStream delivers updates of a user's orders as they happen.
+type OrderStream interface {
+	Subscribe(login string) (<-chan order.Order, func())
+}
+
+// StreamOrders pushes an "order" server-sent event whenever one of the
+// caller's orders changes its status or accrual.
+func (mh *Handler) StreamOrders(res http.ResponseWriter, req *http.Request) {
+	const op = "stream_handler.StreamOrders"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	if mh.stream == nil {
+		problem.NotFound(res, req)
+		return
+	}
+
+	updates, cancel := mh.stream.Subscribe(utils.GetLogin(req.Context()))
+	defer cancel()
+
+	rc := http.NewResponseController(res)
+	// Streams outlive the server timeouts, which are replaced by a write
+	// deadline that moves with every event. Writers that don't support
+	// deadlines keep the server ones.
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		mh.log.Error("error flush event stream", sl.Err(err))
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case o, ok := <-updates:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(o)
+			if err != nil {
+				mh.log.Error("error marshal order", sl.Err(err))
+				continue
+			}
+			if _, err = fmt.Fprintf(res, "event: order\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/auth"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/orderstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamOrders(t *testing.T) {
+	hub := orderstream.NewHub()
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), nil, WithOrderStream(hub))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.StreamOrders(w, r.WithContext(context.WithValue(r.Context(), auth.LoginKey, "gopher")))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The headers are flushed once the subscription is in place.
+	hub.Publish(order.Order{UserLogin: "mole", Number: "2377225624", Status: order.StatusProcessed})
+	hub.Publish(order.Order{UserLogin: "gopher", Number: "12345678903", Status: order.StatusProcessed, Accrual: 500})
+
+	lines := bufio.NewScanner(resp.Body)
+	var event []string
+	for lines.Scan() && lines.Text() != "" {
+		event = append(event, lines.Text())
+	}
+	require.Len(t, event, 2)
+	assert.Equal(t, "event: order", event[0])
+	assert.JSONEq(t, `{"number":"12345678903","status":"PROCESSED","accrual":500,"uploaded_at":"0001-01-01T00:00:00Z"}`, event[1][len("data: "):])
+
+	hub.Close()
+	for lines.Scan() {
+	}
+	assert.NoError(t, lines.Err(), "the stream ends when the hub closes")
+}
+
+func TestStreamOrdersOutlivesServerTimeouts(t *testing.T) {
+	hub := orderstream.NewHub()
+	defer hub.Close()
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), nil, WithOrderStream(hub))
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.StreamOrders(w, r.WithContext(context.WithValue(r.Context(), auth.LoginKey, "gopher")))
+	}))
+	srv.Config.ReadTimeout = 20 * time.Millisecond
+	srv.Config.WriteTimeout = 20 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	time.Sleep(100 * time.Millisecond)
+	hub.Publish(order.Order{UserLogin: "gopher", Number: "12345678903", Status: order.StatusProcessed})
+
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan(), "the stream is still open: %v", lines.Err())
+	assert.Equal(t, "event: order", lines.Text())
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/totp"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"golang.org/x/exp/slog"
+	"net/http"
+)
+
+const (
+	totpIssuer = "Gophermart"
+	// otpRequiredHeader marks 401 responses to a login that needs a
+	// second factor, otpHeader carries the code for large withdrawals.
+	otpRequiredHeader = "X-OTP-Required"
+	otpHeader         = "X-OTP"
+)
+
+func (mh *Handler) EnrollTOTP(res http.ResponseWriter, req *http.Request) {
+	const op = "totp_handler.EnrollTOTP"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	login := utils.GetLogin(req.Context())
+
+	current, err := mh.db.GetTOTP(req.Context(), login)
+	switch {
+	case err == nil && current.Enabled:
+		mh.log.Error("Two-factor authentication is already enabled")
+		problem.Error(res, req, http.StatusConflict, problem.CodeTOTPEnabled, "Two-factor authentication is already enabled")
+		return
+	case err != nil && !errors.Is(err, postgresql.ErrorTOTPNotFound):
+		mh.log.Error("error get totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		mh.log.Error("error generate totp secret", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+	codes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		mh.log.Error("error generate recovery codes", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashToken(c)
+	}
+
+	if err = mh.db.SetTOTP(req.Context(), login, secret, hashes); err != nil {
+		mh.log.Error("error set totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	enrollment := user.TOTPEnrollment{
+		Secret:        secret,
+		URI:           totp.URI(totpIssuer, login, secret),
+		RecoveryCodes: codes,
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	json.NewEncoder(res).Encode(enrollment)
+	mh.log.Info("Two-factor authentication enrollment started")
+}
+
+func (mh *Handler) ConfirmTOTP(res http.ResponseWriter, req *http.Request) {
+	const op = "totp_handler.ConfirmTOTP"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var code user.TOTPCode
+	if err := decodeJSON(req, &code); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	login := utils.GetLogin(req.Context())
+
+	t, err := mh.db.GetTOTP(req.Context(), login)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	// Only a code from the authenticator proves that enrollment worked,
+	// recovery codes are not accepted here.
+	err = auth.CheckSecondFactor(req.Context(), mh.guard, mh.log, login, utils.ClientIP(req), func() (bool, error) {
+		return auth.VerifyTOTP(req.Context(), mh.db, t, code.Code)
+	})
+	if !mh.secondFactorOK(res, req, err) {
+		return
+	}
+
+	if err = mh.db.EnableTOTP(req.Context(), login); err != nil {
+		mh.log.Error("error enable totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+	mh.log.Info("Two-factor authentication enabled")
+}
+
+func (mh *Handler) DisableTOTP(res http.ResponseWriter, req *http.Request) {
+	const op = "totp_handler.DisableTOTP"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var code user.TOTPCode
+	if err := decodeJSON(req, &code); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	login := utils.GetLogin(req.Context())
+
+	t, err := mh.db.GetTOTP(req.Context(), login)
+	switch {
+	case errors.Is(err, postgresql.ErrorTOTPNotFound):
+		res.WriteHeader(http.StatusOK)
+		return
+	case err != nil:
+		mh.log.Error("error get totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	if t.Enabled {
+		err = auth.CheckSecondFactor(req.Context(), mh.guard, mh.log, login, utils.ClientIP(req), func() (bool, error) {
+			return auth.VerifySecondFactor(req.Context(), mh.db, t, code.Code)
+		})
+		if !mh.secondFactorOK(res, req, err) {
+			return
+		}
+	}
+
+	if err = mh.db.DeleteTOTP(req.Context(), login); err != nil {
+		mh.log.Error("error delete totp", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+	mh.log.Info("Two-factor authentication disabled")
+}
+
+// secondFactorOK reports the result of auth.CheckSecondFactor while
+// managing two-factor authentication and whether it passed.
+func (mh *Handler) secondFactorOK(res http.ResponseWriter, req *http.Request, err error) bool {
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		mh.log.Error("Too many failed two-factor attempts")
+		writeLocked(res, req, locked)
+		return false
+	case errors.Is(err, auth.ErrInvalidOTP):
+		mh.log.Error("Invalid two-factor code")
+		problem.Error(res, req, http.StatusUnprocessableEntity, problem.CodeInvalidOTP, "Invalid two-factor code")
+		return false
+	case err != nil:
+		mh.log.Error("error verify two-factor code", sl.Err(err))
+		writeInternalError(res, req)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	authn "github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/lockout"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/kholodmv/gophermart/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type totpStorage struct {
+	storage.Storage
+	totp        *user.TOTP
+	lastStep    uint64
+	recovery    string
+	deleted     bool
+	withdrawals []withdraw.Withdraw
+}
+
+func (s *totpStorage) GetTOTP(_ context.Context, _ string) (*user.TOTP, error) {
+	if s.totp == nil {
+		return nil, postgresql.ErrorTOTPNotFound
+	}
+	t := *s.totp
+	return &t, nil
+}
+
+func (s *totpStorage) EnableTOTP(_ context.Context, _ string) error {
+	s.totp.Enabled = true
+	return nil
+}
+
+func (s *totpStorage) DeleteTOTP(_ context.Context, _ string) error {
+	s.deleted = true
+	return nil
+}
+
+func (s *totpStorage) UseTOTPStep(_ context.Context, _ string, step uint64) error {
+	if step <= s.lastStep {
+		return postgresql.ErrorTOTPReplay
+	}
+	s.lastStep = step
+	return nil
+}
+
+func (s *totpStorage) UseRecoveryCode(_ context.Context, _ string, codeHash string) error {
+	if s.recovery == "" || codeHash != s.recovery {
+		return postgresql.ErrorRecoveryCode
+	}
+	s.recovery = ""
+	return nil
+}
+
+func (s *totpStorage) AddWithdrawal(_ context.Context, wd withdraw.Withdraw, _ string) (*withdraw.Withdraw, error) {
+	s.withdrawals = append(s.withdrawals, wd)
+	return &wd, nil
+}
+
+func newTOTPStorage(t *testing.T, enabled bool) (*totpStorage, string) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	return &totpStorage{totp: &user.TOTP{Login: "gopher", Secret: secret, Enabled: enabled}}, secret
+}
+
+// newTestLockout throttles without delays between failures, so tests
+// only hit the lock.
+func newTestLockout() Option {
+	return WithLockout(lockout.New(lockout.NewMemoryStore(), lockout.Config{
+		MaxLoginAttempts: 5,
+		MaxIPAttempts:    20,
+		LockoutDuration:  time.Minute,
+		Window:           time.Minute,
+	}))
+}
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := totp.Code(secret, time.Now())
+	require.NoError(t, err)
+	return code
+}
+
+func serveAsGopher(h http.HandlerFunc, method string, target string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	req = req.WithContext(context.WithValue(req.Context(), auth.LoginKey, "gopher"))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	var p problem.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+	return p.Code
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	db, secret := newTOTPStorage(t, false)
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, newTestLockout())
+	code := currentCode(t, secret)
+
+	rec := serveAsGopher(h.ConfirmTOTP, http.MethodPost, "/api/user/totp/confirm", `{"code":"000000"}`, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeInvalidOTP, problemCode(t, rec))
+	assert.False(t, db.totp.Enabled)
+
+	rec = serveAsGopher(h.ConfirmTOTP, http.MethodPost, "/api/user/totp/confirm", `{"code":"`+code+`"}`, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.True(t, db.totp.Enabled)
+
+	// The same code can't be used twice.
+	rec = serveAsGopher(h.ConfirmTOTP, http.MethodPost, "/api/user/totp/confirm", `{"code":"`+code+`"}`, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeInvalidOTP, problemCode(t, rec))
+}
+
+func TestDisableTOTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    func(secret string) string
+		status  int
+		deleted bool
+	}{
+		{name: "Current code", code: func(secret string) string { return currentCode(t, secret) }, status: http.StatusOK, deleted: true},
+		{name: "Recovery code", code: func(string) string { return "abcd-efgh" }, status: http.StatusOK, deleted: true},
+		{name: "Invalid code", code: func(string) string { return "000000" }, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, secret := newTOTPStorage(t, true)
+			db.recovery = authn.HashToken(totp.NormalizeRecoveryCode("abcd-efgh"))
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, newTestLockout())
+
+			rec := serveAsGopher(h.DisableTOTP, http.MethodDelete, "/api/user/totp", `{"code":"`+test.code(secret)+`"}`, nil)
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			assert.Equal(t, test.deleted, db.deleted)
+		})
+	}
+}
+
+func TestSecondFactorIsThrottled(t *testing.T) {
+	db, secret := newTOTPStorage(t, true)
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, newTestLockout(), WithWithdrawalOTPThreshold(100))
+	body := `{"order":"2377225624","sum":500}`
+
+	for i := 0; i < 5; i++ {
+		rec := serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", body, http.Header{otpHeader: {"000000"}})
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Equal(t, problem.CodeInvalidOTP, problemCode(t, rec))
+	}
+
+	// Further guesses are refused, even with the right code, and so are
+	// guesses through the other endpoints checking the second factor.
+	rec := serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", body, http.Header{otpHeader: {currentCode(t, secret)}})
+	require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeTooManyAttempts, problemCode(t, rec))
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	rec = serveAsGopher(h.DisableTOTP, http.MethodDelete, "/api/user/totp", `{"code":"000000"}`, nil)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+	assert.Empty(t, db.withdrawals)
+	assert.False(t, db.deleted)
+}
+
+func TestWithdrawWithSecondFactor(t *testing.T) {
+	db, secret := newTOTPStorage(t, true)
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, newTestLockout(), WithWithdrawalOTPThreshold(100))
+	body := `{"order":"2377225624","sum":500}`
+	header := http.Header{otpHeader: {currentCode(t, secret)}}
+
+	rec := serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", body, header)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, db.withdrawals, 1)
+
+	// Replaying the code doesn't authorize another withdrawal.
+	rec = serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", body, header)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Equal(t, problem.CodeInvalidOTP, problemCode(t, rec))
+	assert.Len(t, db.withdrawals, 1)
+}
+
+func TestInvalidWithdrawalKeepsSecondFactor(t *testing.T) {
+	db, secret := newTOTPStorage(t, true)
+	db.recovery = authn.HashToken(totp.NormalizeRecoveryCode("abcd-efgh"))
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db, newTestLockout(), WithWithdrawalOTPThreshold(100))
+	code := currentCode(t, secret)
+
+	rec := serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", `{"order":"2377225625","sum":500}`, http.Header{otpHeader: {code}})
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	rec = serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", `{"order":"2377225625","sum":500}`, http.Header{otpHeader: {"abcd-efgh"}})
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	assert.Zero(t, db.lastStep, "the code wasn't used")
+	assert.NotEmpty(t, db.recovery, "the recovery code wasn't used")
+
+	rec = serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", `{"order":"2377225624","sum":500}`, http.Header{otpHeader: {code}})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Len(t, db.withdrawals, 1)
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
 	"github.com/kholodmv/gophermart/internal/logger/sl"
 	"github.com/kholodmv/gophermart/internal/models/user"
 	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
 	"golang.org/x/exp/slog"
 	"net/http"
 )
@@ -19,40 +21,44 @@ func (mh *Handler) Register(res http.ResponseWriter, req *http.Request) {
 	)
 
 	var newUser user.User
-	decoder := json.NewDecoder(req.Body)
-	err := decoder.Decode(&newUser)
+	err := decodeJSON(req, &newUser)
 	if err != nil {
 		mh.log.Error("Invalid request format", sl.Err(err))
-		http.Error(res, "Invalid request format", http.StatusBadRequest)
+		writeDecodeError(res, req, err)
 		return
 	}
 
-	var hashPass string
-	if newUser.HashPassword == "" {
-		hashPass, err = utils.GenerateHashPassword(newUser.Password)
-		newUser.HashPassword = hashPass
+	violations := append(validation.ValidateLogin(newUser.Login), mh.policy.Validate(newUser.Password, newUser.Login)...)
+	if len(violations) > 0 {
+		mh.log.Error("Invalid registration data")
+		writeViolations(res, req, violations)
+		return
 	}
 
+	newUser.Role = user.RoleUser
+	newUser.HashPassword, err = utils.GenerateHashPassword(newUser.Password)
 	if err != nil {
-		mh.log.Error("Error generate hash password")
+		mh.log.Error("Error generate hash password", sl.Err(err))
+		writeInternalError(res, req)
+		return
 	}
+
 	err = mh.db.AddUser(req.Context(), newUser)
 	if err != nil {
 		mh.log.Error("New user has not been register", sl.Err(err))
-		res.WriteHeader(http.StatusConflict)
+		mh.writeError(res, req, err)
 		return
 	}
 	mh.log.Info("User successfully registered")
 
-	tokenString, err := auth.GenerateToken(newUser.Login)
+	tokenString, err := auth.GenerateToken(newUser)
 	if err != nil {
 		mh.log.Error("Error creating token")
-		http.Error(res, "Error creating token", http.StatusInternalServerError)
+		writeInternalError(res, req)
 		return
 	}
 
 	res.Header().Set("Authorization", "Bearer "+tokenString)
-	mh.log.Info("tokenString is: " + tokenString)
 	res.WriteHeader(http.StatusOK)
 	mh.log.Info("User successfully registered and authenticated")
 }
@@ -65,32 +71,45 @@ func (mh *Handler) Login(res http.ResponseWriter, req *http.Request) {
 	)
 
 	var credentials user.User
-	decoder := json.NewDecoder(req.Body)
-	err := decoder.Decode(&credentials)
+	err := decodeJSON(req, &credentials)
 	if err != nil {
 		mh.log.Error("Invalid request format")
-		http.Error(res, "Invalid request format", http.StatusBadRequest)
+		writeDecodeError(res, req, err)
 		return
 	}
 
-	user1, err := mh.db.GetUser(req.Context(), credentials.Login)
-	if err != nil {
-		mh.log.Error("Invalid username/password pair")
-		http.Error(res, "Invalid username/password pair", http.StatusUnauthorized)
+	ip := utils.ClientIP(req)
+	user1, err := auth.Login(req.Context(), mh.db, mh.guard, mh.log, credentials, ip)
+	var locked *auth.LockedError
+	switch {
+	case errors.As(err, &locked):
+		mh.log.Error("Too many failed login attempts", slog.String("login", credentials.Login), slog.String("ip", ip))
+		writeLocked(res, req, locked)
 		return
-	}
-
-	err = utils.CompareHashAndPassword(user1.HashPassword, credentials.Password)
-	if err != nil {
+	case errors.Is(err, auth.ErrInvalidCredentials):
 		mh.log.Error("Invalid username/password pair")
-		http.Error(res, "Invalid username/password pair", http.StatusUnauthorized)
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid username/password pair")
+		return
+	case errors.Is(err, auth.ErrOTPRequired):
+		mh.log.Info("Two-factor code required")
+		res.Header().Set(otpRequiredHeader, "true")
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeOTPRequired, "Two-factor code required")
+		return
+	case errors.Is(err, auth.ErrInvalidOTP):
+		mh.log.Error("Invalid two-factor code")
+		res.Header().Set(otpRequiredHeader, "true")
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeInvalidOTP, "Invalid two-factor code")
+		return
+	case err != nil:
+		mh.log.Error("error login", sl.Err(err))
+		writeInternalError(res, req)
 		return
 	}
 
-	tokenString, err := auth.GenerateToken(credentials.Login)
+	tokenString, err := auth.GenerateToken(*user1)
 	if err != nil {
 		mh.log.Error("Error creating token")
-		http.Error(res, "Error creating token", http.StatusInternalServerError)
+		writeInternalError(res, req)
 		return
 	}
 
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterDoesNotLogToken(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(&logs, nil)), storagetest.NewMemory())
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(`{"login":"gopher","password":"Tunnel-Digger-42"}`))
+	rec := httptest.NewRecorder()
+
+	h.Register(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	token := strings.TrimPrefix(rec.Header().Get("Authorization"), "Bearer ")
+	require.NotEmpty(t, token)
+	assert.NotContains(t, logs.String(), token)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
+	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"github.com/kholodmv/gophermart/internal/webhooks"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDeliveriesLimit = 50
+
+// Webhooks of users receive the events of their owner. The admin
+// variants manage webhooks without an owner, which receive the events of
+// all users.
+
+func (mh *Handler) CreateWebhook(res http.ResponseWriter, req *http.Request) {
+	mh.createWebhook(res, req, utils.GetLogin(req.Context()))
+}
+
+func (mh *Handler) CreateAdminWebhook(res http.ResponseWriter, req *http.Request) {
+	mh.createWebhook(res, req, "")
+}
+
+func (mh *Handler) GetWebhooks(res http.ResponseWriter, req *http.Request) {
+	mh.getWebhooks(res, req, utils.GetLogin(req.Context()))
+}
+
+func (mh *Handler) GetAdminWebhooks(res http.ResponseWriter, req *http.Request) {
+	mh.getWebhooks(res, req, "")
+}
+
+func (mh *Handler) DeleteWebhook(res http.ResponseWriter, req *http.Request) {
+	mh.deleteWebhook(res, req, utils.GetLogin(req.Context()))
+}
+
+func (mh *Handler) DeleteAdminWebhook(res http.ResponseWriter, req *http.Request) {
+	mh.deleteWebhook(res, req, "")
+}
+
+func (mh *Handler) GetWebhookDeliveries(res http.ResponseWriter, req *http.Request) {
+	mh.getWebhookDeliveries(res, req, utils.GetLogin(req.Context()))
+}
+
+func (mh *Handler) GetAdminWebhookDeliveries(res http.ResponseWriter, req *http.Request) {
+	mh.getWebhookDeliveries(res, req, "")
+}
+
+func (mh *Handler) createWebhook(res http.ResponseWriter, req *http.Request, owner string) {
+	const op = "webhook_handler.CreateWebhook"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	var create webhook.CreateRequest
+	if err := decodeJSON(req, &create); err != nil {
+		mh.log.Error("Invalid request format")
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	if violations := validateWebhook(req.Context(), create); len(violations) > 0 {
+		writeViolations(res, req, violations)
+		return
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		mh.log.Error("error generate webhook secret", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	w, err := mh.db.AddWebhook(req.Context(), webhook.Webhook{
+		User:      owner,
+		URL:       strings.TrimSpace(create.URL),
+		Events:    create.Events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	if owner == "" {
+		mh.audit(req.Context(), audit.ActionWebhookCreate, strconv.FormatInt(w.ID, 10), w)
+	}
+	mh.log.Info("Webhook created", slog.Int64("id", w.ID))
+	writeJSON(res, http.StatusCreated, webhook.Created{Webhook: *w, Secret: secret})
+}
+
+func (mh *Handler) getWebhooks(res http.ResponseWriter, req *http.Request, owner string) {
+	const op = "webhook_handler.GetWebhooks"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	webhooks, err := mh.db.GetWebhooks(req.Context(), owner)
+	if err != nil {
+		mh.log.Error("error get webhooks", sl.Err(err))
+		writeInternalError(res, req)
+		return
+	}
+
+	if len(webhooks) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(res, http.StatusOK, webhooks)
+}
+
+func (mh *Handler) deleteWebhook(res http.ResponseWriter, req *http.Request, owner string) {
+	const op = "webhook_handler.DeleteWebhook"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	id, err := strconv.ParseInt(chi.URLParam(req, "id"), 10, 64)
+	if err != nil {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid webhook id")
+		return
+	}
+
+	if err = mh.db.DeleteWebhook(req.Context(), owner, id); err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	if owner == "" {
+		mh.audit(req.Context(), audit.ActionWebhookDelete, strconv.FormatInt(id, 10), nil)
+	}
+	res.WriteHeader(http.StatusNoContent)
+	mh.log.Info("Webhook deleted", slog.Int64("id", id))
+}
+
+func (mh *Handler) getWebhookDeliveries(res http.ResponseWriter, req *http.Request, owner string) {
+	const op = "webhook_handler.GetWebhookDeliveries"
+	mh.log.With(
+		slog.String("op", op),
+		slog.String("request_id", middleware.GetReqID(req.Context())),
+	)
+
+	id, err := strconv.ParseInt(chi.URLParam(req, "id"), 10, 64)
+	if err != nil {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid webhook id")
+		return
+	}
+
+	limit := queryInt(req, "limit", defaultDeliveriesLimit)
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultDeliveriesLimit
+	}
+
+	deliveries, err := mh.db.GetWebhookDeliveries(req.Context(), owner, id, limit)
+	if err != nil {
+		mh.writeError(res, req, err)
+		return
+	}
+
+	if len(deliveries) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(res, http.StatusOK, deliveries)
+}
+
+func validateWebhook(ctx context.Context, create webhook.CreateRequest) []validation.Violation {
+	var violations []validation.Violation
+
+	rawURL := strings.TrimSpace(create.URL)
+	u, err := url.Parse(rawURL)
+	switch {
+	case rawURL == "":
+		violations = append(violations, validation.Violation{
+			Field:   "url",
+			Code:    validation.CodeRequired,
+			Message: "url is required",
+		})
+	case len(rawURL) > webhook.MaxURLLength:
+		violations = append(violations, validation.Violation{
+			Field:   "url",
+			Code:    validation.CodeTooLong,
+			Message: "url must be at most " + strconv.Itoa(webhook.MaxURLLength) + " characters long",
+		})
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		violations = append(violations, validation.Violation{
+			Field:   "url",
+			Code:    validation.CodeInvalid,
+			Message: "url must be an absolute http or https URL",
+		})
+	case webhooks.CheckURL(ctx, rawURL) != nil:
+		violations = append(violations, validation.Violation{
+			Field:   "url",
+			Code:    validation.CodeInvalid,
+			Message: "url must point to a public address",
+		})
+	}
+
+	if len(create.Events) == 0 {
+		violations = append(violations, validation.Violation{
+			Field:   "events",
+			Code:    validation.CodeRequired,
+			Message: "at least one event is required, known events: " + strings.Join(event.Types, ", "),
+		})
+	}
+	for _, e := range create.Events {
+		if !event.ValidType(e) {
+			violations = append(violations, validation.Violation{
+				Field:   "events",
+				Code:    validation.CodeInvalid,
+				Message: "unknown event " + e + ", known events: " + strings.Join(event.Types, ", "),
+			})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type webhookStorage struct {
+	storage.Storage
+	count int
+	added []webhook.Webhook
+}
+
+func (s *webhookStorage) AddWebhook(_ context.Context, w webhook.Webhook) (*webhook.Webhook, error) {
+	if s.count >= webhook.MaxPerUser {
+		return nil, postgresql.ErrorWebhookLimit
+	}
+	s.count++
+	s.added = append(s.added, w)
+	return &w, nil
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		count  int
+		status int
+		code   string
+	}{
+		{name: "Public address", url: "https://93.184.216.34/hook", status: http.StatusCreated},
+		{name: "Loopback", url: "http://127.0.0.1:9090/metrics", status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+		{name: "Cloud metadata", url: "http://169.254.169.254/latest/meta-data", status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+		{name: "Private network", url: "http://192.168.1.10/hook", status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+		{name: "Limit reached", url: "https://93.184.216.34/hook", count: webhook.MaxPerUser, status: http.StatusConflict, code: problem.CodeWebhookLimit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &webhookStorage{count: test.count}
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db)
+			body := `{"url":"` + test.url + `","events":["order.processed"]}`
+			req := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", strings.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), auth.LoginKey, "gopher"))
+			rec := httptest.NewRecorder()
+
+			h.CreateWebhook(rec, req)
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.code == "" {
+				require.Len(t, db.added, 1)
+				assert.Equal(t, "gopher", db.added[0].User)
+				return
+			}
+			var p problem.Problem
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+			assert.Equal(t, test.code, p.Code)
+			assert.Empty(t, db.added)
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
 	"github.com/kholodmv/gophermart/internal/models/withdraw"
-	"github.com/kholodmv/gophermart/internal/storage/postgresql"
 	"github.com/kholodmv/gophermart/internal/utils"
+	"github.com/kholodmv/gophermart/internal/validation"
 	"golang.org/x/exp/slog"
 	"net/http"
 	"time"
@@ -19,31 +25,58 @@ func (mh *Handler) PostWithdrawFromBalance(res http.ResponseWriter, req *http.Re
 	)
 
 	var wd withdraw.Withdraw
-	if err := json.NewDecoder(req.Body).Decode(&wd); err != nil {
+	if err := decodeJSON(req, &wd); err != nil {
 		mh.log.Error("Invalid request format")
-		res.WriteHeader(http.StatusBadRequest)
+		writeDecodeError(res, req, err)
+		return
+	}
+
+	// Invalid requests must not use up the second factor.
+	if !mh.validWithdraw(res, req, wd) {
 		return
 	}
 
 	login := utils.GetLogin(req.Context())
 
+	if mh.otpThreshold > 0 && wd.Sum > mh.otpThreshold {
+		t, err := auth.EnabledTOTP(req.Context(), mh.db, login)
+		if err != nil {
+			mh.log.Error("error get totp", sl.Err(err))
+			writeInternalError(res, req)
+			return
+		}
+		if t == nil {
+			mh.log.Error("Two-factor authentication required for withdrawal")
+			problem.Error(res, req, http.StatusForbidden, problem.CodeOTPRequired, "Two-factor authentication must be enabled for this withdrawal")
+			return
+		}
+		err = auth.CheckSecondFactor(req.Context(), mh.guard, mh.log, login, utils.ClientIP(req), func() (bool, error) {
+			return auth.VerifySecondFactor(req.Context(), mh.db, t, req.Header.Get(otpHeader))
+		})
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			mh.log.Error("Too many failed two-factor attempts")
+			writeLocked(res, req, locked)
+			return
+		case errors.Is(err, auth.ErrInvalidOTP):
+			mh.log.Error("Invalid two-factor code")
+			problem.Error(res, req, http.StatusForbidden, problem.CodeInvalidOTP, "Invalid two-factor code")
+			return
+		case err != nil:
+			mh.log.Error("error verify two-factor code", sl.Err(err))
+			writeInternalError(res, req)
+			return
+		}
+	}
+
 	wd.User = login
 	createdTime := time.Now()
 	wd.ProcessedAt = &createdTime
 
-	w, err := mh.db.AddWithdrawal(req.Context(), wd, login)
-	switch err {
-	case postgresql.ErrorNotEnoughFunds:
-		http.Error(res, "there are not enough funds on the account", http.StatusPaymentRequired)
-		return
-	case postgresql.ErrorAddWithdrawal:
-		http.Error(res, "statusConflict", http.StatusConflict)
-		return
-	}
-
-	if !utils.IsValidLuhnNumber(w.Order) {
-		mh.log.Error("invalid order number format")
-		http.Error(res, "Invalid order number format", http.StatusUnprocessableEntity)
+	_, err := mh.db.AddWithdrawal(req.Context(), wd, login)
+	if err != nil {
+		mh.writeError(res, req, err)
 		return
 	}
 
@@ -51,6 +84,33 @@ func (mh *Handler) PostWithdrawFromBalance(res http.ResponseWriter, req *http.Re
 	res.WriteHeader(http.StatusOK)
 }
 
+// validWithdraw reports whether the withdrawal is valid, writing the
+// violations if it isn't. Invalid order numbers keep their own status, as
+// for uploaded orders.
+func (mh *Handler) validWithdraw(res http.ResponseWriter, req *http.Request, wd withdraw.Withdraw) bool {
+	if !utils.IsValidLuhnNumber(wd.Order) {
+		mh.log.Error("invalid order number format")
+		p := problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, "Invalid order number format")
+		p.Errors = []validation.Violation{{
+			Field:   "order",
+			Code:    validation.CodeInvalid,
+			Message: "order must be a number passing the Luhn check",
+		}}
+		problem.Write(res, req, p)
+		return false
+	}
+	if wd.Sum <= 0 {
+		mh.log.Error("invalid withdrawal sum")
+		writeViolations(res, req, []validation.Violation{{
+			Field:   "sum",
+			Code:    validation.CodeInvalid,
+			Message: "sum must be positive",
+		}})
+		return false
+	}
+	return true
+}
+
 func (mh *Handler) GetWithdrawals(res http.ResponseWriter, req *http.Request) {
 	const op = "withdrawal_handler.GetWithdrawals"
 	mh.log.With(
@@ -63,7 +123,7 @@ func (mh *Handler) GetWithdrawals(res http.ResponseWriter, req *http.Request) {
 	withdrawals, err := mh.db.GetWithdrawals(req.Context(), login)
 	if err != nil {
 		mh.log.Error("error get withdrawals")
-		res.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(res, req)
 		return
 	}
 
@@ -85,24 +145,16 @@ func (mh *Handler) GetBalance(res http.ResponseWriter, req *http.Request) {
 
 	login := utils.GetLogin(req.Context())
 
-	currentBalance, err := mh.db.GetAccruals(req.Context(), login)
+	balance, err := mh.balance(req.Context(), login)
 	if err != nil {
-		mh.log.Error("error get accruals")
-	}
-	withdrawnPoints, err := mh.db.GetWithdrawn(req.Context(), login)
-	if err != nil {
-		mh.log.Error("error get withdrawn")
-	}
-
-	balance := withdraw.Balance{
-		Current:   currentBalance - withdrawnPoints,
-		Withdrawn: withdrawnPoints,
+		mh.writeError(res, req, err)
+		return
 	}
 
 	responseJSON, err := json.Marshal(balance)
 	if err != nil {
 		mh.log.Error("error responseJSON")
-		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		writeInternalError(res, req)
 		return
 	}
 
@@ -111,3 +163,19 @@ func (mh *Handler) GetBalance(res http.ResponseWriter, req *http.Request) {
 	res.WriteHeader(http.StatusOK)
 	res.Write(responseJSON)
 }
+
+func (mh *Handler) balance(ctx context.Context, login string) (withdraw.Balance, error) {
+	currentBalance, err := mh.db.GetAccruals(ctx, login)
+	if err != nil {
+		return withdraw.Balance{}, fmt.Errorf("get accruals: %w", err)
+	}
+	withdrawnPoints, err := mh.db.GetWithdrawn(ctx, login)
+	if err != nil {
+		return withdraw.Balance{}, fmt.Errorf("get withdrawn: %w", err)
+	}
+
+	return withdraw.Balance{
+		Current:   currentBalance - withdrawnPoints,
+		Withdrawn: withdrawnPoints,
+	}, nil
+}
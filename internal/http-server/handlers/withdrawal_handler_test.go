@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestPostWithdrawFromBalance(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "Withdrawn", body: `{"order":"2377225624","sum":500}`, status: http.StatusOK},
+		{name: "Invalid order number", body: `{"order":"2377225625","sum":500}`, status: http.StatusUnprocessableEntity, code: problem.CodeInvalidOrderNumber},
+		{name: "Zero sum", body: `{"order":"2377225624","sum":0}`, status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+		{name: "Negative sum", body: `{"order":"2377225624","sum":-500}`, status: http.StatusBadRequest, code: problem.CodeValidationFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, _ := newTOTPStorage(t, false)
+			h := NewHandler(chi.NewRouter(), slog.New(slog.NewTextHandler(io.Discard, nil)), db)
+
+			rec := serveAsGopher(h.PostWithdrawFromBalance, http.MethodPost, "/api/user/balance/withdraw", test.body, nil)
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.code != "" {
+				assert.Equal(t, test.code, problemCode(t, rec))
+				assert.Empty(t, db.withdrawals)
+				return
+			}
+			assert.Len(t, db.withdrawals, 1)
+		})
+	}
+}
@@ -2,31 +2,116 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/storage"
 	"net/http"
 	"strings"
 )
 
 type key string
 
-var LoginKey key = "login"
+var (
+	LoginKey  key = "login"
+	RoleKey   key = "role"
+	ScopesKey key = "scopes"
+)
 
-func AuthenticationMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := strings.Split(r.Header.Get("Authorization"), "Bearer ")
-		if len(authHeader) != 2 {
-			http.Error(w, "malformed token", http.StatusUnauthorized)
-			return
-		}
+const apiKeyHeader = "X-API-Key"
 
-		token := authHeader[1]
-		login, err := auth.GetLogin(token)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+// AuthenticationMiddleware accepts either a bearer token or an API key,
+// see auth.Authenticate. Requests authenticated with an API key carry the
+// key's scopes in the context, see RequireScope.
+func AuthenticationMiddleware(db storage.Storage) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				token = ""
+			}
+			p, err := auth.Authenticate(r.Context(), db, token, apiKeyFromRequest(r))
+			switch {
+			case errors.Is(err, auth.ErrInvalidAPIKey):
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidAPIKey, "Invalid api key")
+				return
+			case errors.Is(err, auth.ErrNoCredentials):
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Missing or malformed bearer token")
+				return
+			case errors.Is(err, auth.ErrSessionRevoked):
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeSessionRevoked, "Session has been revoked")
+				return
+			case err != nil:
+				problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, err.Error())
+				return
+			}
+
+			newContext := context.WithValue(r.Context(), LoginKey, p.Login)
+			newContext = context.WithValue(newContext, RoleKey, p.Role)
+			if p.IsAPIKey() {
+				newContext = context.WithValue(newContext, ScopesKey, p.Scopes)
+			}
+			next.ServeHTTP(w, r.WithContext(newContext))
+		})
+	}
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if k := r.Header.Get(apiKeyHeader); k != "" {
+		return k
+	}
+	if k, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		return k
+	}
+	return ""
+}
+
+// RequireRole lets through only authenticated users having one of the
+// roles. It must be used after AuthenticationMiddleware.
+func RequireRole(roles ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value(RoleKey).(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "")
+		})
+	}
+}
+
+// RequireScope rejects API key requests whose key lacks the scope.
+// Requests authenticated with a token are not limited by scopes.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, isAPIKey := r.Context().Value(ScopesKey).([]string)
+			if !isAPIKey {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, s := range scopes {
+				if s == scope {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			problem.Error(w, r, http.StatusForbidden, problem.CodeInsufficientScope, "API key lacks scope "+scope)
+		})
+	}
+}
+
+// SessionOnly rejects requests authenticated with an API key, e.g. for
+// account management that needs an interactive login.
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, isAPIKey := r.Context().Value(ScopesKey).([]string); isAPIKey {
+			problem.Error(w, r, http.StatusForbidden, problem.CodeSessionRequired, "API keys are not allowed here")
 			return
 		}
-
-		newContext := context.WithValue(r.Context(), LoginKey, login)
-		next.ServeHTTP(w, r.WithContext(newContext))
+		next.ServeHTTP(w, r)
 	})
 }
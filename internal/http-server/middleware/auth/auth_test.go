@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var requireRoleTests = []struct {
+	name   string
+	role   interface{}
+	status int
+}{
+	{name: "Admin is allowed", role: "admin", status: http.StatusOK},
+	{name: "User is forbidden", role: "user", status: http.StatusForbidden},
+	{name: "Missing role is forbidden", role: nil, status: http.StatusForbidden},
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, test := range requireRoleTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/admin", nil)
+			if test.role != nil {
+				req = req.WithContext(context.WithValue(req.Context(), RoleKey, test.role))
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+var requireScopeTests = []struct {
+	name   string
+	scopes []string
+	status int
+}{
+	{name: "Token is not limited by scopes", scopes: nil, status: http.StatusOK},
+	{name: "API key with the scope is allowed", scopes: []string{"orders:read", "orders:write"}, status: http.StatusOK},
+	{name: "API key without the scope is forbidden", scopes: []string{"orders:read"}, status: http.StatusForbidden},
+	{name: "API key without scopes is forbidden", scopes: []string{}, status: http.StatusForbidden},
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := RequireScope("orders:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, test := range requireScopeTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+			if test.scopes != nil {
+				req = req.WithContext(context.WithValue(req.Context(), ScopesKey, test.scopes))
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestSessionOnly(t *testing.T) {
+	handler := SessionOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/keys", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = req.WithContext(context.WithValue(req.Context(), ScopesKey, []string{"orders:read"}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -2,6 +2,7 @@ package gzip
 
 import (
 	"compress/gzip"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
 	"io"
 	"net/http"
 	"strings"
@@ -16,6 +17,22 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends the data compressed so far, which streaming responses need.
+func (w gzipWriter) Flush() {
+	if f, ok := w.Writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to
+// change its deadlines.
+func (w gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func GzipHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
@@ -33,7 +50,7 @@ func GzipHandler(next http.Handler) http.Handler {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
 			r.Body, err = gzip.NewReader(r.Body)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid gzip request body")
 				return
 			}
 		}
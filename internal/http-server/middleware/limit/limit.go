@@ -0,0 +1,36 @@
+// Package limit bounds the size of request bodies.
+package limit
+
+import (
+	"errors"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"net/http"
+)
+
+// MaxBytes fails reads past n bytes of the request body. Bodies declaring
+// a larger length are rejected before the handler runs. Placed after the
+// gzip middleware it counts the decompressed bytes, so a small compressed
+// body can't expand without bound. Of nested limits the smallest wins.
+func MaxBytes(n int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				TooLarge(w, r)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsTooLarge reports whether err comes from reading past the limit.
+func IsTooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
+
+// TooLarge writes the problem of a body over the limit.
+func TooLarge(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "Request body is too large")
+}
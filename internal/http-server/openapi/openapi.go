@@ -0,0 +1,199 @@
+// Package openapi holds the OpenAPI document of the HTTP API and the
+// middleware that validates traffic against it.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/kholodmv/gophermart/internal/http-server/middleware/limit"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+func init() {
+	// Order numbers are uploaded as text/plain and order updates are
+	// streamed as text/event-stream, which kin-openapi has no decoders for.
+	openapi3filter.RegisterBodyDecoder("text/plain", decodeText)
+	openapi3filter.RegisterBodyDecoder("text/event-stream", decodeText)
+}
+
+func decodeText(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (interface{}, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Load parses and validates the embedded document.
+func Load() (*openapi3.T, error) {
+	const op = "openapi.Load"
+
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return doc, nil
+}
+
+// Handler serves the document as is.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+type Validator struct {
+	router    routers.Router
+	log       *slog.Logger
+	responses bool
+}
+
+// NewValidator returns a validator for the document. With responses set
+// it also checks what handlers send back; mismatches are only logged,
+// since the client is not to blame for them.
+func NewValidator(doc *openapi3.T, log *slog.Logger, responses bool) (*Validator, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi.NewValidator: %w", err)
+	}
+	return &Validator{router: router, log: log, responses: responses}, nil
+}
+
+// Middleware rejects requests which don't match the document with a
+// validation problem. Undocumented routes are passed through so the
+// router answers them as usual. Authentication is left to the auth
+// middleware.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params, err := v.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		if err = openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			if limit.IsTooLarge(err) {
+				limit.TooLarge(w, r)
+				return
+			}
+			p := problem.Validation(Violations(err))
+			p.Detail = "The request doesn't match the API specification"
+			problem.Write(w, r, p)
+			return
+		}
+
+		if !v.responses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.status,
+			Header:                 w.Header(),
+			Body:                   io.NopCloser(&rec.body),
+			Options: &openapi3filter.Options{
+				IncludeResponseStatus: true,
+			},
+		})
+		if err != nil {
+			v.log.Warn("response doesn't match the api specification",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				sl.Err(err),
+			)
+		}
+	})
+}
+
+// Violations turns validation errors into field-level violations.
+func Violations(err error) []validation.Violation {
+	return collect(err, "body")
+}
+
+func collect(err error, field string) []validation.Violation {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		var violations []validation.Violation
+		for _, inner := range e {
+			violations = append(violations, collect(inner, field)...)
+		}
+		return violations
+	case *openapi3filter.RequestError:
+		if e.Parameter != nil {
+			field = e.Parameter.Name
+		}
+		if e.Err != nil {
+			return collect(e.Err, field)
+		}
+		return []validation.Violation{{Field: field, Code: validation.CodeInvalid, Message: e.Reason}}
+	case *openapi3.SchemaError:
+		if pointer := e.JSONPointer(); len(pointer) > 0 {
+			field = strings.Join(pointer, ".")
+		}
+		code := validation.CodeInvalid
+		if e.SchemaField == "required" {
+			code = validation.CodeRequired
+		}
+		return []validation.Violation{{Field: field, Code: code, Message: e.Reason}}
+	}
+
+	code := validation.CodeInvalid
+	if err == openapi3filter.ErrInvalidRequired {
+		code = validation.CodeRequired
+	}
+	return []validation.Violation{{Field: field, Code: code, Message: err.Error()}}
+}
+
+// recorder passes the response through, keeping a copy for validation.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to flush event streams.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var middlewareTests = []struct {
+	name        string
+	method      string
+	path        string
+	contentType string
+	body        string
+	status      int
+	violations  []validation.Violation
+}{
+	{
+		name:        "Valid registration",
+		method:      http.MethodPost,
+		path:        "/api/user/register",
+		contentType: "application/json",
+		body:        `{"login":"gopher","password":"secret123"}`,
+		status:      http.StatusOK,
+	},
+	{
+		name:        "Missing password",
+		method:      http.MethodPost,
+		path:        "/api/user/register",
+		contentType: "application/json",
+		body:        `{"login":"gopher"}`,
+		status:      http.StatusBadRequest,
+		violations: []validation.Violation{
+			{Field: "password", Code: validation.CodeRequired},
+		},
+	},
+	{
+		name:        "Valid order number",
+		method:      http.MethodPost,
+		path:        "/api/user/orders",
+		contentType: "text/plain",
+		body:        "12345678903",
+		status:      http.StatusOK,
+	},
+	{
+		name:        "Order number with letters",
+		method:      http.MethodPost,
+		path:        "/api/user/orders",
+		contentType: "text/plain",
+		body:        "1234abc",
+		status:      http.StatusBadRequest,
+		violations: []validation.Violation{
+			{Field: "body", Code: validation.CodeInvalid},
+		},
+	},
+	{
+		name:   "Invalid query parameter",
+		method: http.MethodGet,
+		path:   "/api/admin/audit?limit=many",
+		status: http.StatusBadRequest,
+		violations: []validation.Violation{
+			{Field: "limit", Code: validation.CodeInvalid},
+		},
+	},
+	{
+		name:   "Undocumented route is passed through",
+		method: http.MethodGet,
+		path:   "/api/unknown",
+		status: http.StatusOK,
+	},
+}
+
+func TestMiddleware(t *testing.T) {
+	doc, err := Load()
+	require.NoError(t, err)
+	v, err := NewValidator(doc, slog.New(slog.NewTextHandler(io.Discard, nil)), false)
+	require.NoError(t, err)
+
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, test := range middlewareTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, test.status, rec.Code)
+			if test.violations == nil {
+				return
+			}
+
+			var p problem.Problem
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&p))
+			assert.Equal(t, problem.CodeValidationFailed, p.Code)
+			require.Len(t, p.Errors, len(test.violations))
+			for i, want := range test.violations {
+				assert.Equal(t, want.Field, p.Errors[i].Field)
+				assert.Equal(t, want.Code, p.Errors[i].Code)
+			}
+		})
+	}
+}
+
+func TestMiddlewareResponses(t *testing.T) {
+	doc, err := Load()
+	require.NoError(t, err)
+
+	var logs strings.Builder
+	v, err := NewValidator(doc, slog.New(slog.NewTextHandler(&logs, nil)), true)
+	require.NoError(t, err)
+
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"current":"a lot"}`)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/user/balance", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"current":"a lot"}`, rec.Body.String())
+	assert.Contains(t, logs.String(), "response doesn't match the api specification")
+}
@@ -0,0 +1,120 @@
+// Package problem writes error responses as RFC 7807 problem details.
+//
+// Every problem carries a stable machine-readable code, so clients can
+// react to errors without parsing the human-readable detail, and the
+// request ID set by middleware.RequestID to correlate reports with logs.
+package problem
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"net/http"
+)
+
+const ContentType = "application/problem+json"
+
+// Codes are part of the API contract: never change or reuse one.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeBodyTooLarge       = "body_too_large"
+	CodeValidationFailed   = "validation_failed"
+	CodeInvalidOrderNumber = "invalid_order_number"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeInvalidToken       = "invalid_token"
+	CodeSessionRevoked     = "session_revoked"
+	CodeInvalidAPIKey      = "invalid_api_key"
+	CodeOTPRequired        = "otp_required"
+	CodeInvalidOTP         = "invalid_otp"
+	CodeTOTPEnabled        = "totp_already_enabled"
+	CodeTOTPNotEnrolled    = "totp_not_enrolled"
+	CodeForbidden          = "forbidden"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeSessionRequired    = "session_required"
+	CodeNotFound           = "not_found"
+	CodeUserNotFound       = "user_not_found"
+	CodeOrderNotFound      = "order_not_found"
+	CodeAPIKeyNotFound     = "api_key_not_found"
+	CodeWebhookNotFound    = "webhook_not_found"
+	CodeWebhookLimit       = "webhook_limit_reached"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeLoginTaken         = "login_taken"
+	CodeOrderExists        = "order_exists"
+	CodeWithdrawalConflict = "withdrawal_conflict"
+	CodeInsufficientFunds  = "insufficient_funds"
+	CodeResetTokenInvalid  = "reset_token_invalid"
+	CodeTooManyAttempts    = "too_many_attempts"
+	CodeOIDCFailed         = "oidc_failed"
+	CodeOIDCSession        = "oidc_session_invalid"
+	CodeIdentityLinked     = "identity_linked"
+	CodeAccrualUnavailable = "accrual_unavailable"
+	CodeAccrualFailed      = "accrual_failed"
+	CodeInternal           = "internal_error"
+)
+
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail,omitempty"`
+	Instance  string                 `json:"instance,omitempty"`
+	Code      string                 `json:"code"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Errors    []validation.Violation `json:"errors,omitempty"`
+}
+
+// New returns a problem of the generic about:blank type, whose title is
+// the status text; the code tells problems with the same status apart.
+func New(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Validation returns a problem listing field-level violations.
+func Validation(violations []validation.Violation) *Problem {
+	p := New(http.StatusBadRequest, CodeValidationFailed, "The request contains invalid fields")
+	p.Errors = violations
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Code + ": " + p.Detail
+	}
+	return p.Code
+}
+
+// Write sends the problem, filling in the request path and ID.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = middleware.GetReqID(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error is a shortcut for Write(w, r, New(status, code, detail)).
+func Error(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	Write(w, r, New(status, code, detail))
+}
+
+// NotFound and MethodNotAllowed replace the router's plain-text defaults.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusNotFound, CodeNotFound, "")
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
+}
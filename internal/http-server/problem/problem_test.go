@@ -0,0 +1,63 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kholodmv/gophermart/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var writeTests = []struct {
+	name    string
+	problem *Problem
+	status  int
+	code    string
+	errors  int
+}{
+	{
+		name:    "Plain problem",
+		problem: New(http.StatusConflict, CodeLoginTaken, "Login is already taken"),
+		status:  http.StatusConflict,
+		code:    CodeLoginTaken,
+	},
+	{
+		name: "Validation problem",
+		problem: Validation([]validation.Violation{
+			{Field: "login", Code: validation.CodeRequired, Message: "login is required"},
+			{Field: "password", Code: validation.CodeTooShort, Message: "password is too short"},
+		}),
+		status: http.StatusBadRequest,
+		code:   CodeValidationFailed,
+		errors: 2,
+	},
+}
+
+func TestWrite(t *testing.T) {
+	for _, test := range writeTests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/user/register", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "req-1"))
+			rec := httptest.NewRecorder()
+
+			Write(rec, req, test.problem)
+
+			assert.Equal(t, test.status, rec.Code)
+			assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+
+			var got Problem
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+			assert.Equal(t, "about:blank", got.Type)
+			assert.Equal(t, http.StatusText(test.status), got.Title)
+			assert.Equal(t, test.status, got.Status)
+			assert.Equal(t, test.code, got.Code)
+			assert.Equal(t, "/api/user/register", got.Instance)
+			assert.Equal(t, "req-1", got.RequestID)
+			assert.Len(t, got.Errors, test.errors)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Package leader elects one replica to run the jobs that must not run
+// concurrently.
+//
+// Replicas race for a lock that lives as long as a database session, see
+// postgresql.Storage.TryLock. The winner leads until its session is gone:
+// the session is checked every KeepAlive interval and leadership is given
+// up as soon as a check fails. The others retry every RetryInterval and
+// take over once the lock is released, which Postgres does when the
+// session of a dead leader ends. For a short time after a network
+// failure both may believe they lead, so led work must be idempotent.
+package leader
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"golang.org/x/exp/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Session is a held lock.
+type Session interface {
+	// KeepAlive fails once the lock may have been lost.
+	KeepAlive(ctx context.Context) error
+	Release(ctx context.Context) error
+}
+
+// Acquire tries to take the lock, reporting false when another replica
+// holds it.
+type Acquire func(ctx context.Context) (Session, bool, error)
+
+type Config struct {
+	// RetryInterval is how often a follower tries to take the lock.
+	RetryInterval time.Duration
+	// KeepAlive is how often the leader checks its session.
+	KeepAlive time.Duration
+	// Timeout limits a single lock, keep-alive or release call.
+	Timeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		RetryInterval: 10 * time.Second,
+		KeepAlive:     5 * time.Second,
+		Timeout:       3 * time.Second,
+	}
+}
+
+type Elector struct {
+	acquire Acquire
+	log     *slog.Logger
+	cfg     Config
+	leading atomic.Bool
+}
+
+func New(acquire Acquire, log *slog.Logger, cfg Config) *Elector {
+	return &Elector{
+		acquire: acquire,
+		log:     log.With(slog.String("component", "leader")),
+		cfg:     cfg,
+	}
+}
+
+// IsLeader reports whether this replica leads at the moment.
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run calls lead every time this replica is elected, with a context that
+// is cancelled when leadership is lost. The lock is released once lead
+// returns. Run returns when ctx is done.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context) error) error {
+	ticker := time.NewTicker(e.cfg.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		if session := e.elect(ctx); session != nil {
+			e.lead(ctx, session, lead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) elect(ctx context.Context) Session {
+	callCtx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+
+	session, ok, err := e.acquire(callCtx)
+	if err != nil {
+		if ctx.Err() == nil {
+			e.log.Warn("failed to take leader lock", sl.Err(err))
+		}
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+func (e *Elector) lead(ctx context.Context, session Session, lead func(ctx context.Context) error) {
+	e.leading.Store(true)
+	e.log.Info("elected leader")
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- lead(leadCtx)
+	}()
+
+	ticker := time.NewTicker(e.cfg.KeepAlive)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		select {
+		case err = <-done:
+			done = nil
+			if err != nil && ctx.Err() == nil {
+				e.log.Error("leader stopped", sl.Err(err))
+			}
+			break loop
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			if err = e.keepAlive(ctx, session); err != nil {
+				if ctx.Err() == nil {
+					e.log.Error("lost leadership", sl.Err(err))
+				}
+				break loop
+			}
+		}
+	}
+
+	// Led work stops before anyone else may take over.
+	cancel()
+	if done != nil {
+		<-done
+	}
+	e.leading.Store(false)
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancelRelease()
+	if err = session.Release(releaseCtx); err != nil {
+		e.log.Warn("failed to release leader lock", sl.Err(err))
+	}
+	e.log.Info("stepped down")
+}
+
+func (e *Elector) keepAlive(ctx context.Context, session Session) error {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	return session.KeepAlive(ctx)
+}
@@ -0,0 +1,164 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDisconnected = errors.New("connection lost")
+
+// fakeLock is a lock shared by replicas, each of which can lose its
+// database connection.
+type fakeLock struct {
+	mu     sync.Mutex
+	holder string
+	down   map[string]bool
+}
+
+type fakeSession struct {
+	lock    *fakeLock
+	replica string
+}
+
+func (l *fakeLock) acquire(replica string) Acquire {
+	return func(context.Context) (Session, bool, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.down[replica] {
+			return nil, false, errDisconnected
+		}
+		if l.holder != "" {
+			return nil, false, nil
+		}
+		l.holder = replica
+		return &fakeSession{lock: l, replica: replica}, true, nil
+	}
+}
+
+// disconnect ends the session of the replica, which frees the lock.
+func (l *fakeLock) disconnect(replica string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.down[replica] = true
+	if l.holder == replica {
+		l.holder = ""
+	}
+}
+
+func (s *fakeSession) KeepAlive(context.Context) error {
+	s.lock.mu.Lock()
+	defer s.lock.mu.Unlock()
+	if s.lock.down[s.replica] {
+		return errDisconnected
+	}
+	return nil
+}
+
+func (s *fakeSession) Release(context.Context) error {
+	s.lock.mu.Lock()
+	defer s.lock.mu.Unlock()
+	if s.lock.holder == s.replica {
+		s.lock.holder = ""
+	}
+	return nil
+}
+
+func newElector(acquire Acquire) *Elector {
+	return New(acquire, slog.New(slog.NewTextHandler(io.Discard, nil)), Config{
+		RetryInterval: 5 * time.Millisecond,
+		KeepAlive:     5 * time.Millisecond,
+		Timeout:       time.Second,
+	})
+}
+
+// leads runs e and reports the replica name every time lead is called.
+func leads(ctx context.Context, e *Elector, replica string, elected chan<- string) {
+	go e.Run(ctx, func(ctx context.Context) error {
+		elected <- replica
+		<-ctx.Done()
+		return nil
+	})
+}
+
+func TestFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lock := &fakeLock{down: make(map[string]bool)}
+	a := newElector(lock.acquire("a"))
+	b := newElector(lock.acquire("b"))
+	elected := make(chan string, 2)
+
+	leads(ctx, a, "a", elected)
+	require.Equal(t, "a", <-elected)
+	assert.True(t, a.IsLeader())
+
+	leads(ctx, b, "b", elected)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, b.IsLeader(), "only one replica leads")
+
+	lock.disconnect("a")
+
+	select {
+	case replica := <-elected:
+		assert.Equal(t, "b", replica)
+	case <-time.After(time.Second):
+		t.Fatal("leadership didn't fail over")
+	}
+	assert.Eventually(t, func() bool { return !a.IsLeader() }, time.Second, time.Millisecond)
+	assert.True(t, b.IsLeader())
+}
+
+func TestStepDownWhenLeadFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lock := &fakeLock{down: make(map[string]bool)}
+	e := newElector(lock.acquire("a"))
+
+	var mu sync.Mutex
+	terms := 0
+	go e.Run(ctx, func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		terms++
+		return errors.New("job crashed")
+	})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return terms >= 2
+	}, time.Second, time.Millisecond, "the lock is released and taken again")
+}
+
+func TestRunStopsLeading(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lock := &fakeLock{down: make(map[string]bool)}
+	e := newElector(lock.acquire("a"))
+
+	stopped := make(chan struct{})
+	go func() {
+		e.Run(ctx, func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+		close(stopped)
+	}()
+	require.Eventually(t, e.IsLeader, time.Second, time.Millisecond)
+
+	cancel()
+	<-stopped
+	assert.False(t, e.IsLeader())
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	assert.Empty(t, lock.holder, "the lock is released")
+}
@@ -0,0 +1,207 @@
+// Package lifecycle runs the servers and background workers of the
+// application and shuts them down in order.
+//
+// Shutdown first stops the servers, so that no new work comes in and the
+// requests in flight finish. Then the root context is cancelled, which
+// stops the workers and aborts their storage and HTTP calls. Servers and
+// workers share one deadline; whatever is still running when it passes is
+// abandoned and reported. Cleanups such as flushing traces and closing the
+// database run last, with a deadline of their own, so that they still run
+// when the workers used up theirs.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"golang.org/x/exp/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cleanupTimeout limits how long the cleanups take together.
+const cleanupTimeout = 5 * time.Second
+
+// ErrAbandoned is wrapped by the errors of workers that drop work when
+// stopped, which is expected on shutdown; the work is reported in
+// Report.Abandoned instead of as an error.
+var ErrAbandoned = errors.New("abandoned")
+
+type Manager struct {
+	log     *slog.Logger
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// failed is closed when a worker stops on its own with an error.
+	failed     chan struct{}
+	failedOnce sync.Once
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	running  map[string]int
+	dropped  []string
+	errs     []error
+	servers  []step
+	cleanups []step
+}
+
+type step struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Report tells what shutdown didn't finish.
+type Report struct {
+	// Abandoned lists the workers still running at the deadline and the
+	// work dropped by workers when stopped, see ErrAbandoned.
+	Abandoned []string
+	// Errors holds the worker failures and the steps that failed or timed
+	// out.
+	Errors []error
+}
+
+func (r Report) Clean() bool {
+	return len(r.Abandoned) == 0 && len(r.Errors) == 0
+}
+
+// New returns a manager whose shutdown takes at most timeout.
+func New(log *slog.Logger, timeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		log:     log.With(slog.String("component", "lifecycle")),
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+		failed:  make(chan struct{}),
+		running: make(map[string]int),
+	}
+}
+
+// Context is cancelled once the servers are stopped. It is the root of
+// all work, including requests.
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Go runs a worker. It must return once its context is cancelled; a worker
+// failing before that brings the application down.
+func (m *Manager) Go(name string, run func(ctx context.Context) error) {
+	m.mu.Lock()
+	m.running[name]++
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := run(m.ctx)
+
+		m.mu.Lock()
+		m.running[name]--
+		if m.running[name] == 0 {
+			delete(m.running, name)
+		}
+		switch {
+		case errors.Is(err, ErrAbandoned) && m.ctx.Err() != nil:
+			m.dropped = append(m.dropped, fmt.Sprintf("%s: %v", name, err))
+			err = nil
+		case err != nil:
+			m.errs = append(m.errs, fmt.Errorf("%s: %w", name, err))
+		}
+		m.mu.Unlock()
+
+		if err != nil && m.ctx.Err() == nil {
+			m.log.Error("worker failed", slog.String("worker", name), sl.Err(err))
+			m.failedOnce.Do(func() { close(m.failed) })
+		}
+	}()
+}
+
+// OnStop registers a server to stop before the workers, in the order of
+// registration. Stop should let the requests in flight finish until the
+// context is done.
+func (m *Manager) OnStop(name string, stop func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers = append(m.servers, step{name: name, run: stop})
+}
+
+// OnCleanup registers a step to run after the workers stopped, in the
+// order of registration.
+func (m *Manager) OnCleanup(name string, cleanup func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cleanups = append(m.cleanups, step{name: name, run: cleanup})
+}
+
+// Wait blocks until ctx is done, typically on a signal, or a worker fails,
+// and then shuts down.
+func (m *Manager) Wait(ctx context.Context) Report {
+	select {
+	case <-ctx.Done():
+		m.log.Info("shutting down")
+	case <-m.failed:
+		m.log.Error("shutting down after a worker failed")
+	}
+	return m.Shutdown()
+}
+
+// Shutdown stops everything and logs what was abandoned.
+func (m *Manager) Shutdown() Report {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	m.mu.Lock()
+	servers, cleanups := m.servers, m.cleanups
+	m.mu.Unlock()
+
+	var errs []error
+	for _, s := range servers {
+		if err := s.run(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", s.name, err))
+		}
+	}
+
+	m.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	report := Report{
+		Abandoned: append([]string(nil), m.dropped...),
+		Errors:    append(append([]error(nil), m.errs...), errs...),
+	}
+	for name := range m.running {
+		report.Abandoned = append(report.Abandoned, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(report.Abandoned)
+
+	cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancelCleanup()
+	for _, c := range cleanups {
+		if err := c.run(cleanupCtx); err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("cleanup %s: %w", c.name, err))
+		}
+	}
+
+	if report.Clean() {
+		m.log.Info("shutdown complete")
+	} else {
+		attrs := []any{slog.Any("abandoned", report.Abandoned)}
+		if err := errors.Join(report.Errors...); err != nil {
+			attrs = append(attrs, sl.Err(err))
+		}
+		m.log.Warn("shutdown incomplete", attrs...)
+	}
+	return report
+}
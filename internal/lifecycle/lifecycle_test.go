@@ -0,0 +1,140 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newManager(timeout time.Duration) *Manager {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), timeout)
+}
+
+func TestShutdownOrder(t *testing.T) {
+	m := newManager(time.Second)
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+
+	m.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		record("worker")
+		return nil
+	})
+	m.OnStop("http", func(context.Context) error {
+		require.NoError(t, m.Context().Err(), "servers stop before the workers")
+		record("http")
+		return nil
+	})
+	m.OnStop("grpc", func(context.Context) error {
+		record("grpc")
+		return nil
+	})
+	m.OnCleanup("database", func(context.Context) error {
+		record("database")
+		return nil
+	})
+
+	report := m.Shutdown()
+
+	assert.True(t, report.Clean(), report)
+	assert.Equal(t, []string{"http", "grpc", "worker", "database"}, steps)
+}
+
+func TestShutdownAbandonsWorkers(t *testing.T) {
+	m := newManager(50 * time.Millisecond)
+
+	release := make(chan struct{})
+	defer close(release)
+	m.Go("stuck", func(context.Context) error {
+		<-release
+		return nil
+	})
+	m.Go("polite", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	var cleanupErr error
+	m.OnCleanup("database", func(ctx context.Context) error {
+		cleanupErr = ctx.Err()
+		return nil
+	})
+
+	report := m.Shutdown()
+
+	assert.Equal(t, []string{"stuck"}, report.Abandoned)
+	assert.NoError(t, cleanupErr, "cleanups get a deadline of their own")
+}
+
+func TestShutdownReportsDroppedWork(t *testing.T) {
+	m := newManager(time.Second)
+
+	m.Go("poller", func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("polls %w: 3", ErrAbandoned)
+	})
+
+	report := m.Shutdown()
+
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, []string{"poller: polls abandoned: 3"}, report.Abandoned)
+}
+
+func TestShutdownReportsErrors(t *testing.T) {
+	m := newManager(time.Second)
+
+	errDrain := errors.New("drain failed")
+	m.Go("poller", func(ctx context.Context) error {
+		<-ctx.Done()
+		return errDrain
+	})
+	m.OnStop("http", func(ctx context.Context) error {
+		return context.DeadlineExceeded
+	})
+
+	report := m.Shutdown()
+
+	assert.Empty(t, report.Abandoned)
+	require.Len(t, report.Errors, 2)
+	assert.ErrorIs(t, report.Errors[0], errDrain)
+	assert.ErrorIs(t, report.Errors[1], context.DeadlineExceeded)
+}
+
+func TestWaitReturnsOnWorkerFailure(t *testing.T) {
+	m := newManager(time.Second)
+
+	errListen := errors.New("address in use")
+	m.Go("http", func(context.Context) error {
+		return errListen
+	})
+	m.Go("worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	done := make(chan Report)
+	go func() {
+		done <- m.Wait(context.Background())
+	}()
+
+	select {
+	case report := <-done:
+		require.Len(t, report.Errors, 1)
+		assert.ErrorIs(t, report.Errors[0], errListen)
+		assert.Empty(t, report.Abandoned)
+	case <-time.After(time.Second):
+		t.Fatal("wait didn't return after the worker failed")
+	}
+}
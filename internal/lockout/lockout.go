@@ -0,0 +1,230 @@
+package lockout
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// MaxLoginAttempts and MaxIPAttempts are the numbers of consecutive
+	// failures after which a login or a client address is locked.
+	MaxLoginAttempts int
+	MaxIPAttempts    int
+	// LockoutDuration is how long a lock lasts once a threshold is reached.
+	LockoutDuration time.Duration
+	// BaseDelay is the wait after the first failure; it doubles with every
+	// further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long failures are remembered without new ones.
+	Window time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxLoginAttempts: 5,
+		MaxIPAttempts:    20,
+		LockoutDuration:  15 * time.Minute,
+		BaseDelay:        time.Second,
+		MaxDelay:         30 * time.Second,
+		Window:           15 * time.Minute,
+	}
+}
+
+// Guard tracks failed logins per login and per client address.
+type Guard struct {
+	store Store
+	cfg   atomic.Pointer[Config]
+	now   func() time.Time
+}
+
+func New(store Store, cfg Config) *Guard {
+	g := &Guard{
+		store: store,
+		now:   time.Now,
+	}
+	g.SetConfig(cfg)
+	return g
+}
+
+// SetConfig replaces the thresholds. Failures recorded so far are kept
+// and judged by the new ones.
+func (g *Guard) SetConfig(cfg Config) {
+	g.cfg.Store(&cfg)
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check reports how long the caller has to wait before the next attempt
+// for the login from the address is allowed. Zero means go ahead.
+func (g *Guard) Check(ctx context.Context, login string, ip string) (time.Duration, error) {
+	cfg := g.cfg.Load()
+	now := g.now()
+	var wait time.Duration
+	for _, key := range []string{loginKey(login), ipKey(ip)} {
+		e, ok, err := g.store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if d := retryAfter(cfg, e, now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Begin is Check for an attempt about to be made: if it may go ahead, it
+// is counted as failed right away, in the same step. Concurrent attempts
+// then have to wait as if it had failed, so they can't all slip through
+// before the first failure is recorded. The attempt ends with Succeed,
+// or Cancel when it turns out not to count; a failed one needs nothing.
+func (g *Guard) Begin(ctx context.Context, login string, ip string) (time.Duration, error) {
+	cfg := g.cfg.Load()
+	wait, err := g.begin(ctx, cfg, loginKey(login), cfg.MaxLoginAttempts)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	wait, err = g.begin(ctx, cfg, ipKey(ip), cfg.MaxIPAttempts)
+	if err == nil && wait > 0 {
+		err = g.refund(ctx, cfg, loginKey(login), cfg.MaxLoginAttempts)
+	}
+	return wait, err
+}
+
+// Fail records a failed attempt for the login from the address.
+func (g *Guard) Fail(ctx context.Context, login string, ip string) error {
+	cfg := g.cfg.Load()
+	if err := g.fail(ctx, cfg, loginKey(login), cfg.MaxLoginAttempts); err != nil {
+		return err
+	}
+	return g.fail(ctx, cfg, ipKey(ip), cfg.MaxIPAttempts)
+}
+
+// Succeed ends an attempt begun with Begin and forgets failures of the
+// login. Earlier failures of the address are kept so that a valid
+// account can't be used to reset the counter.
+func (g *Guard) Succeed(ctx context.Context, login string, ip string) error {
+	cfg := g.cfg.Load()
+	if err := g.store.Delete(ctx, loginKey(login)); err != nil {
+		return err
+	}
+	return g.refund(ctx, cfg, ipKey(ip), cfg.MaxIPAttempts)
+}
+
+// Cancel takes back an attempt begun with Begin that neither failed nor
+// succeeded.
+func (g *Guard) Cancel(ctx context.Context, login string, ip string) error {
+	cfg := g.cfg.Load()
+	if err := g.refund(ctx, cfg, loginKey(login), cfg.MaxLoginAttempts); err != nil {
+		return err
+	}
+	return g.refund(ctx, cfg, ipKey(ip), cfg.MaxIPAttempts)
+}
+
+// Unlock lifts a lock of the login and forgets its failures.
+func (g *Guard) Unlock(ctx context.Context, login string) error {
+	return g.store.Delete(ctx, loginKey(login))
+}
+
+func (g *Guard) fail(ctx context.Context, cfg *Config, key string, maxAttempts int) error {
+	_, err := g.store.Update(ctx, key, func(e Entry, ok bool) Entry {
+		return record(cfg, e, ok, g.now(), maxAttempts)
+	})
+	return err
+}
+
+func (g *Guard) begin(ctx context.Context, cfg *Config, key string, maxAttempts int) (time.Duration, error) {
+	var wait time.Duration
+	_, err := g.store.Update(ctx, key, func(e Entry, ok bool) Entry {
+		now := g.now()
+		if ok {
+			if wait = retryAfter(cfg, e, now); wait > 0 {
+				return e
+			}
+		}
+		return record(cfg, e, ok, now, maxAttempts)
+	})
+	return wait, err
+}
+
+// refund takes back the last failure counted for key.
+func (g *Guard) refund(ctx context.Context, cfg *Config, key string, maxAttempts int) error {
+	_, err := g.store.Update(ctx, key, func(e Entry, ok bool) Entry {
+		if !ok || e.Failures <= 1 {
+			return Entry{}
+		}
+		e.Failures--
+		if maxAttempts <= 0 || e.Failures < maxAttempts {
+			e.LockedUntil = time.Time{}
+		}
+		e.ExpiresAt = expiresAt(cfg, e)
+		return e
+	})
+	return err
+}
+
+func record(cfg *Config, e Entry, ok bool, now time.Time, maxAttempts int) Entry {
+	if !ok || expired(cfg, e, now) {
+		e = Entry{}
+	}
+
+	e.Failures++
+	e.LastFailure = now
+	if maxAttempts > 0 && e.Failures >= maxAttempts {
+		e.LockedUntil = now.Add(cfg.LockoutDuration)
+	}
+	e.ExpiresAt = expiresAt(cfg, e)
+	return e
+}
+
+func expired(cfg *Config, e Entry, now time.Time) bool {
+	return now.After(e.LockedUntil) && now.Sub(e.LastFailure) > cfg.Window
+}
+
+// expiresAt is when the entry expires under the configuration it was
+// written with. Stores may drop it then, even if the window has grown.
+func expiresAt(cfg *Config, e Entry) time.Time {
+	if end := e.LastFailure.Add(cfg.Window); end.After(e.LockedUntil) {
+		return end
+	}
+	return e.LockedUntil
+}
+
+func retryAfter(cfg *Config, e Entry, now time.Time) time.Duration {
+	if expired(cfg, e, now) {
+		return 0
+	}
+
+	if e.LockedUntil.After(now) {
+		return e.LockedUntil.Sub(now)
+	}
+
+	if next := e.LastFailure.Add(delay(cfg, e.Failures)); next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+func delay(cfg *Config, failures int) time.Duration {
+	if failures <= 0 || cfg.BaseDelay <= 0 {
+		return 0
+	}
+	d := cfg.BaseDelay
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if cfg.MaxDelay > 0 && d >= cfg.MaxDelay {
+			return cfg.MaxDelay
+		}
+	}
+	return d
+}
@@ -0,0 +1,184 @@
+package lockout
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestGuard() (*Guard, *clock) {
+	c := &clock{t: time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = c.now
+	g := New(store, Config{
+		MaxLoginAttempts: 3,
+		MaxIPAttempts:    5,
+		LockoutDuration:  10 * time.Minute,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		Window:           time.Hour,
+	})
+	g.now = c.now
+	return g, c
+}
+
+func TestGuardProgressiveDelay(t *testing.T) {
+	ctx := context.Background()
+	g, c := newTestGuard()
+
+	wait, err := g.Check(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	require.NoError(t, g.Fail(ctx, "user", "10.0.0.1"))
+	wait, _ = g.Check(ctx, "user", "10.0.0.1")
+	assert.Equal(t, time.Second, wait)
+
+	c.t = c.t.Add(time.Second)
+	wait, _ = g.Check(ctx, "user", "10.0.0.1")
+	assert.Zero(t, wait)
+
+	require.NoError(t, g.Fail(ctx, "user", "10.0.0.1"))
+	wait, _ = g.Check(ctx, "user", "10.0.0.1")
+	assert.Equal(t, 2*time.Second, wait)
+}
+
+func TestGuardLockout(t *testing.T) {
+	ctx := context.Background()
+	g, c := newTestGuard()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, g.Fail(ctx, "user", "10.0.0.1"))
+	}
+
+	wait, _ := g.Check(ctx, "user", "10.0.0.2")
+	assert.Equal(t, 10*time.Minute, wait)
+
+	c.t = c.t.Add(10*time.Minute + time.Second)
+	wait, _ = g.Check(ctx, "user", "10.0.0.2")
+	assert.Zero(t, wait)
+}
+
+func TestGuardIPLockout(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+
+	logins := []string{"a", "b", "c", "d", "e"}
+	for _, login := range logins {
+		require.NoError(t, g.Fail(ctx, login, "10.0.0.1"))
+	}
+
+	wait, _ := g.Check(ctx, "f", "10.0.0.1")
+	assert.Equal(t, 10*time.Minute, wait)
+
+	wait, _ = g.Check(ctx, "f", "10.0.0.2")
+	assert.Zero(t, wait)
+}
+
+func TestGuardUnlock(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, g.Fail(ctx, "user", "10.0.0.1"))
+	}
+	require.NoError(t, g.Unlock(ctx, "user"))
+
+	wait, _ := g.Check(ctx, "user", "10.0.0.2")
+	assert.Zero(t, wait)
+}
+
+func TestGuardSetConfig(t *testing.T) {
+	ctx := context.Background()
+	g, c := newTestGuard()
+
+	require.NoError(t, g.Fail(ctx, "user", "10.0.0.1"))
+	c.t = c.t.Add(time.Minute)
+
+	g.SetConfig(Config{MaxLoginAttempts: 2, MaxIPAttempts: 5, LockoutDuration: time.Minute, Window: time.Hour})
+	require.NoError(t, g.Fail(ctx, "user", "10.0.0.1"))
+
+	wait, err := g.Check(ctx, "user", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait, "the earlier failure counts against the new threshold")
+}
+
+func TestGuardBeginConcurrent(t *testing.T) {
+	ctx := context.Background()
+	g := New(NewMemoryStore(), DefaultConfig())
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := g.Begin(ctx, "user", "10.0.0.1")
+			if err == nil && wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), allowed.Load(), "parallel guesses wait for the first one")
+}
+
+func TestGuardBeginEnds(t *testing.T) {
+	ctx := context.Background()
+	g, c := newTestGuard()
+
+	wait, err := g.Begin(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	wait, _ = g.Check(ctx, "user", "10.0.0.1")
+	assert.Equal(t, time.Second, wait, "the attempt counts until it ends")
+
+	require.NoError(t, g.Cancel(ctx, "user", "10.0.0.1"))
+	wait, _ = g.Check(ctx, "user", "10.0.0.1")
+	assert.Zero(t, wait, "a cancelled attempt is taken back")
+
+	// Two failures of the address, then a success.
+	for i := 0; i < 2; i++ {
+		_, err = g.Begin(ctx, "other", "10.0.0.1")
+		require.NoError(t, err)
+		c.t = c.t.Add(time.Minute)
+	}
+	_, err = g.Begin(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	require.NoError(t, g.Succeed(ctx, "user", "10.0.0.1"))
+
+	e, ok, err := g.store.Get(ctx, ipKey("10.0.0.1"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 2, e.Failures, "only the successful attempt is taken back")
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	g, c := newTestGuard()
+	store := g.store.(*MemoryStore)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, g.Fail(ctx, "user"+strconv.Itoa(i), "10.0.0."+strconv.Itoa(i)))
+	}
+	assert.Len(t, store.entries, 200)
+
+	c.t = c.t.Add(time.Hour + sweepInterval)
+	require.NoError(t, g.Fail(ctx, "last", "10.0.1.1"))
+
+	assert.Len(t, store.entries, 2, "expired entries are dropped")
+}
@@ -0,0 +1,94 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Entry is the failed-attempt state tracked for a single key.
+type Entry struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	// ExpiresAt is when the entry no longer matters and may be dropped.
+	ExpiresAt time.Time
+}
+
+// Store keeps failed-attempt state. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	Get(ctx context.Context, key string) (Entry, bool, error)
+	// Update replaces the entry of key with what fn returns, atomically
+	// with respect to other updates of the key. A zero Entry deletes it.
+	Update(ctx context.Context, key string, fn func(e Entry, ok bool) Entry) (Entry, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// sweepInterval is how often MemoryStore drops expired entries.
+const sweepInterval = time.Minute
+
+// MemoryStore is the default Store. State is local to the process, so
+// replicas don't share counters. Expired entries are dropped, so that
+// attempts with random logins or addresses don't pile up.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]Entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Entry), now: time.Now}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (Entry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.get(key)
+	return e, ok, nil
+}
+
+func (s *MemoryStore) get(key string) (Entry, bool) {
+	e, ok := s.entries[key]
+	if ok && s.now().After(e.ExpiresAt) {
+		delete(s.entries, key)
+		return Entry{}, false
+	}
+	return e, ok
+}
+
+func (s *MemoryStore) Update(_ context.Context, key string, fn func(e Entry, ok bool) Entry) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	e, ok := s.get(key)
+	e = fn(e, ok)
+	if e == (Entry{}) {
+		delete(s.entries, key)
+	} else {
+		s.entries[key] = e
+	}
+	return e, nil
+}
+
+func (s *MemoryStore) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if now.After(e.ExpiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
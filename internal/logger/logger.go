@@ -11,17 +11,27 @@ const (
 	envProd  = "prod"
 )
 
-func SetupLogger(env string) *slog.Logger {
+// SetupLogger returns the logger of env. The level is read on every
+// record, so a slog.LevelVar changes it while the logger is in use.
+func SetupLogger(env string, level slog.Leveler) *slog.Logger {
 	var log *slog.Logger
 
 	switch env {
 	case envLocal:
-		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		log = slog.New(traceHandler{slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})})
 	case envDev:
-		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		log = slog.New(traceHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})})
 	case envProd:
-		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+		log = slog.New(traceHandler{slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})})
 	}
 
 	return log
 }
+
+// DefaultLevel is the level of env unless configured otherwise.
+func DefaultLevel(env string) slog.Level {
+	if env == envProd {
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+// traceHandler adds the trace and span IDs of the context to records, so
+// that log lines can be matched with traces. Only the *Context logging
+// methods pass a context along.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute labels requests no route matched, so that unknown paths
+// don't grow the number of series.
+const unmatchedRoute = "unmatched"
+
+// Middleware counts requests and their latency by the chi route pattern,
+// e.g. /api/user/webhooks/{id}, rather than by path.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,108 @@
+// Package metrics exposes Prometheus metrics of the HTTP API, the storage
+// and the accrual poller.
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+)
+
+const namespace = "gophermart"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	accrualRequests *prometheus.CounterVec
+	pollQueue       prometheus.Gauge
+}
+
+// New returns metrics registered in their own registry along with the
+// Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "query_duration_seconds",
+			Help:      "Storage call latency by method.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"method"}),
+		accrualRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "accrual",
+			Name:      "requests_total",
+			Help:      "Accrual system requests by outcome: the status code, 5xx or error.",
+		}, []string{"outcome"}),
+		pollQueue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "accrual",
+			Name:      "poll_queue_depth",
+			Help:      "NEW and PROCESSING orders found by the last poll.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.storageDuration,
+		m.accrualRequests,
+		m.pollQueue,
+	)
+	return m
+}
+
+// RegisterDB exports the connection pool stats of db.
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveAccrualRequest counts a request to the accrual system. Outcome
+// is the status code of the response, or "error" if there was none.
+func (m *Metrics) ObserveAccrualRequest(status int, err error) {
+	m.accrualRequests.WithLabelValues(accrualOutcome(status, err)).Inc()
+}
+
+func accrualOutcome(status int, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case status >= 500:
+		return "5xx"
+	case status >= 400 && status != http.StatusTooManyRequests:
+		return "4xx"
+	}
+	return strconv.Itoa(status)
+}
+
+// SetPollQueue records how many orders wait for an accrual.
+func (m *Metrics) SetPollQueue(n int) {
+	m.pollQueue.Set(float64(n))
+}
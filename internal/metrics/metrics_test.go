@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/api/user/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, path := range []string{"/api/user/webhooks/1", "/api/user/webhooks/2", "/api/unknown/3"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, "/api/user/webhooks/{id}", "204")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.httpDuration))
+}
+
+var accrualOutcomeTests = []struct {
+	name   string
+	status int
+	err    error
+	want   string
+}{
+	{name: "Processed", status: http.StatusOK, want: "200"},
+	{name: "Not registered", status: http.StatusNoContent, want: "204"},
+	{name: "Rate limited", status: http.StatusTooManyRequests, want: "429"},
+	{name: "Server error", status: http.StatusBadGateway, want: "5xx"},
+	{name: "Client error", status: http.StatusNotFound, want: "4xx"},
+	{name: "No response", err: errors.New("connection refused"), want: "error"},
+}
+
+func TestAccrualOutcome(t *testing.T) {
+	for _, test := range accrualOutcomeTests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, accrualOutcome(test.status, test.err))
+		})
+	}
+}
+
+type balanceStorage struct {
+	storage.Storage
+}
+
+func (balanceStorage) GetAccruals(context.Context, string) (float32, error) {
+	return 500, nil
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	m := New()
+	db := InstrumentStorage(balanceStorage{}, m)
+
+	sum, err := db.GetAccruals(context.Background(), "gopher")
+	require.NoError(t, err)
+	assert.Equal(t, float32(500), sum)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.storageDuration))
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `gophermart_storage_query_duration_seconds_count{method="GetAccruals"} 1`)
+}
@@ -0,0 +1,254 @@
+package metrics
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// InstrumentStorage records the latency of every call to db.
+func InstrumentStorage(db storage.Storage, m *Metrics) storage.Storage {
+	return &instrumentedStorage{next: db, duration: m.storageDuration}
+}
+
+type instrumentedStorage struct {
+	next     storage.Storage
+	duration *prometheus.HistogramVec
+}
+
+func (s *instrumentedStorage) observe(method string, start time.Time) {
+	s.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStorage) AddUser(ctx context.Context, u user.User) error {
+	defer s.observe("AddUser", time.Now())
+	return s.next.AddUser(ctx, u)
+}
+
+func (s *instrumentedStorage) GetUser(ctx context.Context, login string) (*user.User, error) {
+	defer s.observe("GetUser", time.Now())
+	return s.next.GetUser(ctx, login)
+}
+
+func (s *instrumentedStorage) UpdatePassword(ctx context.Context, login string, hashPassword string) (int, error) {
+	defer s.observe("UpdatePassword", time.Now())
+	return s.next.UpdatePassword(ctx, login, hashPassword)
+}
+
+func (s *instrumentedStorage) SetUserRole(ctx context.Context, login string, role string) error {
+	defer s.observe("SetUserRole", time.Now())
+	return s.next.SetUserRole(ctx, login, role)
+}
+
+func (s *instrumentedStorage) GetIdentity(ctx context.Context, issuer string, subject string) (string, error) {
+	defer s.observe("GetIdentity", time.Now())
+	return s.next.GetIdentity(ctx, issuer, subject)
+}
+
+func (s *instrumentedStorage) AddIdentityUser(ctx context.Context, issuer string, subject string, u user.User) error {
+	defer s.observe("AddIdentityUser", time.Now())
+	return s.next.AddIdentityUser(ctx, issuer, subject, u)
+}
+
+func (s *instrumentedStorage) LinkIdentity(ctx context.Context, issuer string, subject string, login string) error {
+	defer s.observe("LinkIdentity", time.Now())
+	return s.next.LinkIdentity(ctx, issuer, subject, login)
+}
+
+func (s *instrumentedStorage) SearchUsers(ctx context.Context, query string, limit int, offset int) ([]*user.Summary, error) {
+	defer s.observe("SearchUsers", time.Now())
+	return s.next.SearchUsers(ctx, query, limit, offset)
+}
+
+func (s *instrumentedStorage) AddPasswordReset(ctx context.Context, r user.PasswordReset) error {
+	defer s.observe("AddPasswordReset", time.Now())
+	return s.next.AddPasswordReset(ctx, r)
+}
+
+func (s *instrumentedStorage) ResetPassword(ctx context.Context, tokenHash string, hashPassword string) (string, error) {
+	defer s.observe("ResetPassword", time.Now())
+	return s.next.ResetPassword(ctx, tokenHash, hashPassword)
+}
+
+func (s *instrumentedStorage) SetTOTP(ctx context.Context, login string, secret string, recoveryHashes []string) error {
+	defer s.observe("SetTOTP", time.Now())
+	return s.next.SetTOTP(ctx, login, secret, recoveryHashes)
+}
+
+func (s *instrumentedStorage) GetTOTP(ctx context.Context, login string) (*user.TOTP, error) {
+	defer s.observe("GetTOTP", time.Now())
+	return s.next.GetTOTP(ctx, login)
+}
+
+func (s *instrumentedStorage) EnableTOTP(ctx context.Context, login string) error {
+	defer s.observe("EnableTOTP", time.Now())
+	return s.next.EnableTOTP(ctx, login)
+}
+
+func (s *instrumentedStorage) DeleteTOTP(ctx context.Context, login string) error {
+	defer s.observe("DeleteTOTP", time.Now())
+	return s.next.DeleteTOTP(ctx, login)
+}
+
+func (s *instrumentedStorage) UseRecoveryCode(ctx context.Context, login string, codeHash string) error {
+	defer s.observe("UseRecoveryCode", time.Now())
+	return s.next.UseRecoveryCode(ctx, login, codeHash)
+}
+
+func (s *instrumentedStorage) UseTOTPStep(ctx context.Context, login string, step uint64) error {
+	defer s.observe("UseTOTPStep", time.Now())
+	return s.next.UseTOTPStep(ctx, login, step)
+}
+
+func (s *instrumentedStorage) AddOrder(ctx context.Context, o order.Order) error {
+	defer s.observe("AddOrder", time.Now())
+	return s.next.AddOrder(ctx, o)
+}
+
+func (s *instrumentedStorage) GetOrders(ctx context.Context, login string) ([]*order.Order, error) {
+	defer s.observe("GetOrders", time.Now())
+	return s.next.GetOrders(ctx, login)
+}
+
+func (s *instrumentedStorage) GetOrder(ctx context.Context, number order.Number) (*order.Order, error) {
+	defer s.observe("GetOrder", time.Now())
+	return s.next.GetOrder(ctx, number)
+}
+
+func (s *instrumentedStorage) GetOrderWithStatuses(ctx context.Context, processing order.Status, newStatus order.Status) ([]order.Number, error) {
+	defer s.observe("GetOrderWithStatuses", time.Now())
+	return s.next.GetOrderWithStatuses(ctx, processing, newStatus)
+}
+
+func (s *instrumentedStorage) UpdateOrder(ctx context.Context, o order.Order) error {
+	defer s.observe("UpdateOrder", time.Now())
+	return s.next.UpdateOrder(ctx, o)
+}
+
+func (s *instrumentedStorage) UpdateOrderAudited(ctx context.Context, o order.Order, e audit.Entry) error {
+	defer s.observe("UpdateOrderAudited", time.Now())
+	return s.next.UpdateOrderAudited(ctx, o, e)
+}
+
+func (s *instrumentedStorage) GetAccruals(ctx context.Context, login string) (float32, error) {
+	defer s.observe("GetAccruals", time.Now())
+	return s.next.GetAccruals(ctx, login)
+}
+
+func (s *instrumentedStorage) GetWithdrawn(ctx context.Context, login string) (float32, error) {
+	defer s.observe("GetWithdrawn", time.Now())
+	return s.next.GetWithdrawn(ctx, login)
+}
+
+func (s *instrumentedStorage) GetWithdrawals(ctx context.Context, login string) ([]*withdraw.Withdraw, error) {
+	defer s.observe("GetWithdrawals", time.Now())
+	return s.next.GetWithdrawals(ctx, login)
+}
+
+func (s *instrumentedStorage) AddWithdrawal(ctx context.Context, wd withdraw.Withdraw, login string) (*withdraw.Withdraw, error) {
+	defer s.observe("AddWithdrawal", time.Now())
+	return s.next.AddWithdrawal(ctx, wd, login)
+}
+
+func (s *instrumentedStorage) AddBalanceAdjustment(ctx context.Context, a withdraw.Adjustment) (*withdraw.Adjustment, error) {
+	defer s.observe("AddBalanceAdjustment", time.Now())
+	return s.next.AddBalanceAdjustment(ctx, a)
+}
+
+func (s *instrumentedStorage) AddAPIKey(ctx context.Context, k apikey.Key) (*apikey.Key, error) {
+	defer s.observe("AddAPIKey", time.Now())
+	return s.next.AddAPIKey(ctx, k)
+}
+
+func (s *instrumentedStorage) GetAPIKeys(ctx context.Context, login string) ([]*apikey.Key, error) {
+	defer s.observe("GetAPIKeys", time.Now())
+	return s.next.GetAPIKeys(ctx, login)
+}
+
+func (s *instrumentedStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.Key, error) {
+	defer s.observe("GetAPIKeyByHash", time.Now())
+	return s.next.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *instrumentedStorage) TouchAPIKey(ctx context.Context, id int64) error {
+	defer s.observe("TouchAPIKey", time.Now())
+	return s.next.TouchAPIKey(ctx, id)
+}
+
+func (s *instrumentedStorage) RevokeAPIKey(ctx context.Context, login string, id int64) error {
+	defer s.observe("RevokeAPIKey", time.Now())
+	return s.next.RevokeAPIKey(ctx, login, id)
+}
+
+func (s *instrumentedStorage) AddWebhook(ctx context.Context, w webhook.Webhook) (*webhook.Webhook, error) {
+	defer s.observe("AddWebhook", time.Now())
+	return s.next.AddWebhook(ctx, w)
+}
+
+func (s *instrumentedStorage) GetWebhooks(ctx context.Context, login string) ([]*webhook.Webhook, error) {
+	defer s.observe("GetWebhooks", time.Now())
+	return s.next.GetWebhooks(ctx, login)
+}
+
+func (s *instrumentedStorage) DeleteWebhook(ctx context.Context, login string, id int64) error {
+	defer s.observe("DeleteWebhook", time.Now())
+	return s.next.DeleteWebhook(ctx, login, id)
+}
+
+func (s *instrumentedStorage) GetWebhookDeliveries(ctx context.Context, login string, id int64, limit int) ([]*webhook.Delivery, error) {
+	defer s.observe("GetWebhookDeliveries", time.Now())
+	return s.next.GetWebhookDeliveries(ctx, login, id, limit)
+}
+
+func (s *instrumentedStorage) AddWebhookDeliveries(ctx context.Context, e event.Event) error {
+	defer s.observe("AddWebhookDeliveries", time.Now())
+	return s.next.AddWebhookDeliveries(ctx, e)
+}
+
+func (s *instrumentedStorage) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*webhook.Task, error) {
+	defer s.observe("ClaimDeliveries", time.Now())
+	return s.next.ClaimDeliveries(ctx, limit, lease)
+}
+
+func (s *instrumentedStorage) RecordDelivery(ctx context.Context, a webhook.Attempt) error {
+	defer s.observe("RecordDelivery", time.Now())
+	return s.next.RecordDelivery(ctx, a)
+}
+
+func (s *instrumentedStorage) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*event.Claim, error) {
+	defer s.observe("ClaimEvents", time.Now())
+	return s.next.ClaimEvents(ctx, limit, lease)
+}
+
+func (s *instrumentedStorage) RecordEvents(ctx context.Context, outcomes []event.Outcome) error {
+	defer s.observe("RecordEvents", time.Now())
+	return s.next.RecordEvents(ctx, outcomes)
+}
+
+func (s *instrumentedStorage) PurgePasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	defer s.observe("PurgePasswordResets", time.Now())
+	return s.next.PurgePasswordResets(ctx, before)
+}
+
+func (s *instrumentedStorage) PruneOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	defer s.observe("PruneOutbox", time.Now())
+	return s.next.PruneOutbox(ctx, before, limit)
+}
+
+func (s *instrumentedStorage) AddAuditEntry(ctx context.Context, e audit.Entry) error {
+	defer s.observe("AddAuditEntry", time.Now())
+	return s.next.AddAuditEntry(ctx, e)
+}
+
+func (s *instrumentedStorage) GetAuditLog(ctx context.Context, limit int) ([]*audit.Entry, error) {
+	defer s.observe("GetAuditLog", time.Now())
+	return s.next.GetAuditLog(ctx, limit)
+}
@@ -0,0 +1,78 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	ScopeOrdersRead      = "orders:read"
+	ScopeOrdersWrite     = "orders:write"
+	ScopeBalanceRead     = "balance:read"
+	ScopeBalanceWithdraw = "balance:withdraw"
+	ScopeWithdrawalsRead = "withdrawals:read"
+)
+
+const (
+	SecretPrefix      = "gmk_"
+	MaxNameLength     = 64
+	prefixLength      = 12
+	secretRandomBytes = 24
+)
+
+var Scopes = []string{
+	ScopeOrdersRead,
+	ScopeOrdersWrite,
+	ScopeBalanceRead,
+	ScopeBalanceWithdraw,
+	ScopeWithdrawalsRead,
+}
+
+// Key is a long-lived credential of a machine client. Only the hash of
+// the secret is stored, the secret itself is shown once on creation.
+type Key struct {
+	ID         int64      `json:"id"`
+	User       string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type CreateRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type Created struct {
+	Key
+	Secret string `json:"key"`
+}
+
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretRandomBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SecretPrefix + hex.EncodeToString(b), nil
+}
+
+// PrefixOf returns the part of the secret which is safe to display.
+func PrefixOf(secret string) string {
+	if len(secret) < prefixLength {
+		return secret
+	}
+	return secret[:prefixLength]
+}
+
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ActionBalanceAdjust = "balance.adjust"
+	ActionOrderRepoll   = "order.repoll"
+	ActionOrderInvalid  = "order.invalidate"
+	ActionWebhookCreate = "webhook.create"
+	ActionWebhookDelete = "webhook.delete"
+	ActionLoginUnlock   = "login.unlock"
+)
+
+// Entry records an action an administrator took on somebody's data.
+type Entry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -0,0 +1,69 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	TypeOrderAdded         = "order.added"
+	TypeOrderStatusChanged = "order.status_changed"
+	// TypeOrderProcessed follows the status change to PROCESSED, when the
+	// accrual is credited.
+	TypeOrderProcessed    = "order.processed"
+	TypeWithdrawalCreated = "withdrawal.created"
+)
+
+var Types = []string{
+	TypeOrderAdded,
+	TypeOrderStatusChanged,
+	TypeOrderProcessed,
+	TypeWithdrawalCreated,
+}
+
+// Event is a state change recorded in the outbox in the same transaction
+// as the change itself. IDs grow in the order the changes were committed
+// for a single user.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	User      string          `json:"user"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Statuses of an outbox event after an attempt to publish it.
+const (
+	StatusPublished = "published"
+	// StatusFailed events are attempted again after Outcome.RetryIn.
+	StatusFailed = "failed"
+	// StatusParked events failed too often and are given up on, so that
+	// the later events of the user go ahead.
+	StatusParked = "parked"
+	// StatusSkipped events wait for an earlier failed event of the user.
+	StatusSkipped = "skipped"
+)
+
+// Claim is an outbox event reserved for publishing.
+type Claim struct {
+	Event
+	// Attempts is how many times publishing the event has failed.
+	Attempts int
+}
+
+// Outcome is the result of an attempt to publish a claimed event.
+type Outcome struct {
+	EventID int64
+	Status  string
+	Error   string
+	RetryIn time.Duration
+}
+
+func ValidType(t string) bool {
+	for _, v := range Types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,59 @@
 package user
 
+import "time"
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	Login        string `json:"login"`
 	Password     string `json:"password"`
+	OTP          string `json:"otp,omitempty"`
 	HashPassword string `json:"-"`
+	TokenVersion int    `json:"-"`
+	Role         string `json:"-"`
+}
+
+// Summary is what administrators see about a user.
+type Summary struct {
+	Login string `json:"login"`
+	Role  string `json:"role"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type PasswordResetConfirm struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordReset struct {
+	Login     string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+type TOTP struct {
+	Login   string
+	Secret  string
+	Enabled bool
+}
+
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
 }
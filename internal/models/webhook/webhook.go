@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	SecretPrefix = "whsec_"
+	MaxURLLength = 2048
+	// MaxPerUser limits the webhooks a user may have.
+	MaxPerUser        = 10
+	secretRandomBytes = 24
+)
+
+// Webhook is a URL events are posted to, see event.Types. Webhooks of a
+// user receive the events of that user; webhooks created by
+// administrators have no user and receive the events of everyone.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	User      string    `json:"-"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Created is the response to a new webhook, the only one that contains
+// the signing secret.
+type Created struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// Delivery is the log entry of sending one event to one webhook.
+type Delivery struct {
+	ID             int64      `json:"id"`
+	WebhookID      int64      `json:"webhook_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus *int       `json:"response_status"`
+	Error          string     `json:"error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+// Task is a delivery claimed by a dispatcher, with everything needed to
+// send it.
+type Task struct {
+	DeliveryID int64
+	Attempts   int
+	Webhook    Webhook
+	Event      event.Event
+}
+
+// Attempt is the outcome of sending a task.
+type Attempt struct {
+	DeliveryID     int64
+	Status         string
+	ResponseStatus *int
+	Error          string
+	// RetryIn is when a pending delivery is to be attempted again.
+	RetryIn time.Duration
+}
+
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretRandomBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SecretPrefix + hex.EncodeToString(b), nil
+}
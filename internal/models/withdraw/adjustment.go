@@ -0,0 +1,15 @@
+package withdraw
+
+import "time"
+
+// Adjustment is a manual change of a user's balance made by an
+// administrator. A positive amount credits the account, a negative one
+// debits it.
+type Adjustment struct {
+	ID        int64     `json:"id"`
+	User      string    `json:"user"`
+	Amount    float32   `json:"amount"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
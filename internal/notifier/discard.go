@@ -0,0 +1,13 @@
+package notifier
+
+import "context"
+
+// Discard drops every message. It is the default, so that reset tokens
+// are not delivered anywhere unless a notifier has been configured.
+var Discard Notifier = discard{}
+
+type discard struct{}
+
+func (discard) Notify(context.Context, Message) error {
+	return nil
+}
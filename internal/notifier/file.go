@@ -0,0 +1,43 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileNotifier appends every message as a JSON line to a file.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+type fileRecord struct {
+	Message
+	SentAt time.Time `json:"sent_at"`
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(_ context.Context, msg Message) error {
+	const op = "notifier.FileNotifier.Notify"
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	if err = json.NewEncoder(f).Encode(fileRecord{Message: msg, SentAt: time.Now()}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
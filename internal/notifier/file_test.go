@@ -0,0 +1,39 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.log")
+	n := NewFileNotifier(path)
+
+	messages := []Message{
+		{To: "first", Subject: "reset", Body: "token-1"},
+		{To: "second", Subject: "reset", Body: "token-2"},
+	}
+	for _, msg := range messages {
+		require.NoError(t, n.Notify(context.Background(), msg))
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var got []Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec fileRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		assert.False(t, rec.SentAt.IsZero())
+		got = append(got, rec.Message)
+	}
+	assert.Equal(t, messages, got)
+}
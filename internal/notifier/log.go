@@ -0,0 +1,27 @@
+package notifier
+
+import (
+	"context"
+	"golang.org/x/exp/slog"
+)
+
+// LogNotifier writes messages to the application log. It is meant for
+// local development only since message bodies may contain secrets.
+type LogNotifier struct {
+	log *slog.Logger
+}
+
+func NewLogNotifier(log *slog.Logger) *LogNotifier {
+	return &LogNotifier{
+		log: log.With(slog.String("component", "notifier/log")),
+	}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, msg Message) error {
+	n.log.Info("notification",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+	return nil
+}
@@ -0,0 +1,14 @@
+package notifier
+
+import "context"
+
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages such as password reset tokens to users.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
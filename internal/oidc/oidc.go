@@ -0,0 +1,188 @@
+// Package oidc implements the relying party side of the OpenID Connect
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Metadata is the part of the provider's discovery document we rely on.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	cfg      Config
+	client   *http.Client
+	metadata Metadata
+	keys     *keySet
+}
+
+var (
+	ErrIssuerMismatch = errors.New("issuer in discovery document doesn't match the configured one")
+	ErrNoIDToken      = errors.New("token response contains no id_token")
+)
+
+// Discover fetches the provider's discovery document and returns a
+// provider ready for the authorization code flow.
+func Discover(ctx context.Context, cfg Config, client *http.Client) (*Provider, error) {
+	const op = "oidc.Discover"
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var m Metadata
+	if err = doJSON(client, req, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("%s: %w", op, ErrIssuerMismatch)
+	}
+
+	return &Provider{
+		cfg:      cfg,
+		client:   client,
+		metadata: m,
+		keys:     newKeySet(client, m.JWKSURI),
+	}, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.metadata.Issuer
+}
+
+// AuthCodeURL returns the URL the user agent is redirected to for login.
+func (p *Provider) AuthCodeURL(state string, nonce string, challenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the authorization code for tokens and returns the
+// verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*IDToken, error) {
+	const op = "oidc.Provider.Exchange"
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tr tokenResponse
+	if err = doJSON(p.client, req, &tr); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if tr.IDToken == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoIDToken)
+	}
+
+	return p.Verify(ctx, tr.IDToken, nonce)
+}
+
+// NewPKCE returns a random code verifier and its S256 challenge.
+func NewPKCE() (verifier string, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString returns n random bytes encoded as URL-safe base64.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type responseError struct {
+	status int
+	body   string
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("unexpected response status %d: %s", e.status, e.body)
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &responseError{status: resp.StatusCode, body: string(body)}
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,145 @@
+package oidc_test
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kholodmv/gophermart/internal/oidc"
+	"github.com/kholodmv/gophermart/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const redirectURL = "http://gophermart.local/api/user/oidc/callback"
+
+func discover(t *testing.T, idp *oidctest.IdP) *oidc.Provider {
+	t.Helper()
+	p, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  redirectURL,
+	}, nil)
+	require.NoError(t, err)
+	return p
+}
+
+// login runs the authorization code flow and returns the exchange result.
+func login(t *testing.T, idp *oidctest.IdP, p *oidc.Provider, nonceOverride string) (*oidc.IDToken, error) {
+	t.Helper()
+
+	s, challenge, err := oidc.NewSession()
+	require.NoError(t, err)
+
+	redirect := idp.Authorize(t, p.AuthCodeURL(s.State, s.Nonce, challenge))
+	require.Equal(t, s.State, redirect.Query().Get("state"))
+
+	nonce := s.Nonce
+	if nonceOverride != "" {
+		nonce = nonceOverride
+	}
+	return p.Exchange(context.Background(), redirect.Query().Get("code"), s.Verifier, nonce)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := oidctest.NewIdP(t, "gophermart", "client-secret")
+	p := discover(t, idp)
+
+	token, err := login(t, idp, p, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, idp.Issuer(), token.Issuer)
+	assert.Equal(t, "subject-1", token.Subject)
+	assert.Equal(t, "john", token.PreferredUsername)
+	assert.Equal(t, "john@example.com", token.Email)
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewIdP(t, "gophermart", "")
+
+	_, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:   idp.Issuer() + "/other",
+		ClientID: "gophermart",
+	}, nil)
+	assert.Error(t, err)
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	idp := oidctest.NewIdP(t, "gophermart", "")
+	p := discover(t, idp)
+
+	s, challenge, err := oidc.NewSession()
+	require.NoError(t, err)
+	redirect := idp.Authorize(t, p.AuthCodeURL(s.State, s.Nonce, challenge))
+
+	_, err = p.Exchange(context.Background(), redirect.Query().Get("code"), "wrong-verifier", s.Nonce)
+	assert.Error(t, err)
+}
+
+var invalidTokenTests = []struct {
+	name   string
+	claims func(claims jwt.MapClaims)
+	nonce  string
+}{
+	{
+		name:  "Nonce mismatch",
+		nonce: "other-nonce",
+	},
+	{
+		name: "Wrong audience",
+		claims: func(claims jwt.MapClaims) {
+			claims["aud"] = "someone-else"
+		},
+	},
+	{
+		name: "Wrong issuer",
+		claims: func(claims jwt.MapClaims) {
+			claims["iss"] = "https://evil.example.com"
+		},
+	},
+	{
+		name: "Expired",
+		claims: func(claims jwt.MapClaims) {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		},
+	},
+	{
+		name: "No expiration",
+		claims: func(claims jwt.MapClaims) {
+			delete(claims, "exp")
+		},
+	},
+}
+
+func TestExchangeInvalidIDToken(t *testing.T) {
+	for _, test := range invalidTokenTests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := oidctest.NewIdP(t, "gophermart", "")
+			idp.Claims = test.claims
+			p := discover(t, idp)
+
+			_, err := login(t, idp, p, test.nonce)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+
+	s, _, err := oidc.NewSession()
+	require.NoError(t, err)
+
+	raw, err := oidc.EncodeSession(secret, *s, time.Minute)
+	require.NoError(t, err)
+
+	decoded, err := oidc.DecodeSession(secret, raw)
+	require.NoError(t, err)
+	assert.Equal(t, s.State, decoded.State)
+	assert.Equal(t, s.Nonce, decoded.Nonce)
+	assert.Equal(t, s.Verifier, decoded.Verifier)
+
+	_, err = oidc.DecodeSession([]byte("other"), raw)
+	assert.Error(t, err)
+}
@@ -0,0 +1,245 @@
+// Package oidctest provides a minimal OpenID Connect provider for tests.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const KeyID = "test-key"
+
+// User is who the provider signs in when asked to authorize.
+type User struct {
+	Subject           string
+	Email             string
+	PreferredUsername string
+}
+
+type grant struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+	user        User
+}
+
+// IdP is a stand-in identity provider supporting discovery, the
+// authorization code flow with PKCE and a JWKS endpoint.
+type IdP struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+	Key          *rsa.PrivateKey
+
+	mu     sync.Mutex
+	user   User
+	grants map[string]grant
+	// Claims modifies ID token claims right before signing, which lets
+	// tests produce invalid tokens.
+	Claims func(claims jwt.MapClaims)
+}
+
+func NewIdP(t *testing.T, clientID string, clientSecret string) *IdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &IdP{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Key:          key,
+		grants:       make(map[string]grant),
+		user:         User{Subject: "subject-1", Email: "john@example.com", PreferredUsername: "john"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Server.Close)
+	return idp
+}
+
+func (idp *IdP) Issuer() string {
+	return idp.Server.URL
+}
+
+// SetUser changes who is signed in on the next authorization.
+func (idp *IdP) SetUser(u User) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.user = u
+}
+
+// Authorize plays the user agent: it follows the authorization URL and
+// returns the redirect the provider answered with.
+func (idp *IdP) Authorize(t *testing.T, authURL string) *url.URL {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: unexpected status %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func (idp *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                idp.Issuer(),
+		"authorization_endpoint":                idp.Issuer() + "/authorize",
+		"token_endpoint":                        idp.Issuer() + "/token",
+		"jwks_uri":                              idp.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *IdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := idp.Key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *IdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != idp.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	idp.mu.Lock()
+	idp.grants[code] = grant{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+		user:        idp.user,
+	}
+	idp.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if idp.ClientSecret != "" {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != idp.ClientID || secret != idp.ClientSecret {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+	}
+
+	code := r.PostForm.Get("code")
+	idp.mu.Lock()
+	g, ok := idp.grants[code]
+	delete(idp.grants, code)
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                idp.Issuer(),
+		"sub":                g.user.Subject,
+		"aud":                idp.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              g.nonce,
+		"email":              g.user.Email,
+		"email_verified":     true,
+		"preferred_username": g.user.PreferredUsername,
+	}
+	if idp.Claims != nil {
+		idp.Claims(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KeyID
+	signed, err := token.SignedString(idp.Key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,61 @@
+package oidc
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// Session is the state of a login in progress. It lives in a signed
+// cookie between the redirect to the provider and the callback, so any
+// replica can complete the flow.
+type Session struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Link is the user who asked to link the identity to their account,
+	// empty for a login.
+	Link string `json:"link,omitempty"`
+	// Login is the user who signed in at the provider but still has to
+	// pass the second factor.
+	Login string `json:"login,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func NewSession() (*Session, string, error) {
+	state, err := RandomString(24)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := RandomString(24)
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		return nil, "", err
+	}
+	return &Session{State: state, Nonce: nonce, Verifier: verifier}, challenge, nil
+}
+
+func EncodeSession(secret []byte, s Session, ttl time.Duration) (string, error) {
+	s.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl))
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, s).SignedString(secret)
+}
+
+func DecodeSession(secret []byte, raw string) (*Session, error) {
+	s := &Session{}
+	_, err := jwt.ParseWithClaims(raw, s,
+		func(t *jwt.Token) (interface{}, error) {
+			return secret, nil
+		},
+		jwt.WithValidMethods([]string{"HS256"}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.State == "" || s.Verifier == "" {
+		return nil, errors.New("incomplete oidc session")
+	}
+	return s, nil
+}
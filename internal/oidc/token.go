@@ -0,0 +1,162 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IDToken holds the claims of a verified ID token.
+type IDToken struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+var (
+	ErrNonceMismatch = errors.New("id token nonce doesn't match")
+	ErrNoExpiration  = errors.New("id token has no expiration")
+	ErrUnknownKey    = errors.New("id token is signed with an unknown key")
+)
+
+// Verify checks the signature, issuer, audience, expiration and nonce of
+// a raw ID token.
+func (p *Provider) Verify(ctx context.Context, raw string, nonce string) (*IDToken, error) {
+	const op = "oidc.Provider.Verify"
+
+	claims := &IDToken{}
+	_, err := jwt.ParseWithClaims(raw, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return p.keys.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(p.metadata.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoExpiration)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNonceMismatch)
+	}
+	return claims, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// keySet caches the provider's signing keys and refetches them when a
+// token refers to an unknown key id, which happens after key rotation.
+type keySet struct {
+	mu        sync.Mutex
+	client    *http.Client
+	uri       string
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// minRefetchInterval keeps tokens with bogus key ids from hammering the
+// provider.
+const minRefetchInterval = 10 * time.Second
+
+func newKeySet(client *http.Client, uri string) *keySet {
+	return &keySet{client: client, uri: uri}
+}
+
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(s.fetchedAt) < minRefetchInterval {
+		return nil, ErrUnknownKey
+	}
+	if err := s.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// lookup falls back to the only key when the token carries no key id.
+func (s *keySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (s *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.uri, nil)
+	if err != nil {
+		return err
+	}
+
+	var set jwks
+	if err = doJSON(s.client, req, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		pub, err := parseRSAKey(k)
+		if err != nil {
+			return err
+		}
+		keys[k.Kid] = pub
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent of key %q: %w", k.Kid, err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
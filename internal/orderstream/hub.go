@@ -0,0 +1,79 @@
+// Package orderstream fans order updates out to the users watching them.
+package orderstream
+
+import (
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"sync"
+)
+
+// bufferSize is how many updates a subscriber may fall behind before
+// further ones are dropped.
+const bufferSize = 16
+
+// Hub delivers every published order to the subscriptions of its owner.
+// Publishing never blocks: a subscriber that doesn't keep up misses
+// updates rather than stalling the others.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan order.Order]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[chan order.Order]struct{})}
+}
+
+// Subscribe returns the updates of the user's orders and a function that
+// ends the subscription. The channel is closed when the subscription
+// ends, either way.
+func (h *Hub) Subscribe(login string) (<-chan order.Order, func()) {
+	ch := make(chan order.Order, bufferSize)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subs[login] == nil {
+		h.subs[login] = make(map[chan order.Order]struct{})
+	}
+	h.subs[login][ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[login][ch]; !ok {
+			return
+		}
+		delete(h.subs[login], ch)
+		if len(h.subs[login]) == 0 {
+			delete(h.subs, login)
+		}
+		close(ch)
+	}
+}
+
+func (h *Hub) Publish(o order.Order) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[o.UserLogin] {
+		select {
+		case ch <- o:
+		default:
+		}
+	}
+}
+
+// Close ends all subscriptions, letting streams finish on shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for login, subs := range h.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(h.subs, login)
+	}
+}
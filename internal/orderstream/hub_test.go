@@ -0,0 +1,63 @@
+package orderstream
+
+import (
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+
+	first, cancelFirst := h.Subscribe("gopher")
+	second, cancelSecond := h.Subscribe("gopher")
+	other, cancelOther := h.Subscribe("mole")
+	defer cancelSecond()
+	defer cancelOther()
+
+	h.Publish(order.Order{UserLogin: "gopher", Number: "12345678903", Status: order.StatusProcessed})
+
+	for _, ch := range []<-chan order.Order{first, second} {
+		select {
+		case o := <-ch:
+			assert.Equal(t, order.Number("12345678903"), o.Number)
+		default:
+			t.Fatal("update not delivered")
+		}
+	}
+	assert.Empty(t, other, "updates are delivered to the owner only")
+
+	cancelFirst()
+	_, ok := <-first
+	assert.False(t, ok, "cancel closes the channel")
+	cancelFirst()
+
+	h.Publish(order.Order{UserLogin: "gopher", Number: "2377225624"})
+	require.Len(t, second, 1)
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	ch, cancel := h.Subscribe("gopher")
+	defer cancel()
+
+	for i := 0; i < bufferSize+5; i++ {
+		h.Publish(order.Order{UserLogin: "gopher"})
+	}
+	assert.Len(t, ch, bufferSize)
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub()
+	ch, cancel := h.Subscribe("gopher")
+
+	h.Close()
+	_, ok := <-ch
+	assert.False(t, ok)
+	cancel()
+
+	ch, _ = h.Subscribe("gopher")
+	_, ok = <-ch
+	assert.False(t, ok, "subscriptions after close end at once")
+}
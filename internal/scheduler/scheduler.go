@@ -0,0 +1,102 @@
+// Package scheduler runs periodic background jobs. Jobs marked as
+// singleton run on the elected leader only, see package leader.
+package scheduler
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"golang.org/x/exp/slog"
+	"sync"
+	"time"
+)
+
+type Job struct {
+	Name  string
+	Every time.Duration
+	// Singleton jobs run on one replica at a time.
+	Singleton bool
+	Run       func(ctx context.Context) error
+}
+
+// Elector runs lead while this replica is the leader.
+type Elector interface {
+	Run(ctx context.Context, lead func(ctx context.Context) error) error
+}
+
+type Scheduler struct {
+	log     *slog.Logger
+	elector Elector
+	jobs    []Job
+}
+
+// New returns a scheduler of jobs. The elector may be nil when there are
+// no singleton jobs.
+func New(log *slog.Logger, elector Elector, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		log:     log.With(slog.String("component", "scheduler")),
+		elector: elector,
+		jobs:    jobs,
+	}
+}
+
+// Run runs the jobs until ctx is done. Every job runs right away and then
+// on its interval; singleton jobs start over whenever this replica is
+// elected.
+func (s *Scheduler) Run(ctx context.Context) error {
+	var local, singleton []Job
+	for _, j := range s.jobs {
+		if j.Singleton {
+			singleton = append(singleton, j)
+		} else {
+			local = append(local, j)
+		}
+	}
+
+	var wg sync.WaitGroup
+	if len(singleton) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.elector.Run(ctx, func(ctx context.Context) error {
+				s.runJobs(ctx, singleton)
+				return nil
+			})
+		}()
+	}
+	s.runJobs(ctx, local)
+	wg.Wait()
+	return nil
+}
+
+// runJobs runs jobs until ctx is done.
+func (s *Scheduler) runJobs(ctx context.Context, jobs []Job) {
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j Job) {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j Job) {
+	ticker := time.NewTicker(j.Every)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if err := j.Run(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("job failed", slog.String("job", j.Name), sl.Err(err))
+		} else if err == nil {
+			s.log.Debug("job done", slog.String("job", j.Name), slog.Duration("took", time.Since(start)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeElector leads while the test allows it.
+type fakeElector struct {
+	elected chan struct{}
+}
+
+func (e *fakeElector) Run(ctx context.Context, lead func(ctx context.Context) error) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-e.elected:
+		return lead(ctx)
+	}
+}
+
+func counter(runs *atomic.Int32) func(context.Context) error {
+	return func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}
+}
+
+func TestSingletonJobsRunOnLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var local, singleton atomic.Int32
+	elector := &fakeElector{elected: make(chan struct{})}
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)), elector,
+		Job{Name: "local", Every: time.Millisecond, Run: counter(&local)},
+		Job{Name: "singleton", Every: time.Millisecond, Singleton: true, Run: counter(&singleton)},
+	)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+
+	assert.Eventually(t, func() bool { return local.Load() > 1 }, time.Second, time.Millisecond)
+	assert.Zero(t, singleton.Load(), "singleton jobs wait for the election")
+
+	close(elector.elected)
+	assert.Eventually(t, func() bool { return singleton.Load() > 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler didn't stop")
+	}
+}
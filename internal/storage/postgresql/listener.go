@@ -0,0 +1,77 @@
+package postgresql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/lib/pq"
+	"golang.org/x/exp/slog"
+	"time"
+)
+
+// OrderUpdatesChannel is the channel UpdateOrder notifies on.
+const OrderUpdatesChannel = "order_updates"
+
+const (
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+type orderUpdate struct {
+	Number     order.Number `json:"number"`
+	Login      string       `json:"login"`
+	Status     order.Status `json:"status"`
+	Accrual    float32      `json:"accrual"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+}
+
+// ListenOrderUpdates calls handle for every order updated by any replica
+// until the context is done. The connection is re-established after a
+// failure; updates made meanwhile are lost.
+func ListenOrderUpdates(ctx context.Context, storagePath string, log *slog.Logger, handle func(order.Order)) error {
+	const op = "storage.postgresql.ListenOrderUpdates"
+
+	l := pq.NewListener(storagePath, listenerMinReconnect, listenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error("order updates listener", slog.Int("event", int(ev)), sl.Err(err))
+		}
+	})
+	defer l.Close()
+
+	if err := l.Listen(OrderUpdatesChannel); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-l.Notify:
+			// nil after a reconnect
+			if n == nil {
+				continue
+			}
+			var u orderUpdate
+			if err := json.Unmarshal([]byte(n.Extra), &u); err != nil {
+				log.Error("invalid order update", slog.String("payload", n.Extra), sl.Err(err))
+				continue
+			}
+			handle(order.Order{
+				UserLogin:  u.Login,
+				Number:     u.Number,
+				Status:     u.Status,
+				Accrual:    u.Accrual,
+				UploadedAt: u.UploadedAt,
+			})
+		case <-ping.C:
+			// Detects a dead connection when nothing is being sent.
+			go l.Ping()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// LeaderLockID is the advisory lock held by the replica that runs the
+// singleton jobs.
+const LeaderLockID = 0x6c6561646572
+
+// Lock is a session advisory lock. It is held as long as its connection
+// lives, so it goes away with a replica that dies or loses the database.
+type Lock struct {
+	conn *sql.Conn
+	id   int64
+}
+
+// TryLock takes the advisory lock id on a connection of its own. It
+// reports false when another session holds the lock.
+func (s *Storage) TryLock(ctx context.Context, id int64) (*Lock, bool, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", errors.New("can't get connection"), err)
+	}
+
+	var locked bool
+	if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", id).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("%s: %w", errors.New("can't take lock"), err)
+	}
+	if !locked {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &Lock{conn: conn, id: id}, true, nil
+}
+
+// KeepAlive checks that the session holding the lock is still there.
+func (l *Lock) KeepAlive(ctx context.Context) error {
+	return l.conn.PingContext(ctx)
+}
+
+// Release gives up the lock and the connection. When the lock can't be
+// released the connection is discarded rather than returned to the pool,
+// which ends the session and the lock with it.
+func (l *Lock) Release(ctx context.Context) error {
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.id)
+	if err != nil {
+		l.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		l.conn.Close()
+		return fmt.Errorf("%s: %w", errors.New("can't release lock"), err)
+	}
+	return l.conn.Close()
+}
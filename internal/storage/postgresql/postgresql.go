@@ -3,15 +3,22 @@ package postgresql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jackc/pgerrcode"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/event"
 	"github.com/kholodmv/gophermart/internal/models/order"
 	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
 	"github.com/kholodmv/gophermart/internal/models/withdraw"
 	"github.com/lib/pq"
 	"golang.org/x/exp/slog"
+	"strings"
+	"time"
 )
 
 type Storage struct {
@@ -25,6 +32,39 @@ const tableUser = `
         login VARCHAR(256) UNIQUE NOT NULL,
         pass_hash VARCHAR(256) NOT NULL);`
 
+const alterUserTokenVersion = `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;`
+
+const alterUserRole = `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'user';`
+
+const tablePasswordResets = `
+	CREATE TABLE IF NOT EXISTS password_resets(
+	    id SERIAL PRIMARY KEY,
+	    user_login VARCHAR(256) NOT NULL,
+	    token_hash VARCHAR(64) UNIQUE NOT NULL,
+	    expires_at TIMESTAMP NOT NULL,
+	    used_at TIMESTAMP);`
+
+const tableTOTP = `
+	CREATE TABLE IF NOT EXISTS user_totp(
+	    user_login VARCHAR(256) PRIMARY KEY,
+	    secret VARCHAR(64) NOT NULL,
+	    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+	    created_at TIMESTAMP NOT NULL DEFAULT now());`
+
+// last_step is the time step of the last TOTP code used, so that a code
+// can't be used twice.
+const alterTOTPLastStep = `
+	ALTER TABLE user_totp ADD COLUMN IF NOT EXISTS last_step BIGINT NOT NULL DEFAULT 0;`
+
+const tableRecoveryCodes = `
+	CREATE TABLE IF NOT EXISTS recovery_codes(
+	    id SERIAL PRIMARY KEY,
+	    user_login VARCHAR(256) NOT NULL,
+	    code_hash VARCHAR(64) NOT NULL,
+	    used_at TIMESTAMP);`
+
 const tableOrder = `
 	CREATE TABLE IF NOT EXISTS orders(
 	    id SERIAL PRIMARY KEY,
@@ -42,15 +82,204 @@ const tableWithdrawals = `
 		sum DOUBLE PRECISION NOT NULL,
 		processed_at TIMESTAMP NOT NULL);`
 
+const tableBalanceAdjustments = `
+	CREATE TABLE IF NOT EXISTS balance_adjustments(
+	    id SERIAL PRIMARY KEY,
+	    user_login VARCHAR(256) NOT NULL,
+	    amount DOUBLE PRECISION NOT NULL,
+	    reason TEXT NOT NULL,
+	    actor VARCHAR(256) NOT NULL,
+	    created_at TIMESTAMP NOT NULL);`
+
+const tableAuditLog = `
+	CREATE TABLE IF NOT EXISTS audit_log(
+	    id SERIAL PRIMARY KEY,
+	    actor VARCHAR(256) NOT NULL,
+	    action VARCHAR(64) NOT NULL,
+	    target VARCHAR(256) NOT NULL,
+	    details JSONB,
+	    created_at TIMESTAMP NOT NULL);`
+
+const tableAPIKeys = `
+	CREATE TABLE IF NOT EXISTS api_keys(
+	    id SERIAL PRIMARY KEY,
+	    user_login VARCHAR(256) NOT NULL,
+	    name VARCHAR(64) NOT NULL,
+	    prefix VARCHAR(16) NOT NULL,
+	    key_hash VARCHAR(64) UNIQUE NOT NULL,
+	    scopes TEXT[] NOT NULL,
+	    created_at TIMESTAMP NOT NULL,
+	    last_used_at TIMESTAMP,
+	    revoked_at TIMESTAMP);`
+
+const tableIdentities = `
+	CREATE TABLE IF NOT EXISTS user_identities(
+	    issuer VARCHAR(512) NOT NULL,
+	    subject VARCHAR(256) NOT NULL,
+	    user_login VARCHAR(256) NOT NULL,
+	    created_at TIMESTAMP NOT NULL DEFAULT now(),
+	    PRIMARY KEY (issuer, subject));`
+
+// The outbox records events in the same transaction as the change they
+// describe, so that no event is lost or sent for a rolled back change.
+// dispatched_at is set once the relay has published the event.
+const tableOutbox = `
+	CREATE TABLE IF NOT EXISTS outbox(
+	    id BIGSERIAL PRIMARY KEY,
+	    event_type VARCHAR(64) NOT NULL,
+	    user_login VARCHAR(256) NOT NULL,
+	    payload JSONB NOT NULL,
+	    created_at TIMESTAMP NOT NULL DEFAULT now(),
+	    dispatched_at TIMESTAMP);`
+
+const indexOutboxPending = `
+	CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (id) WHERE dispatched_at IS NULL;`
+
+// Events failing to publish are retried at next_attempt_at, up to a limit
+// after which they are parked for an operator to look into.
+const alterOutboxAttempts = `
+	ALTER TABLE outbox
+	    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+	    ADD COLUMN IF NOT EXISTS error TEXT,
+	    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP,
+	    ADD COLUMN IF NOT EXISTS parked_at TIMESTAMP;`
+
+const indexOutboxUserPending = `
+	CREATE INDEX IF NOT EXISTS outbox_user_pending ON outbox (user_login, id)
+	WHERE dispatched_at IS NULL AND parked_at IS NULL;`
+
+// Webhooks without a user belong to administrators and receive the
+// events of all users.
+const tableWebhooks = `
+	CREATE TABLE IF NOT EXISTS webhooks(
+	    id SERIAL PRIMARY KEY,
+	    user_login VARCHAR(256),
+	    url VARCHAR(2048) NOT NULL,
+	    events TEXT[] NOT NULL,
+	    secret VARCHAR(128) NOT NULL,
+	    created_at TIMESTAMP NOT NULL,
+	    revoked_at TIMESTAMP);`
+
+const tableWebhookDeliveries = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries(
+	    id BIGSERIAL PRIMARY KEY,
+	    webhook_id INTEGER NOT NULL REFERENCES webhooks(id),
+	    event_id BIGINT NOT NULL REFERENCES outbox(id),
+	    status VARCHAR(16) NOT NULL,
+	    attempts INTEGER NOT NULL DEFAULT 0,
+	    response_status INTEGER,
+	    error TEXT,
+	    next_attempt_at TIMESTAMP,
+	    created_at TIMESTAMP NOT NULL DEFAULT now(),
+	    delivered_at TIMESTAMP,
+	    UNIQUE (webhook_id, event_id));`
+
+const indexWebhookDeliveriesDue = `
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';`
+
+// The schema version is the number of migrations applied. It only grows,
+// so a replica of an older build doesn't undo what a newer one recorded.
+const tableSchemaVersion = `
+	CREATE TABLE IF NOT EXISTS schema_version(
+	    id INTEGER PRIMARY KEY CHECK (id = 1),
+	    version INTEGER NOT NULL
+	);`
+
+const querySetSchemaVersion = `
+	INSERT INTO schema_version (id, version) VALUES (1, $1)
+	ON CONFLICT (id) DO UPDATE SET version = GREATEST(schema_version.version, EXCLUDED.version)`
+
+var migrations = []string{
+	tableUser,
+	alterUserTokenVersion,
+	alterUserRole,
+	tableOrder,
+	tableWithdrawals,
+	tablePasswordResets,
+	tableTOTP,
+	tableRecoveryCodes,
+	tableBalanceAdjustments,
+	tableAuditLog,
+	tableAPIKeys,
+	tableIdentities,
+	tableOutbox,
+	indexOutboxPending,
+	tableWebhooks,
+	tableWebhookDeliveries,
+	indexWebhookDeliveriesDue,
+	tableSchemaVersion,
+	alterTOTPLastStep,
+	alterOutboxAttempts,
+	indexOutboxUserPending,
+}
+
+// SchemaVersion is the schema version this build migrates to.
+var SchemaVersion = len(migrations)
+
+// queryAccruals sums everything credited to a user: accruals for orders
+// and manual adjustments.
+const queryAccruals = `
+	SELECT (SELECT coalesce(sum(accrual), 0) FROM orders WHERE user_login = $1)
+	     + (SELECT coalesce(sum(amount), 0) FROM balance_adjustments WHERE user_login = $1)`
+
+// queryUpdateOrder skips no-op updates, so that polling an order which
+// hasn't changed doesn't notify anyone. A status change is recorded in
+// the outbox, followed by order.processed once the accrual is credited;
+// the single insert keeps the two in order.
+const queryUpdateOrder = `
+	WITH old AS (
+	    SELECT number, status FROM orders WHERE number = $3 FOR UPDATE),
+	updated AS (
+	    UPDATE orders o SET status = $1, accrual = $2 FROM old
+	    WHERE o.number = old.number AND (o.status IS DISTINCT FROM $1 OR o.accrual IS DISTINCT FROM $2)
+	    RETURNING o.number, o.user_login, o.status, o.accrual, o.uploaded_at, old.status AS old_status),
+	events AS (
+	    INSERT INTO outbox (event_type, user_login, payload)
+	    SELECT event_type, user_login, payload FROM (
+	        SELECT 1 AS seq, '` + event.TypeOrderStatusChanged + `' AS event_type, user_login, json_build_object(
+	            'number', number,
+	            'status', status,
+	            'previous_status', old_status,
+	            'accrual', coalesce(accrual, 0),
+	            'uploaded_at', uploaded_at AT TIME ZONE 'UTC') AS payload
+	        FROM updated WHERE status IS DISTINCT FROM old_status
+	        UNION ALL
+	        SELECT 2, '` + event.TypeOrderProcessed + `', user_login, json_build_object(
+	            'number', number,
+	            'status', status,
+	            'accrual', coalesce(accrual, 0),
+	            'uploaded_at', uploaded_at AT TIME ZONE 'UTC')
+	        FROM updated WHERE status = '` + string(order.StatusProcessed) + `' AND old_status IS DISTINCT FROM status
+	    ) e ORDER BY seq)
+	SELECT pg_notify('` + OrderUpdatesChannel + `', json_build_object(
+	    'number', number,
+	    'login', user_login,
+	    'status', status,
+	    'accrual', coalesce(accrual, 0),
+	    'uploaded_at', uploaded_at AT TIME ZONE 'UTC')::text)
+	FROM updated`
+
 var (
-	ErrorNotFound       = errors.New(`can not get order by number`)
-	ErrorOrderAdded     = errors.New(`order number added yet by this user`)
-	ErrorOrderExist     = errors.New(`order number added yet by another user`)
-	ErrorNotEnoughFunds = errors.New(`there are not enough funds on the account`)
-	ErrorAddWithdrawal  = errors.New(`error add withdrawal`)
+	ErrorNotFound        = errors.New(`can not get order by number`)
+	ErrorOrderAdded      = errors.New(`order number added yet by this user`)
+	ErrorOrderExist      = errors.New(`order number added yet by another user`)
+	ErrorNotEnoughFunds  = errors.New(`there are not enough funds on the account`)
+	ErrorAddWithdrawal   = errors.New(`error add withdrawal`)
+	ErrorUserNotFound    = errors.New(`user not found`)
+	ErrorUserExists      = errors.New(`user with this login already exists`)
+	ErrorResetInvalid    = errors.New(`reset token is invalid, expired or already used`)
+	ErrorTOTPNotFound    = errors.New(`two-factor authentication is not set up`)
+	ErrorRecoveryCode    = errors.New(`recovery code is invalid or already used`)
+	ErrorTOTPReplay      = errors.New(`two-factor code has already been used`)
+	ErrorOrderNotFound   = errors.New(`order not found`)
+	ErrorAPIKeyNotFound  = errors.New(`api key not found`)
+	ErrorNoIdentity      = errors.New(`external identity is not linked to a user`)
+	ErrorIdentityLinked  = errors.New(`external identity is already linked to a user`)
+	ErrorWebhookNotFound = errors.New(`webhook not found`)
+	ErrorWebhookLimit    = errors.New(`too many webhooks`)
 )
 
-func New(storagePath string) (*Storage, error) {
+func New(storagePath string, log *slog.Logger) (*Storage, error) {
 	const op = "storage.postgresql.New"
 
 	db, err := sql.Open("postgres", storagePath)
@@ -58,24 +287,67 @@ func New(storagePath string) (*Storage, error) {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if _, err = db.Exec(tableUser); err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: can't connect: %w", op, err)
 	}
-	if _, err = db.Exec(tableOrder); err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+
+	for _, m := range migrations {
+		if _, err = db.Exec(m); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
 	}
-	if _, err = db.Exec(tableWithdrawals); err != nil {
+	if _, err = db.Exec(querySetSchemaVersion, SchemaVersion); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return &Storage{db: db, log: log}, nil
+}
+
+// connectTimeout limits how long New waits for the database.
+const connectTimeout = 10 * time.Second
+
+// Ping checks that the database answers.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// CheckMigrations fails unless the schema is at least SchemaVersion, e.g.
+// after the database was restored from an old backup.
+func (s *Storage) CheckMigrations(ctx context.Context) error {
+	var version int
+	err := s.db.QueryRowContext(ctx, "SELECT version FROM schema_version WHERE id = 1").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't get schema version"), err)
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("schema version %d is behind %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// DB returns the connection pool, e.g. to export its stats.
+func (s *Storage) DB() *sql.DB {
+	return s.db
 }
 
 func (s *Storage) AddUser(ctx context.Context, u user.User) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO users (login, pass_hash) VALUES ($1, $2)", u.Login, u.HashPassword)
+	role := u.Role
+	if role == "" {
+		role = user.RoleUser
+	}
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users (login, pass_hash, role) VALUES ($1, $2, $3)", u.Login, u.HashPassword, role)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.UniqueViolation {
+			return ErrorUserExists
+		}
 		s.log.Error("error insert user to table", err)
-		return errors.New(`can not add user to db`)
+		return fmt.Errorf("%s: %w", errors.New("can not add user to db"), err)
 	}
 	return nil
 }
@@ -83,22 +355,301 @@ func (s *Storage) AddUser(ctx context.Context, u user.User) error {
 func (s *Storage) GetUser(ctx context.Context, login string) (*user.User, error) {
 	u := new(user.User)
 	row := s.db.QueryRowContext(ctx,
-		"SELECT login, pass_hash FROM users WHERE login = $1", login)
-	if err := row.Scan(&u.Login, &u.HashPassword); err != nil {
+		"SELECT login, pass_hash, token_version, role FROM users WHERE login = $1", login)
+	if err := row.Scan(&u.Login, &u.HashPassword, &u.TokenVersion, &u.Role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrorUserNotFound
+		}
 		s.log.Error("error get user by login", err)
 		return nil, err
 	}
 	return u, nil
 }
 
+// UpdatePassword stores a new password hash and bumps the token version,
+// which invalidates every token issued before the change.
+func (s *Storage) UpdatePassword(ctx context.Context, login string, hashPassword string) (int, error) {
+	var version int
+	row := s.db.QueryRowContext(ctx,
+		"UPDATE users SET pass_hash = $1, token_version = token_version + 1 WHERE login = $2 RETURNING token_version",
+		hashPassword, login)
+	if err := row.Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrorUserNotFound
+		}
+		return 0, fmt.Errorf("%s: %w", errors.New("can't update password"), err)
+	}
+	return version, nil
+}
+
+func (s *Storage) SetUserRole(ctx context.Context, login string, role string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE login = $2", role, login)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't set user role"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrorUserNotFound
+	}
+	return nil
+}
+
+// GetIdentity returns the login linked to an external identity.
+func (s *Storage) GetIdentity(ctx context.Context, issuer string, subject string) (string, error) {
+	var login string
+	row := s.db.QueryRowContext(ctx,
+		"SELECT user_login FROM user_identities WHERE issuer = $1 AND subject = $2", issuer, subject)
+	if err := row.Scan(&login); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrorNoIdentity
+		}
+		return "", fmt.Errorf("%s: %w", errors.New("can't get identity"), err)
+	}
+	return login, nil
+}
+
+// AddIdentityUser provisions a user for an external identity and links
+// the two in one transaction. It fails with ErrorIdentityLinked if the
+// identity has been linked meanwhile, e.g. by a concurrent first login.
+func (s *Storage) AddIdentityUser(ctx context.Context, issuer string, subject string, u user.User) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (login, pass_hash, role) VALUES ($1, $2, $3)",
+		u.Login, u.HashPassword, user.RoleUser)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.UniqueViolation {
+			return ErrorUserExists
+		}
+		return fmt.Errorf("%s: %w", errors.New("can not add user to db"), err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_login) VALUES ($1, $2, $3)", issuer, subject, u.Login)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.UniqueViolation {
+			return ErrorIdentityLinked
+		}
+		return fmt.Errorf("%s: %w", errors.New("can't link identity"), err)
+	}
+
+	return tx.Commit()
+}
+
+// LinkIdentity links an external identity to an existing user. Linking it
+// again to the same user is a no-op; it fails with ErrorIdentityLinked if
+// the identity belongs to another user.
+func (s *Storage) LinkIdentity(ctx context.Context, issuer string, subject string, login string) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_login) VALUES ($1, $2, $3) ON CONFLICT (issuer, subject) DO NOTHING",
+		issuer, subject, login)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't link identity"), err)
+	}
+
+	owner, err := s.GetIdentity(ctx, issuer, subject)
+	if err != nil {
+		return err
+	}
+	if owner != login {
+		return ErrorIdentityLinked
+	}
+	return nil
+}
+
+// SearchUsers returns users whose login contains the query.
+func (s *Storage) SearchUsers(ctx context.Context, query string, limit int, offset int) ([]*user.Summary, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT login, role FROM users WHERE login ILIKE $1 ORDER BY login LIMIT $2 OFFSET $3",
+		pattern, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't search users"), err)
+	}
+	defer rows.Close()
+
+	users := make([]*user.Summary, 0)
+	for rows.Next() {
+		u := &user.Summary{}
+		if err = rows.Scan(&u.Login, &u.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *Storage) AddPasswordReset(ctx context.Context, r user.PasswordReset) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO password_resets (user_login, token_hash, expires_at) VALUES ($1, $2, $3)",
+		r.Login, r.TokenHash, r.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't add password reset"), err)
+	}
+	return nil
+}
+
+// ResetPassword consumes the reset token and stores the new password hash
+// of its owner in one transaction, returning the owner. A token can be
+// consumed only once and only before it expires; the owner's other
+// outstanding tokens are invalidated along with it.
+func (s *Storage) ResetPassword(ctx context.Context, tokenHash string, hashPassword string) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var login string
+	row := tx.QueryRowContext(ctx,
+		"UPDATE password_resets SET used_at = now() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now() RETURNING user_login",
+		tokenHash)
+	if err = row.Scan(&login); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrorResetInvalid
+		}
+		return "", fmt.Errorf("%s: %w", errors.New("can't use password reset"), err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET pass_hash = $1, token_version = token_version + 1 WHERE login = $2",
+		hashPassword, login)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errors.New("can't update password"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrorResetInvalid
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = now() WHERE user_login = $1 AND used_at IS NULL", login)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", errors.New("can't invalidate password resets"), err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return login, nil
+}
+
+// SetTOTP stores a new, not yet enabled, TOTP secret along with hashed
+// recovery codes, replacing any previous enrollment of the user.
+func (s *Storage) SetTOTP(ctx context.Context, login string, secret string, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_totp (user_login, secret, enabled, created_at) VALUES ($1, $2, FALSE, now())
+		ON CONFLICT (user_login) DO UPDATE SET secret = EXCLUDED.secret, enabled = FALSE, created_at = EXCLUDED.created_at, last_step = 0`,
+		login, secret)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't set totp secret"), err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_login = $1", login); err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't delete recovery codes"), err)
+	}
+	for _, h := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_login, code_hash) VALUES ($1, $2)", login, h)
+		if err != nil {
+			return fmt.Errorf("%s: %w", errors.New("can't add recovery code"), err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) GetTOTP(ctx context.Context, login string) (*user.TOTP, error) {
+	t := &user.TOTP{}
+	row := s.db.QueryRowContext(ctx,
+		"SELECT user_login, secret, enabled FROM user_totp WHERE user_login = $1", login)
+	if err := row.Scan(&t.Login, &t.Secret, &t.Enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrorTOTPNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get totp secret"), err)
+	}
+	return t, nil
+}
+
+func (s *Storage) EnableTOTP(ctx context.Context, login string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE user_totp SET enabled = TRUE WHERE user_login = $1", login)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't enable totp"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrorTOTPNotFound
+	}
+	return nil
+}
+
+func (s *Storage) DeleteTOTP(ctx context.Context, login string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_login = $1", login); err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't delete totp secret"), err)
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_login = $1", login); err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't delete recovery codes"), err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *Storage) UseRecoveryCode(ctx context.Context, login string, codeHash string) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = now() WHERE user_login = $1 AND code_hash = $2 AND used_at IS NULL",
+		login, codeHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't use recovery code"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrorRecoveryCode
+	}
+	return nil
+}
+
+// UseTOTPStep records the time step of a TOTP code being used, failing
+// with ErrorTOTPReplay unless it is later than the last one used.
+func (s *Storage) UseTOTPStep(ctx context.Context, login string, step uint64) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE user_totp SET last_step = $2 WHERE user_login = $1 AND last_step < $2",
+		login, int64(step))
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't use totp step"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrorTOTPReplay
+	}
+	return nil
+}
+
 func (s *Storage) AddOrder(ctx context.Context, o order.Order) error {
-	stmt, err := s.db.Prepare("INSERT INTO orders(number, user_login, status, accrual, uploaded_at) values($1,$2,$3,$4,$5)")
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		s.log.Error("error insert order number to table", err)
 		return err
 	}
-	_, err = stmt.ExecContext(
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
 		ctx,
+		"INSERT INTO orders(number, user_login, status, accrual, uploaded_at) values($1,$2,$3,$4,$5)",
 		o.Number,
 		o.UserLogin,
 		o.Status,
@@ -120,8 +671,15 @@ func (s *Storage) AddOrder(ctx context.Context, o order.Order) error {
 				return ErrorOrderExist
 			}
 		}
+		s.log.Error("error insert order number to table", err)
+		return err
 	}
-	return nil
+
+	if err = addOutboxEvent(ctx, tx, event.TypeOrderAdded, o.UserLogin, o); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *Storage) GetOrder(ctx context.Context, number order.Number) (*order.Order, error) {
@@ -135,7 +693,7 @@ func (s *Storage) GetOrder(ctx context.Context, number order.Number) (*order.Ord
 	err = row.Scan(&o.Number, &o.UserLogin, &o.Status, &o.Accrual, &o.UploadedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("order not found")
+			return nil, ErrorOrderNotFound
 		}
 		return nil, fmt.Errorf("%s: %w", errors.New("can't get order"), err)
 	}
@@ -205,8 +763,11 @@ func (s *Storage) GetOrderWithStatuses(ctx context.Context, processing order.Sta
 	return orders, nil
 }
 
+// UpdateOrder stores the order's status and accrual. If either changed,
+// the order is announced on the OrderUpdatesChannel once the update is
+// committed, see ListenOrderUpdates.
 func (s *Storage) UpdateOrder(ctx context.Context, o order.Order) error {
-	stmt, err := s.db.Prepare("UPDATE orders SET status=$1, accrual=$2 WHERE number=$3")
+	stmt, err := s.db.Prepare(queryUpdateOrder)
 	if err != nil {
 		return err
 	}
@@ -221,10 +782,33 @@ func (s *Storage) UpdateOrder(ctx context.Context, o order.Order) error {
 	return nil
 }
 
+// UpdateOrderAudited is UpdateOrder for a change made by an administrator,
+// recording it in the audit log within the same transaction.
+func (s *Storage) UpdateOrderAudited(ctx context.Context, o order.Order, e audit.Entry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, queryUpdateOrder,
+		o.Status,
+		o.Accrual,
+		o.Number,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't update order"), err)
+	}
+
+	if err = addAuditEntry(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (s *Storage) GetAccruals(ctx context.Context, login string) (float32, error) {
 	var accrual float32
-	row := s.db.QueryRowContext(ctx,
-		"SELECT sum(accrual) FROM orders WHERE user_login = $1", login)
+	row := s.db.QueryRowContext(ctx, queryAccruals, login)
 
 	if err := row.Scan(&accrual); err != nil {
 		return 0, err
@@ -235,7 +819,7 @@ func (s *Storage) GetAccruals(ctx context.Context, login string) (float32, error
 func (s *Storage) GetWithdrawn(ctx context.Context, login string) (float32, error) {
 	var withdrawn float32
 	row := s.db.QueryRowContext(ctx,
-		"SELECT sum(sum) FROM withdrawals WHERE user_login = $1", login)
+		"SELECT coalesce(SUM(sum), 0.00) FROM withdrawals WHERE user_login = $1", login)
 
 	if err := row.Scan(&withdrawn); err != nil {
 		return 0, err
@@ -244,17 +828,27 @@ func (s *Storage) GetWithdrawn(ctx context.Context, login string) (float32, erro
 }
 
 func (s *Storage) AddWithdrawal(ctx context.Context, wd withdraw.Withdraw, login string) (*withdraw.Withdraw, error) {
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	// Locking the user row serializes concurrent withdrawals from one
+	// balance, as in AddBalanceAdjustment.
+	var locked string
+	row := tx.QueryRowContext(ctx, "SELECT login FROM users WHERE login = $1 FOR UPDATE", login)
+	if err = row.Scan(&locked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrorUserNotFound
+		}
+		return nil, err
+	}
 
 	var accrual float32
-	row := tx.QueryRowContext(ctx,
-		"SELECT sum(accrual) FROM orders WHERE user_login = $1", login)
+	row = tx.QueryRowContext(ctx, queryAccruals, login)
 	if err = row.Scan(&accrual); err != nil {
 		s.log.Error("error get current balance")
-		tx.Rollback()
 		return nil, err
 	}
 
@@ -263,7 +857,6 @@ func (s *Storage) AddWithdrawal(ctx context.Context, wd withdraw.Withdraw, login
 		"SELECT coalesce(SUM(sum), 0.00) FROM withdrawals WHERE user_login = $1", login)
 	if err = row.Scan(&withdrawn); err != nil {
 		s.log.Error("error get withdrawn")
-		tx.Rollback()
 		return nil, err
 	}
 
@@ -272,14 +865,17 @@ func (s *Storage) AddWithdrawal(ctx context.Context, wd withdraw.Withdraw, login
 		return nil, ErrorNotEnoughFunds
 	}
 
-	_, err = s.db.ExecContext(ctx, "INSERT INTO withdrawals (order_number, user_login, sum, processed_at) VALUES ($1, $2, $3, $4)",
+	_, err = tx.ExecContext(ctx, "INSERT INTO withdrawals (order_number, user_login, sum, processed_at) VALUES ($1, $2, $3, $4)",
 		wd.Order, wd.User, wd.Sum, wd.ProcessedAt)
 	if err != nil {
 		s.log.Error("error add withdrawal")
-		tx.Rollback()
 		return nil, ErrorAddWithdrawal
 	}
 
+	if err = addOutboxEvent(ctx, tx, event.TypeWithdrawalCreated, login, wd); err != nil {
+		return nil, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -317,3 +913,544 @@ func (s *Storage) GetWithdrawals(ctx context.Context, login string) ([]*withdraw
 
 	return withdrawals, nil
 }
+
+// AddBalanceAdjustment applies a manual credit or debit and records it in
+// the audit log within one transaction. A debit may not exceed the
+// current balance.
+func (s *Storage) AddBalanceAdjustment(ctx context.Context, a withdraw.Adjustment) (*withdraw.Adjustment, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the user row serializes concurrent adjustments of one balance.
+	var login string
+	row := tx.QueryRowContext(ctx, "SELECT login FROM users WHERE login = $1 FOR UPDATE", a.User)
+	if err = row.Scan(&login); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrorUserNotFound
+		}
+		return nil, err
+	}
+
+	if a.Amount < 0 {
+		var accrual, withdrawn float32
+		if err = tx.QueryRowContext(ctx, queryAccruals, a.User).Scan(&accrual); err != nil {
+			return nil, err
+		}
+		row = tx.QueryRowContext(ctx,
+			"SELECT coalesce(SUM(sum), 0.00) FROM withdrawals WHERE user_login = $1", a.User)
+		if err = row.Scan(&withdrawn); err != nil {
+			return nil, err
+		}
+		if accrual-withdrawn+a.Amount < 0 {
+			return nil, ErrorNotEnoughFunds
+		}
+	}
+
+	row = tx.QueryRowContext(ctx,
+		"INSERT INTO balance_adjustments (user_login, amount, reason, actor, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		a.User, a.Amount, a.Reason, a.Actor, a.CreatedAt)
+	if err = row.Scan(&a.ID); err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't add balance adjustment"), err)
+	}
+
+	details, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	err = addAuditEntry(ctx, tx, audit.Entry{
+		Actor:     a.Actor,
+		Action:    audit.ActionBalanceAdjust,
+		Target:    a.User,
+		Details:   details,
+		CreatedAt: a.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *Storage) AddAPIKey(ctx context.Context, k apikey.Key) (*apikey.Key, error) {
+	row := s.db.QueryRowContext(ctx,
+		"INSERT INTO api_keys (user_login, name, prefix, key_hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		k.User, k.Name, k.Prefix, k.Hash, pq.Array(k.Scopes), k.CreatedAt)
+	if err := row.Scan(&k.ID); err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't add api key"), err)
+	}
+	return &k, nil
+}
+
+func (s *Storage) GetAPIKeys(ctx context.Context, login string) ([]*apikey.Key, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, user_login, name, prefix, key_hash, scopes, created_at, last_used_at FROM api_keys
+		WHERE user_login = $1 AND revoked_at IS NULL ORDER BY created_at DESC`, login)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get api keys"), err)
+	}
+	defer rows.Close()
+
+	keys := make([]*apikey.Key, 0)
+	for rows.Next() {
+		k := &apikey.Key{}
+		if err = rows.Scan(&k.ID, &k.User, &k.Name, &k.Prefix, &k.Hash, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the key with the hash unless it has been revoked.
+func (s *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.Key, error) {
+	k := &apikey.Key{}
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, user_login, name, prefix, key_hash, scopes, created_at, last_used_at FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL`, hash)
+	err := row.Scan(&k.ID, &k.User, &k.Name, &k.Prefix, &k.Hash, pq.Array(&k.Scopes), &k.CreatedAt, &k.LastUsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrorAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get api key"), err)
+	}
+	return k, nil
+}
+
+func (s *Storage) TouchAPIKey(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = now() WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't touch api key"), err)
+	}
+	return nil
+}
+
+func (s *Storage) RevokeAPIKey(ctx context.Context, login string, id int64) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_login = $2 AND revoked_at IS NULL", id, login)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't revoke api key"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrorAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *Storage) AddAuditEntry(ctx context.Context, e audit.Entry) error {
+	return addAuditEntry(ctx, s.db, e)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func addAuditEntry(ctx context.Context, db execer, e audit.Entry) error {
+	var details []byte
+	if len(e.Details) > 0 {
+		details = e.Details
+	}
+	_, err := db.ExecContext(ctx,
+		"INSERT INTO audit_log (actor, action, target, details, created_at) VALUES ($1, $2, $3, $4, $5)",
+		e.Actor, e.Action, e.Target, details, e.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't add audit entry"), err)
+	}
+	return nil
+}
+
+func (s *Storage) GetAuditLog(ctx context.Context, limit int) ([]*audit.Entry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, actor, action, target, details, created_at FROM audit_log ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get audit log"), err)
+	}
+	defer rows.Close()
+
+	entries := make([]*audit.Entry, 0)
+	for rows.Next() {
+		e := &audit.Entry{}
+		var details []byte
+		if err = rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Details = details
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func addOutboxEvent(ctx context.Context, db execer, eventType string, login string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO outbox (event_type, user_login, payload) VALUES ($1, $2, $3)", eventType, login, payload)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't add outbox event"), err)
+	}
+	return nil
+}
+
+// AddWebhook adds a webhook, failing with ErrorWebhookLimit when the user
+// already has webhook.MaxPerUser of them. Administrators' webhooks are not
+// limited.
+func (s *Storage) AddWebhook(ctx context.Context, w webhook.Webhook) (*webhook.Webhook, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if w.User != "" {
+		// Locking the user row serializes concurrent additions.
+		row := tx.QueryRowContext(ctx, "SELECT login FROM users WHERE login = $1 FOR UPDATE", w.User)
+		if err = row.Scan(new(string)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrorUserNotFound
+			}
+			return nil, err
+		}
+		var count int
+		row = tx.QueryRowContext(ctx,
+			"SELECT count(*) FROM webhooks WHERE user_login = $1 AND revoked_at IS NULL", w.User)
+		if err = row.Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= webhook.MaxPerUser {
+			return nil, ErrorWebhookLimit
+		}
+	}
+
+	row := tx.QueryRowContext(ctx,
+		"INSERT INTO webhooks (user_login, url, events, secret, created_at) VALUES (NULLIF($1, ''), $2, $3, $4, $5) RETURNING id",
+		w.User, w.URL, pq.Array(w.Events), w.Secret, w.CreatedAt)
+	if err = row.Scan(&w.ID); err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't add webhook"), err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// GetWebhooks returns the webhooks of the user, or the administrators'
+// ones for an empty login.
+func (s *Storage) GetWebhooks(ctx context.Context, login string) ([]*webhook.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, coalesce(user_login, ''), url, events, secret, created_at FROM webhooks
+		WHERE user_login IS NOT DISTINCT FROM NULLIF($1, '') AND revoked_at IS NULL ORDER BY created_at DESC`, login)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get webhooks"), err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]*webhook.Webhook, 0)
+	for rows.Next() {
+		w := &webhook.Webhook{}
+		if err = rows.Scan(&w.ID, &w.User, &w.URL, pq.Array(&w.Events), &w.Secret, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook stops deliveries to the webhook, keeping its log.
+func (s *Storage) DeleteWebhook(ctx context.Context, login string, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE webhooks SET revoked_at = now()
+		WHERE id = $1 AND user_login IS NOT DISTINCT FROM NULLIF($2, '') AND revoked_at IS NULL`, id, login)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't delete webhook"), err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrorWebhookNotFound
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = $1, error = 'webhook deleted', next_attempt_at = NULL
+		WHERE webhook_id = $2 AND status = $3`, webhook.DeliveryFailed, id, webhook.DeliveryPending)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't delete webhook"), err)
+	}
+
+	return tx.Commit()
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a webhook owned
+// by the user, or by administrators for an empty login.
+func (s *Storage) GetWebhookDeliveries(ctx context.Context, login string, id int64, limit int) ([]*webhook.Delivery, error) {
+	var exists bool
+	row := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND user_login IS NOT DISTINCT FROM NULLIF($2, ''))", id, login)
+	if err := row.Scan(&exists); err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get webhook"), err)
+	}
+	if !exists {
+		return nil, ErrorWebhookNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT d.id, d.webhook_id, d.event_id, e.event_type, d.status, d.attempts, d.response_status,
+		       coalesce(d.error, ''), d.next_attempt_at, d.created_at, d.delivered_at
+		FROM webhook_deliveries d JOIN outbox e ON e.id = d.event_id
+		WHERE d.webhook_id = $1 ORDER BY d.id DESC LIMIT $2`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't get webhook deliveries"), err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]*webhook.Delivery, 0)
+	for rows.Next() {
+		d := &webhook.Delivery{}
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.ResponseStatus,
+			&d.Error, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// AddWebhookDeliveries schedules delivery of the event to every webhook
+// subscribed to it. Scheduling an event again is a no-op.
+func (s *Storage) AddWebhookDeliveries(ctx context.Context, e event.Event) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event_id, status, next_attempt_at)
+		SELECT id, $1, $2, now() FROM webhooks
+		WHERE revoked_at IS NULL AND (user_login = $3 OR user_login IS NULL) AND $4 = ANY(events)
+		ON CONFLICT DO NOTHING`,
+		e.ID, webhook.DeliveryPending, e.User, e.Type)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't add webhook deliveries"), err)
+	}
+	return nil
+}
+
+// relayLockID is the advisory lock that lets a single relay at a time
+// claim events, which keeps the events of a user in order.
+const relayLockID = 0x6f7574626f78
+
+// queryClaimEvents leases the pending events of up to $1 users whose
+// oldest pending event is due, up to $1 events in all. A user is picked
+// up again only once that event is due, so the events of a user are never
+// published by two relays at a time, and a user held back by a failing
+// event doesn't hold back the others.
+const queryClaimEvents = `
+	WITH heads AS (
+	    SELECT DISTINCT ON (user_login) id, user_login, next_attempt_at
+	    FROM outbox WHERE dispatched_at IS NULL AND parked_at IS NULL
+	    ORDER BY user_login, id),
+	due AS (
+	    SELECT user_login FROM heads
+	    WHERE next_attempt_at IS NULL OR next_attempt_at <= now()
+	    ORDER BY id LIMIT $1),
+	claimed AS (
+	    UPDATE outbox SET next_attempt_at = now() + $2 * interval '1 millisecond'
+	    WHERE id IN (
+	        SELECT id FROM outbox
+	        WHERE dispatched_at IS NULL AND parked_at IS NULL AND user_login IN (SELECT user_login FROM due)
+	        ORDER BY id LIMIT $1)
+	    RETURNING id, event_type, user_login, payload, created_at, attempts)
+	SELECT id, event_type, user_login, payload, created_at, attempts FROM claimed ORDER BY id`
+
+// ClaimEvents returns up to limit unpublished events, oldest first,
+// reserving them for the lease. Nothing is claimed while another replica
+// is claiming.
+func (s *Storage) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*event.Claim, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err = tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", relayLockID).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't lock outbox"), err)
+	}
+	if !locked {
+		return nil, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, queryClaimEvents, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't claim outbox events"), err)
+	}
+	claims := make([]*event.Claim, 0)
+	for rows.Next() {
+		c := &event.Claim{}
+		var data []byte
+		if err = rows.Scan(&c.ID, &c.Type, &c.User, &data, &c.CreatedAt, &c.Attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.Data = data
+		claims = append(claims, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// RecordEvents stores the outcomes of publishing claimed events.
+func (s *Storage) RecordEvents(ctx context.Context, outcomes []event.Outcome) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, o := range outcomes {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE outbox SET
+			    dispatched_at = CASE WHEN $2 = '`+event.StatusPublished+`' THEN now() END,
+			    parked_at = CASE WHEN $2 = '`+event.StatusParked+`' THEN now() END,
+			    attempts = attempts + CASE WHEN $2 = '`+event.StatusSkipped+`' THEN 0 ELSE 1 END,
+			    error = coalesce(NULLIF($3, ''), error),
+			    next_attempt_at = now() + $4 * interval '1 millisecond'
+			WHERE id = $1 AND dispatched_at IS NULL`,
+			o.EventID, o.Status, o.Error, o.RetryIn.Milliseconds())
+		if err != nil {
+			return fmt.Errorf("%s: %w", errors.New("can't record outbox event"), err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) PurgePasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM password_resets WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", errors.New("can't purge password resets"), err)
+	}
+	return res.RowsAffected()
+}
+
+// queryPruneOutbox deletes up to $2 events published before $1 together
+// with their deliveries. Events still being delivered are kept.
+const queryPruneOutbox = `
+	WITH pruned AS (
+	    SELECT id FROM outbox o
+	    WHERE dispatched_at < $1
+	      AND NOT EXISTS (
+	          SELECT 1 FROM webhook_deliveries d WHERE d.event_id = o.id AND d.status = 'pending')
+	    ORDER BY id
+	    LIMIT $2
+	), deliveries AS (
+	    DELETE FROM webhook_deliveries WHERE event_id IN (SELECT id FROM pruned)
+	)
+	DELETE FROM outbox WHERE id IN (SELECT id FROM pruned)`
+
+// PruneOutbox deletes up to limit events published before the given time
+// and returns how many it deleted.
+func (s *Storage) PruneOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := s.db.ExecContext(ctx, queryPruneOutbox, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", errors.New("can't prune outbox"), err)
+	}
+	return res.RowsAffected()
+}
+
+// queryClaimDeliveries postpones due deliveries by the lease, so that no
+// other dispatcher picks them up while they are being sent.
+const queryClaimDeliveries = `
+	WITH claimed AS (
+	    UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 millisecond'
+	    WHERE id IN (
+	        SELECT id FROM webhook_deliveries
+	        WHERE status = $3 AND next_attempt_at <= now()
+	        ORDER BY next_attempt_at LIMIT $1
+	        FOR UPDATE SKIP LOCKED)
+	    RETURNING id, webhook_id, event_id, attempts)
+	SELECT c.id, c.attempts, w.id, w.url, w.secret, e.id, e.event_type, e.user_login, e.payload, e.created_at
+	FROM claimed c
+	JOIN webhooks w ON w.id = c.webhook_id
+	JOIN outbox e ON e.id = c.event_id
+	ORDER BY e.id`
+
+// ClaimDeliveries returns up to limit deliveries that are due, reserving
+// them for the lease.
+func (s *Storage) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*webhook.Task, error) {
+	rows, err := s.db.QueryContext(ctx, queryClaimDeliveries, limit, lease.Milliseconds(), webhook.DeliveryPending)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errors.New("can't claim deliveries"), err)
+	}
+	defer rows.Close()
+
+	tasks := make([]*webhook.Task, 0)
+	for rows.Next() {
+		t := &webhook.Task{}
+		var data []byte
+		err = rows.Scan(&t.DeliveryID, &t.Attempts, &t.Webhook.ID, &t.Webhook.URL, &t.Webhook.Secret,
+			&t.Event.ID, &t.Event.Type, &t.Event.User, &data, &t.Event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		t.Event.Data = data
+		tasks = append(tasks, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// RecordDelivery stores the outcome of an attempt.
+func (s *Storage) RecordDelivery(ctx context.Context, a webhook.Attempt) error {
+	var next *int64
+	if a.Status == webhook.DeliveryPending {
+		ms := a.RetryIn.Milliseconds()
+		next = &ms
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET
+		    status = $2,
+		    attempts = attempts + 1,
+		    response_status = $3,
+		    error = NULLIF($4, ''),
+		    next_attempt_at = now() + $5 * interval '1 millisecond',
+		    delivered_at = CASE WHEN $2 = '`+webhook.DeliveryDelivered+`' THEN now() END
+		WHERE id = $1`,
+		a.DeliveryID, a.Status, a.ResponseStatus, a.Error, next)
+	if err != nil {
+		return fmt.Errorf("%s: %w", errors.New("can't record delivery"), err)
+	}
+	return nil
+}
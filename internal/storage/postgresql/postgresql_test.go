@@ -0,0 +1,144 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestStorage connects to the database in TEST_DATABASE_URI and skips
+// the test if it isn't set. Tests share the database, so they use fresh
+// logins, see testLogin.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	s, err := New(uri, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func testLogin(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+}
+
+func TestResetPassword(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	login := testLogin(t)
+	require.NoError(t, s.AddUser(ctx, user.User{Login: login, HashPassword: "old"}))
+
+	expiresAt := time.Now().Add(time.Hour)
+	for _, hash := range []string{login + "-a", login + "-b"} {
+		require.NoError(t, s.AddPasswordReset(ctx, user.PasswordReset{Login: login, TokenHash: hash, ExpiresAt: expiresAt}))
+	}
+	require.NoError(t, s.AddPasswordReset(ctx, user.PasswordReset{Login: login, TokenHash: login + "-expired", ExpiresAt: time.Now().Add(-time.Minute)}))
+
+	_, err := s.ResetPassword(ctx, login+"-expired", "new")
+	assert.ErrorIs(t, err, ErrorResetInvalid)
+
+	got, err := s.ResetPassword(ctx, login+"-a", "new")
+	require.NoError(t, err)
+	assert.Equal(t, login, got)
+
+	u, err := s.GetUser(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, "new", u.HashPassword)
+	assert.Equal(t, 1, u.TokenVersion)
+
+	// Neither the used token nor the other outstanding one work anymore.
+	_, err = s.ResetPassword(ctx, login+"-a", "newer")
+	assert.ErrorIs(t, err, ErrorResetInvalid)
+	_, err = s.ResetPassword(ctx, login+"-b", "newer")
+	assert.ErrorIs(t, err, ErrorResetInvalid)
+
+	u, err = s.GetUser(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, "new", u.HashPassword)
+}
+
+func TestClaimEvents(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	alice, bob := testLogin(t)+"-alice", testLogin(t)+"-bob"
+	for _, login := range []string{alice, alice, bob} {
+		require.NoError(t, addOutboxEvent(ctx, s.db, event.TypeOrderAdded, login, map[string]string{}))
+	}
+
+	// claim returns the IDs of the claimed events of alice and bob.
+	claim := func() map[string][]int64 {
+		claims, err := s.ClaimEvents(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+		ids := make(map[string][]int64)
+		for _, c := range claims {
+			if c.User == alice || c.User == bob {
+				ids[c.User] = append(ids[c.User], c.ID)
+			}
+		}
+		return ids
+	}
+
+	ids := claim()
+	require.Len(t, ids[alice], 2)
+	require.Len(t, ids[bob], 1)
+	assert.Empty(t, claim(), "claimed events are leased")
+
+	require.NoError(t, s.RecordEvents(ctx, []event.Outcome{
+		{EventID: ids[alice][0], Status: event.StatusFailed, Error: "unavailable", RetryIn: time.Hour},
+		{EventID: ids[alice][1], Status: event.StatusSkipped},
+		{EventID: ids[bob][0], Status: event.StatusPublished},
+	}))
+	assert.Empty(t, claim(), "alice waits for her failed event")
+
+	require.NoError(t, s.RecordEvents(ctx, []event.Outcome{
+		{EventID: ids[alice][0], Status: event.StatusParked, Error: "unavailable"},
+	}))
+	assert.Equal(t, map[string][]int64{alice: {ids[alice][1]}}, claim(), "alice's later event goes ahead of the parked one")
+}
+
+func TestLinkIdentity(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	login, other := testLogin(t), testLogin(t)+"-other"
+	issuer, subject := "https://idp.example.com", testLogin(t)
+	require.NoError(t, s.AddUser(ctx, user.User{Login: login, HashPassword: "hash"}))
+	require.NoError(t, s.AddUser(ctx, user.User{Login: other, HashPassword: "hash"}))
+
+	require.NoError(t, s.LinkIdentity(ctx, issuer, subject, login))
+	require.NoError(t, s.LinkIdentity(ctx, issuer, subject, login), "linking again is a no-op")
+	assert.ErrorIs(t, s.LinkIdentity(ctx, issuer, subject, other), ErrorIdentityLinked)
+
+	got, err := s.GetIdentity(ctx, issuer, subject)
+	require.NoError(t, err)
+	assert.Equal(t, login, got)
+
+	// A concurrent first login finds the identity provisioned already.
+	err = s.AddIdentityUser(ctx, issuer, subject, user.User{Login: testLogin(t) + "-new", HashPassword: "hash"})
+	assert.ErrorIs(t, err, ErrorIdentityLinked)
+}
+
+func TestBalanceOfNewUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	login := testLogin(t)
+	require.NoError(t, s.AddUser(ctx, user.User{Login: login, HashPassword: "hash"}))
+
+	accruals, err := s.GetAccruals(ctx, login)
+	require.NoError(t, err)
+	assert.Zero(t, accruals)
+
+	withdrawn, err := s.GetWithdrawn(ctx, login)
+	require.NoError(t, err)
+	assert.Zero(t, withdrawn)
+}
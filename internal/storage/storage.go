@@ -2,14 +2,35 @@ package storage
 
 import (
 	"context"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/event"
 	"github.com/kholodmv/gophermart/internal/models/order"
 	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
 	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"time"
 )
 
 type Storage interface {
 	AddUser(ctx context.Context, user user.User) error
 	GetUser(ctx context.Context, login string) (*user.User, error)
+	UpdatePassword(ctx context.Context, login string, hashPassword string) (int, error)
+	SetUserRole(ctx context.Context, login string, role string) error
+	GetIdentity(ctx context.Context, issuer string, subject string) (string, error)
+	AddIdentityUser(ctx context.Context, issuer string, subject string, u user.User) error
+	LinkIdentity(ctx context.Context, issuer string, subject string, login string) error
+	SearchUsers(ctx context.Context, query string, limit int, offset int) ([]*user.Summary, error)
+
+	AddPasswordReset(ctx context.Context, r user.PasswordReset) error
+	ResetPassword(ctx context.Context, tokenHash string, hashPassword string) (string, error)
+
+	SetTOTP(ctx context.Context, login string, secret string, recoveryHashes []string) error
+	GetTOTP(ctx context.Context, login string) (*user.TOTP, error)
+	EnableTOTP(ctx context.Context, login string) error
+	DeleteTOTP(ctx context.Context, login string) error
+	UseRecoveryCode(ctx context.Context, login string, codeHash string) error
+	UseTOTPStep(ctx context.Context, login string, step uint64) error
 
 	AddOrder(ctx context.Context, o order.Order) error
 	GetOrders(ctx context.Context, login string) ([]*order.Order, error)
@@ -17,10 +38,36 @@ type Storage interface {
 	GetOrderWithStatuses(ctx context.Context, processing order.Status, new order.Status) ([]order.Number, error)
 
 	UpdateOrder(ctx context.Context, o order.Order) error
+	UpdateOrderAudited(ctx context.Context, o order.Order, e audit.Entry) error
 
 	GetAccruals(ctx context.Context, login string) (float32, error)
 
 	GetWithdrawn(ctx context.Context, login string) (float32, error)
 	GetWithdrawals(ctx context.Context, login string) ([]*withdraw.Withdraw, error)
 	AddWithdrawal(ctx context.Context, wd withdraw.Withdraw, login string) (*withdraw.Withdraw, error)
+
+	AddBalanceAdjustment(ctx context.Context, a withdraw.Adjustment) (*withdraw.Adjustment, error)
+
+	AddAPIKey(ctx context.Context, k apikey.Key) (*apikey.Key, error)
+	GetAPIKeys(ctx context.Context, login string) ([]*apikey.Key, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.Key, error)
+	TouchAPIKey(ctx context.Context, id int64) error
+	RevokeAPIKey(ctx context.Context, login string, id int64) error
+
+	AddWebhook(ctx context.Context, w webhook.Webhook) (*webhook.Webhook, error)
+	GetWebhooks(ctx context.Context, login string) ([]*webhook.Webhook, error)
+	DeleteWebhook(ctx context.Context, login string, id int64) error
+	GetWebhookDeliveries(ctx context.Context, login string, id int64, limit int) ([]*webhook.Delivery, error)
+	AddWebhookDeliveries(ctx context.Context, e event.Event) error
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*webhook.Task, error)
+	RecordDelivery(ctx context.Context, a webhook.Attempt) error
+
+	ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*event.Claim, error)
+	RecordEvents(ctx context.Context, outcomes []event.Outcome) error
+
+	PurgePasswordResets(ctx context.Context, before time.Time) (int64, error)
+	PruneOutbox(ctx context.Context, before time.Time, limit int) (int64, error)
+
+	AddAuditEntry(ctx context.Context, e audit.Entry) error
+	GetAuditLog(ctx context.Context, limit int) ([]*audit.Entry, error)
 }
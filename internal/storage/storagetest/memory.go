@@ -0,0 +1,174 @@
+// Package storagetest provides an in-memory storage for tests of the API
+// servers.
+package storagetest
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/auth"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"sync"
+)
+
+// Memory implements the part of storage.Storage the user-facing API
+// reaches; anything else panics on the nil embedded interface. It returns
+// the same results and errors as the PostgreSQL storage.
+type Memory struct {
+	storage.Storage
+
+	mu          sync.Mutex
+	users       map[string]*user.User
+	orders      map[order.Number]order.Order
+	withdrawals []withdraw.Withdraw
+	accruals    map[string]float32
+	keys        map[string]apikey.Key
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:    make(map[string]*user.User),
+		orders:   make(map[order.Number]order.Order),
+		accruals: make(map[string]float32),
+		keys:     make(map[string]apikey.Key),
+	}
+}
+
+func (s *Memory) AddUser(_ context.Context, u user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.Login]; ok {
+		return postgresql.ErrorUserExists
+	}
+	s.users[u.Login] = &u
+	return nil
+}
+
+func (s *Memory) GetUser(_ context.Context, login string) (*user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[login]
+	if !ok {
+		return nil, postgresql.ErrorUserNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (s *Memory) GetTOTP(context.Context, string) (*user.TOTP, error) {
+	return nil, postgresql.ErrorTOTPNotFound
+}
+
+func (s *Memory) AddOrder(_ context.Context, o order.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.orders[o.Number]; ok {
+		if existing.UserLogin == o.UserLogin {
+			return postgresql.ErrorOrderAdded
+		}
+		return postgresql.ErrorOrderExist
+	}
+	s.orders[o.Number] = o
+	return nil
+}
+
+func (s *Memory) GetOrders(_ context.Context, login string) ([]*order.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var orders []*order.Order
+	for _, o := range s.orders {
+		if o.UserLogin == login {
+			cp := o
+			orders = append(orders, &cp)
+		}
+	}
+	return orders, nil
+}
+
+// GetAccruals and GetWithdrawn are zero for users without orders or
+// withdrawals, as the queries coalesce the missing sums.
+func (s *Memory) GetAccruals(_ context.Context, login string) (float32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accruals[login], nil
+}
+
+func (s *Memory) GetWithdrawn(_ context.Context, login string) (float32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.withdrawn(login), nil
+}
+
+func (s *Memory) withdrawn(login string) float32 {
+	var sum float32
+	for _, w := range s.withdrawals {
+		if w.User == login {
+			sum += w.Sum
+		}
+	}
+	return sum
+}
+
+func (s *Memory) GetWithdrawals(_ context.Context, login string) ([]*withdraw.Withdraw, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var withdrawals []*withdraw.Withdraw
+	for _, w := range s.withdrawals {
+		if w.User == login {
+			cp := w
+			withdrawals = append(withdrawals, &cp)
+		}
+	}
+	return withdrawals, nil
+}
+
+func (s *Memory) AddWithdrawal(_ context.Context, wd withdraw.Withdraw, login string) (*withdraw.Withdraw, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[login]; !ok {
+		return nil, postgresql.ErrorUserNotFound
+	}
+	if wd.Sum > s.accruals[login]-s.withdrawn(login) {
+		return nil, postgresql.ErrorNotEnoughFunds
+	}
+	s.withdrawals = append(s.withdrawals, wd)
+	return &wd, nil
+}
+
+func (s *Memory) GetAPIKeyByHash(_ context.Context, hash string) (*apikey.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[hash]
+	if !ok {
+		return nil, postgresql.ErrorAPIKeyNotFound
+	}
+	return &k, nil
+}
+
+func (s *Memory) TouchAPIKey(context.Context, int64) error {
+	return nil
+}
+
+// AddKey adds an API key of the user with the secret.
+func (s *Memory) AddKey(secret string, login string, scopes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[auth.HashToken(secret)] = apikey.Key{User: login, Scopes: scopes}
+}
+
+// SetAccrual sets the sum of the user's accruals.
+func (s *Memory) SetAccrual(login string, sum float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accruals[login] = sum
+}
+
+// RevokeSessions ends the sessions of the user, as a password change does.
+func (s *Memory) RevokeSessions(login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[login].TokenVersion++
+}
@@ -0,0 +1,142 @@
+// Package tlsconfig builds the TLS configuration of the servers. The key
+// pair is read again when its files change, so certificates can be
+// rotated without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"golang.org/x/exp/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Versions are the accepted minimum TLS versions.
+var Versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// pollInterval is how often the key pair files are checked for changes.
+const pollInterval = 10 * time.Second
+
+// Certificates serves the key pair from certFile and keyFile.
+type Certificates struct {
+	certFile string
+	keyFile  string
+	log      *slog.Logger
+	cert     atomic.Pointer[tls.Certificate]
+
+	pollInterval time.Duration
+	modTimes     [2]time.Time
+}
+
+// NewCertificates loads the key pair, failing if it can't.
+func NewCertificates(certFile string, keyFile string, log *slog.Logger) (*Certificates, error) {
+	c := &Certificates{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		log:          log.With(slog.String("component", "tls")),
+		pollInterval: pollInterval,
+	}
+	c.modTimes = c.stat()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Certificates) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("tlsconfig: load key pair: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *Certificates) stat() [2]time.Time {
+	var times [2]time.Time
+	for i, path := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(path); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// GetCertificate is the tls.Config hook that hands out the current pair.
+func (c *Certificates) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// Run reloads the key pair when its files change until ctx is done. A
+// pair that doesn't load, e.g. because only one of the files has been
+// replaced yet, is retried on the next change and the current one kept.
+func (c *Certificates) Run(ctx context.Context) error {
+	t := time.NewTicker(c.pollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			times := c.stat()
+			if times == c.modTimes {
+				continue
+			}
+			if err := c.load(); err != nil {
+				c.log.Error("failed to reload certificate", sl.Err(err))
+				continue
+			}
+			c.modTimes = times
+			c.log.Info("certificate reloaded", slog.String("file", c.certFile))
+		}
+	}
+}
+
+// Server returns the configuration of a server with the certificates,
+// offering HTTP/2.
+func Server(certs *Certificates, minVersion uint16) *tls.Config {
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// RequestClientCerts makes cfg verify the client certificates against
+// the CAs in caFile. Certificates are optional at the handshake, so that
+// RequireClientCert can protect some routes only.
+func RequestClientCerts(cfg *tls.Config, caFile string) error {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("tlsconfig: read client CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("tlsconfig: no certificates in client CA file")
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// RequireClientCert rejects requests without a verified client
+// certificate.
+func RequireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,232 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// authority issues certificates for tests.
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority(t *testing.T) *authority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gophermart test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM key pair for a server on 127.0.0.1 or a client.
+func (a *authority) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "gophermart"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (a *authority) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.cert)
+	return pool
+}
+
+type keyPair struct {
+	certFile string
+	keyFile  string
+}
+
+func writeKeyPair(t *testing.T, dir string, cert []byte, key []byte) keyPair {
+	t.Helper()
+	kp := keyPair{certFile: filepath.Join(dir, "tls.crt"), keyFile: filepath.Join(dir, "tls.key")}
+	require.NoError(t, os.WriteFile(kp.certFile, cert, 0o600))
+	require.NoError(t, os.WriteFile(kp.keyFile, key, 0o600))
+	modified = modified.Add(time.Second)
+	require.NoError(t, os.Chtimes(kp.certFile, modified, modified))
+	return kp
+}
+
+// modified spaces out the modification times of key pairs, which are
+// coarse on some file systems.
+var modified = time.Now()
+
+func newCertificates(t *testing.T, kp keyPair) *Certificates {
+	t.Helper()
+	certs, err := NewCertificates(kp.certFile, kp.keyFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	return certs
+}
+
+// serve runs a TLS server the way main does and returns its address.
+func serve(t *testing.T, cfg *tls.Config, handler http.Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: handler, TLSConfig: cfg}
+	go srv.ServeTLS(l, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + l.Addr().String()
+}
+
+func client(cfg *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, ForceAttemptHTTP2: true}}
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestServerHTTP2(t *testing.T) {
+	ca := newAuthority(t)
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	certs := newCertificates(t, writeKeyPair(t, t.TempDir(), cert, key))
+	url := serve(t, Server(certs, tls.VersionTLS12), ok)
+
+	resp, err := client(&tls.Config{RootCAs: ca.pool()}).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+func TestServerMinVersion(t *testing.T) {
+	ca := newAuthority(t)
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	certs := newCertificates(t, writeKeyPair(t, t.TempDir(), cert, key))
+	url := serve(t, Server(certs, tls.VersionTLS13), ok)
+
+	_, err := client(&tls.Config{RootCAs: ca.pool(), MaxVersion: tls.VersionTLS12}).Get(url)
+	assert.Error(t, err)
+
+	resp, err := client(&tls.Config{RootCAs: ca.pool()}).Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+}
+
+func TestCertificatesReload(t *testing.T) {
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	certs := newCertificates(t, writeKeyPair(t, dir, cert, key))
+	certs.pollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go certs.Run(ctx)
+
+	url := serve(t, Server(certs, tls.VersionTLS12), ok)
+	serial := func() int64 {
+		// A new transport makes a new connection and handshake.
+		resp, err := client(&tls.Config{RootCAs: ca.pool()}).Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	require.Equal(t, int64(2), serial())
+
+	// A broken pair is not picked up.
+	writeKeyPair(t, dir, []byte("garbage"), key)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(2), serial())
+
+	cert, key = ca.issue(t, 3, x509.ExtKeyUsageServerAuth)
+	writeKeyPair(t, dir, cert, key)
+	assert.Eventually(t, func() bool { return serial() == 3 }, time.Second, 5*time.Millisecond)
+}
+
+func TestRequireClientCert(t *testing.T) {
+	ca := newAuthority(t)
+	dir := t.TempDir()
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	certs := newCertificates(t, writeKeyPair(t, dir, cert, key))
+
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+	cfg := Server(certs, tls.VersionTLS12)
+	require.NoError(t, RequestClientCerts(cfg, caFile))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", RequireClientCert(ok))
+	mux.Handle("/healthz", ok)
+	url := serve(t, cfg, mux)
+
+	clientCert, clientKey := ca.issue(t, 4, x509.ExtKeyUsageClientAuth)
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		path   string
+		certs  []tls.Certificate
+		status int
+	}{
+		{name: "Metrics with a client certificate", path: "/metrics", certs: []tls.Certificate{pair}, status: http.StatusOK},
+		{name: "Metrics without a client certificate", path: "/metrics", status: http.StatusForbidden},
+		{name: "Health without a client certificate", path: "/healthz", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := client(&tls.Config{RootCAs: ca.pool(), Certificates: test.certs}).Get(url + test.path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.status, resp.StatusCode)
+		})
+	}
+
+	// Certificates of other authorities fail the handshake.
+	other := newAuthority(t)
+	otherCert, otherKey := other.issue(t, 5, x509.ExtKeyUsageClientAuth)
+	otherPair, err := tls.X509KeyPair(otherCert, otherKey)
+	require.NoError(t, err)
+	_, err = client(&tls.Config{RootCAs: ca.pool(), Certificates: []tls.Certificate{otherPair}}).Get(url + "/healthz")
+	assert.Error(t, err)
+}
@@ -0,0 +1,33 @@
+package totp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const RecoveryCodes = 10
+
+// GenerateRecoveryCodes returns single-use codes in the form xxxxx-xxxxx
+// that can stand in for a TOTP code when the authenticator is lost.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(b)
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode makes user input comparable with a generated code.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -0,0 +1,114 @@
+// Package totp implements time-based one-time passwords (RFC 6238) with the
+// parameters understood by common authenticator apps: HMAC-SHA1, six
+// digits and a 30 second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Skew is how many periods before and after the current one are
+	// accepted to tolerate clock drift.
+	Skew = 1
+
+	secretSize = 20
+)
+
+var (
+	encoding         = base32.StdEncoding.WithPadding(base32.NoPadding)
+	ErrInvalidSecret = errors.New("invalid totp secret")
+)
+
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// key URI which authenticator apps accept,
+// usually rendered as a QR code.
+func URI(issuer string, account string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", ErrInvalidSecret
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate reports whether the code is valid for the secret at t.
+func Validate(secret string, passcode string, t time.Time) bool {
+	_, ok := Match(secret, passcode, t)
+	return ok
+}
+
+// Match returns the time step the code is valid for at t. Callers
+// should reject steps at or below the last one used, as a code may only
+// be used once.
+func Match(secret string, passcode string, t time.Time) (uint64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(passcode) != Digits {
+		return 0, false
+	}
+
+	c := counter(t)
+	for i := -Skew; i <= Skew; i++ {
+		step := uint64(int64(c) + int64(i))
+		if hmac.Equal([]byte(code(key, step)), []byte(passcode)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(Period.Seconds()))
+}
+
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
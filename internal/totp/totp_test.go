@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Secret "12345678901234567890" from the RFC 6238 test vectors.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var codeTests = []struct {
+	name string
+	unix int64
+	want string
+}{
+	{name: "T=59", unix: 59, want: "287082"},
+	{name: "T=1111111109", unix: 1111111109, want: "081804"},
+	{name: "T=1111111111", unix: 1111111111, want: "050471"},
+	{name: "T=1234567890", unix: 1234567890, want: "005924"},
+	{name: "T=2000000000", unix: 2000000000, want: "279037"},
+}
+
+func TestCode(t *testing.T) {
+	for _, test := range codeTests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, time.Unix(test.unix, 0))
+			require.NoError(t, err)
+			assert.Equal(t, test.want, code)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+
+	assert.True(t, Validate(secret, code, now))
+	assert.True(t, Validate(secret, code, now.Add(Period)))
+	assert.False(t, Validate(secret, code, now.Add(3*Period)))
+	assert.False(t, Validate(secret, "12345", now))
+	assert.False(t, Validate("not base32!", code, now))
+}
+
+func TestMatch(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+
+	step, ok := Match(secret, code, now)
+	require.True(t, ok)
+	later, ok := Match(secret, code, now.Add(Period))
+	require.True(t, ok)
+	assert.Equal(t, step, later, "the step of the code, not of the time, is returned")
+	assert.Equal(t, uint64(now.Unix()/int64(Period.Seconds())), step)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Gophermart", "john", rfcSecret))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Gophermart:john", u.Path)
+	assert.Equal(t, rfcSecret, u.Query().Get("secret"))
+	assert.Equal(t, "Gophermart", u.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodes)
+
+	for _, c := range codes {
+		assert.Equal(t, c, NormalizeRecoveryCode(c))
+		assert.Equal(t, c, NormalizeRecoveryCode(" "+c[:5]+c[6:]+" "))
+	}
+}
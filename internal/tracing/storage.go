@@ -0,0 +1,357 @@
+package tracing
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/models/apikey"
+	"github.com/kholodmv/gophermart/internal/models/audit"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/user"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// InstrumentStorage wraps every call to db in a client span. Errors,
+// including expected ones such as a missing user, are recorded on it.
+func InstrumentStorage(db storage.Storage) storage.Storage {
+	return &tracedStorage{next: db}
+}
+
+type tracedStorage struct {
+	next storage.Storage
+}
+
+func (s *tracedStorage) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(method)),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStorage) AddUser(ctx context.Context, u user.User) error {
+	ctx, span := s.start(ctx, "AddUser")
+	err := s.next.AddUser(ctx, u)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetUser(ctx context.Context, login string) (*user.User, error) {
+	ctx, span := s.start(ctx, "GetUser")
+	res, err := s.next.GetUser(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) UpdatePassword(ctx context.Context, login string, hashPassword string) (int, error) {
+	ctx, span := s.start(ctx, "UpdatePassword")
+	res, err := s.next.UpdatePassword(ctx, login, hashPassword)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) SetUserRole(ctx context.Context, login string, role string) error {
+	ctx, span := s.start(ctx, "SetUserRole")
+	err := s.next.SetUserRole(ctx, login, role)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetIdentity(ctx context.Context, issuer string, subject string) (string, error) {
+	ctx, span := s.start(ctx, "GetIdentity")
+	res, err := s.next.GetIdentity(ctx, issuer, subject)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddIdentityUser(ctx context.Context, issuer string, subject string, u user.User) error {
+	ctx, span := s.start(ctx, "AddIdentityUser")
+	err := s.next.AddIdentityUser(ctx, issuer, subject, u)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) LinkIdentity(ctx context.Context, issuer string, subject string, login string) error {
+	ctx, span := s.start(ctx, "LinkIdentity")
+	err := s.next.LinkIdentity(ctx, issuer, subject, login)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) SearchUsers(ctx context.Context, query string, limit int, offset int) ([]*user.Summary, error) {
+	ctx, span := s.start(ctx, "SearchUsers")
+	res, err := s.next.SearchUsers(ctx, query, limit, offset)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddPasswordReset(ctx context.Context, r user.PasswordReset) error {
+	ctx, span := s.start(ctx, "AddPasswordReset")
+	err := s.next.AddPasswordReset(ctx, r)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) ResetPassword(ctx context.Context, tokenHash string, hashPassword string) (string, error) {
+	ctx, span := s.start(ctx, "ResetPassword")
+	res, err := s.next.ResetPassword(ctx, tokenHash, hashPassword)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) SetTOTP(ctx context.Context, login string, secret string, recoveryHashes []string) error {
+	ctx, span := s.start(ctx, "SetTOTP")
+	err := s.next.SetTOTP(ctx, login, secret, recoveryHashes)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetTOTP(ctx context.Context, login string) (*user.TOTP, error) {
+	ctx, span := s.start(ctx, "GetTOTP")
+	res, err := s.next.GetTOTP(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) EnableTOTP(ctx context.Context, login string) error {
+	ctx, span := s.start(ctx, "EnableTOTP")
+	err := s.next.EnableTOTP(ctx, login)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) DeleteTOTP(ctx context.Context, login string) error {
+	ctx, span := s.start(ctx, "DeleteTOTP")
+	err := s.next.DeleteTOTP(ctx, login)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) UseRecoveryCode(ctx context.Context, login string, codeHash string) error {
+	ctx, span := s.start(ctx, "UseRecoveryCode")
+	err := s.next.UseRecoveryCode(ctx, login, codeHash)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) UseTOTPStep(ctx context.Context, login string, step uint64) error {
+	ctx, span := s.start(ctx, "UseTOTPStep")
+	err := s.next.UseTOTPStep(ctx, login, step)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) AddOrder(ctx context.Context, o order.Order) error {
+	ctx, span := s.start(ctx, "AddOrder")
+	err := s.next.AddOrder(ctx, o)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetOrders(ctx context.Context, login string) ([]*order.Order, error) {
+	ctx, span := s.start(ctx, "GetOrders")
+	res, err := s.next.GetOrders(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetOrder(ctx context.Context, number order.Number) (*order.Order, error) {
+	ctx, span := s.start(ctx, "GetOrder")
+	res, err := s.next.GetOrder(ctx, number)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetOrderWithStatuses(ctx context.Context, processing order.Status, newStatus order.Status) ([]order.Number, error) {
+	ctx, span := s.start(ctx, "GetOrderWithStatuses")
+	res, err := s.next.GetOrderWithStatuses(ctx, processing, newStatus)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) UpdateOrder(ctx context.Context, o order.Order) error {
+	ctx, span := s.start(ctx, "UpdateOrder")
+	err := s.next.UpdateOrder(ctx, o)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) UpdateOrderAudited(ctx context.Context, o order.Order, e audit.Entry) error {
+	ctx, span := s.start(ctx, "UpdateOrderAudited")
+	err := s.next.UpdateOrderAudited(ctx, o, e)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetAccruals(ctx context.Context, login string) (float32, error) {
+	ctx, span := s.start(ctx, "GetAccruals")
+	res, err := s.next.GetAccruals(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetWithdrawn(ctx context.Context, login string) (float32, error) {
+	ctx, span := s.start(ctx, "GetWithdrawn")
+	res, err := s.next.GetWithdrawn(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetWithdrawals(ctx context.Context, login string) ([]*withdraw.Withdraw, error) {
+	ctx, span := s.start(ctx, "GetWithdrawals")
+	res, err := s.next.GetWithdrawals(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddWithdrawal(ctx context.Context, wd withdraw.Withdraw, login string) (*withdraw.Withdraw, error) {
+	ctx, span := s.start(ctx, "AddWithdrawal")
+	res, err := s.next.AddWithdrawal(ctx, wd, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddBalanceAdjustment(ctx context.Context, a withdraw.Adjustment) (*withdraw.Adjustment, error) {
+	ctx, span := s.start(ctx, "AddBalanceAdjustment")
+	res, err := s.next.AddBalanceAdjustment(ctx, a)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddAPIKey(ctx context.Context, k apikey.Key) (*apikey.Key, error) {
+	ctx, span := s.start(ctx, "AddAPIKey")
+	res, err := s.next.AddAPIKey(ctx, k)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetAPIKeys(ctx context.Context, login string) ([]*apikey.Key, error) {
+	ctx, span := s.start(ctx, "GetAPIKeys")
+	res, err := s.next.GetAPIKeys(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.Key, error) {
+	ctx, span := s.start(ctx, "GetAPIKeyByHash")
+	res, err := s.next.GetAPIKeyByHash(ctx, hash)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) TouchAPIKey(ctx context.Context, id int64) error {
+	ctx, span := s.start(ctx, "TouchAPIKey")
+	err := s.next.TouchAPIKey(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) RevokeAPIKey(ctx context.Context, login string, id int64) error {
+	ctx, span := s.start(ctx, "RevokeAPIKey")
+	err := s.next.RevokeAPIKey(ctx, login, id)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) AddWebhook(ctx context.Context, w webhook.Webhook) (*webhook.Webhook, error) {
+	ctx, span := s.start(ctx, "AddWebhook")
+	res, err := s.next.AddWebhook(ctx, w)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) GetWebhooks(ctx context.Context, login string) ([]*webhook.Webhook, error) {
+	ctx, span := s.start(ctx, "GetWebhooks")
+	res, err := s.next.GetWebhooks(ctx, login)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) DeleteWebhook(ctx context.Context, login string, id int64) error {
+	ctx, span := s.start(ctx, "DeleteWebhook")
+	err := s.next.DeleteWebhook(ctx, login, id)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetWebhookDeliveries(ctx context.Context, login string, id int64, limit int) ([]*webhook.Delivery, error) {
+	ctx, span := s.start(ctx, "GetWebhookDeliveries")
+	res, err := s.next.GetWebhookDeliveries(ctx, login, id, limit)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddWebhookDeliveries(ctx context.Context, e event.Event) error {
+	ctx, span := s.start(ctx, "AddWebhookDeliveries")
+	err := s.next.AddWebhookDeliveries(ctx, e)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*webhook.Task, error) {
+	ctx, span := s.start(ctx, "ClaimDeliveries")
+	res, err := s.next.ClaimDeliveries(ctx, limit, lease)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) RecordDelivery(ctx context.Context, a webhook.Attempt) error {
+	ctx, span := s.start(ctx, "RecordDelivery")
+	err := s.next.RecordDelivery(ctx, a)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]*event.Claim, error) {
+	ctx, span := s.start(ctx, "ClaimEvents")
+	res, err := s.next.ClaimEvents(ctx, limit, lease)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) RecordEvents(ctx context.Context, outcomes []event.Outcome) error {
+	ctx, span := s.start(ctx, "RecordEvents")
+	err := s.next.RecordEvents(ctx, outcomes)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) PurgePasswordResets(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := s.start(ctx, "PurgePasswordResets")
+	res, err := s.next.PurgePasswordResets(ctx, before)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) PruneOutbox(ctx context.Context, before time.Time, limit int) (int64, error) {
+	ctx, span := s.start(ctx, "PruneOutbox")
+	res, err := s.next.PruneOutbox(ctx, before, limit)
+	end(span, err)
+	return res, err
+}
+
+func (s *tracedStorage) AddAuditEntry(ctx context.Context, e audit.Entry) error {
+	ctx, span := s.start(ctx, "AddAuditEntry")
+	err := s.next.AddAuditEntry(ctx, e)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetAuditLog(ctx context.Context, limit int) ([]*audit.Entry, error) {
+	ctx, span := s.start(ctx, "GetAuditLog")
+	res, err := s.next.GetAuditLog(ctx, limit)
+	end(span, err)
+	return res, err
+}
@@ -0,0 +1,92 @@
+// Package tracing sets up OpenTelemetry tracing and instruments the HTTP
+// API and the storage with spans. Trace context is propagated in the W3C
+// traceparent header.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+const (
+	// ExporterNone keeps tracing disabled, spans are not recorded.
+	ExporterNone = "none"
+	// ExporterStdout prints spans as JSON, meant for development.
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OTLP collector over gRPC.
+	ExporterOTLP = "otlp"
+
+	tracerName  = "github.com/kholodmv/gophermart"
+	serviceName = "gophermart"
+)
+
+type Config struct {
+	Exporter string
+	// Endpoint is the host:port of the OTLP collector.
+	Endpoint string
+	// Insecure disables TLS to the collector.
+	Insecure bool
+}
+
+// Setup installs the global tracer provider and propagator. The returned
+// function flushes the spans left and must be called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	const op = "tracing.Setup"
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("%s: unknown exporter %q", op, cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The SDK describes itself in the semantic conventions it was built
+	// with, merging in a resource of a different schema would fail.
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer of the application.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
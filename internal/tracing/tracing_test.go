@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/postgresql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	var handlerSpan trace.SpanContext
+	router.Get("/api/user/webhooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/user/webhooks/7", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /api/user/webhooks/{id}", span.Name())
+	assert.Equal(t, traceID, span.SpanContext().TraceID().String(), "the trace of the caller is continued")
+	assert.Equal(t, span.SpanContext(), handlerSpan)
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, span.Attributes(), semconv.HTTPRoute("/api/user/webhooks/{id}"))
+	assert.Contains(t, span.Attributes(), semconv.HTTPStatusCode(http.StatusInternalServerError))
+}
+
+type userStorage struct {
+	storage.Storage
+}
+
+func (userStorage) GetAccruals(context.Context, string) (float32, error) {
+	return 0, postgresql.ErrorUserNotFound
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	recorder := record(t)
+
+	ctx, parent := Tracer().Start(context.Background(), "request")
+	_, err := InstrumentStorage(userStorage{}).GetAccruals(ctx, "gopher")
+	parent.End()
+	assert.ErrorIs(t, err, postgresql.ErrorUserNotFound)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	span := spans[0]
+	assert.Equal(t, "storage.GetAccruals", span.Name())
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Equal(t, codes.Error, span.Status().Code)
+	require.Len(t, span.Events(), 1, "the error is recorded")
+}
+
+func TestSetup(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	for _, exporter := range []string{ExporterNone, ExporterStdout} {
+		t.Run(exporter, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), Config{Exporter: exporter})
+			require.NoError(t, err)
+
+			_, span := Tracer().Start(context.Background(), "test")
+			span.End()
+
+			assert.NoError(t, shutdown(context.Background()))
+		})
+	}
+
+	_, err := Setup(context.Background(), Config{Exporter: "jaeger"})
+	assert.Error(t, err)
+}
@@ -2,9 +2,10 @@ package utils
 
 import (
 	"context"
-	"encoding/hex"
+	authcore "github.com/kholodmv/gophermart/internal/auth"
 	"github.com/kholodmv/gophermart/internal/http-server/middleware/auth"
-	"golang.org/x/crypto/bcrypt"
+	"net"
+	"net/http"
 )
 
 func IsValidLuhnNumber(number string) bool {
@@ -27,22 +28,22 @@ func IsValidLuhnNumber(number string) bool {
 }
 
 func GenerateHashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	hashPassword := hex.EncodeToString(hash)
-
-	return hashPassword, nil
+	return authcore.HashPassword(password)
 }
 
 func CompareHashAndPassword(hash, password string) error {
-	decHash, _ := hex.DecodeString(hash)
-
-	err := bcrypt.CompareHashAndPassword([]byte(decHash), []byte(password))
-	return err
+	return authcore.ComparePassword(hash, password)
 }
 
 func GetLogin(ctx context.Context) string {
 	return ctx.Value(auth.LoginKey).(string)
 }
+
+// ClientIP returns the host part of the request's remote address.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,46 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	LoginMinLength = 3
+	LoginMaxLength = 64
+)
+
+var loginPattern = regexp.MustCompile(`^[a-zA-Z0-9._@-]+$`)
+
+func ValidateLogin(login string) []Violation {
+	const field = "login"
+
+	if login == "" {
+		return []Violation{{Field: field, Code: CodeRequired, Message: "login is required"}}
+	}
+
+	var violations []Violation
+	switch n := utf8.RuneCountInString(login); {
+	case n < LoginMinLength:
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("login must be at least %d characters long", LoginMinLength),
+		})
+	case n > LoginMaxLength:
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("login must be at most %d characters long", LoginMaxLength),
+		})
+	}
+	if !loginPattern.MatchString(login) {
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeInvalid,
+			Message: "login may contain only latin letters, digits and . _ @ -",
+		})
+	}
+	return violations
+}
@@ -0,0 +1,130 @@
+package validation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordMaxLength is the bcrypt input limit, longer passwords would be
+// silently truncated.
+const PasswordMaxLength = 72
+
+var commonPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password", "password1",
+	"qwerty", "qwerty123", "qwertyuiop", "111111", "000000", "abc123",
+	"iloveyou", "admin", "admin123", "letmein", "welcome", "monkey",
+	"dragon", "football", "baseball", "sunshine", "princess", "master",
+	"passw0rd", "1q2w3e4r", "zaq12wsx", "changeme", "secret", "trustno1",
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lower case letters, upper case letters,
+	// digits and symbols a password has to contain.
+	MinClasses int
+	Denylist   map[string]struct{}
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	p := PasswordPolicy{
+		MinLength:  8,
+		MinClasses: 1,
+		Denylist:   make(map[string]struct{}, len(commonPasswords)),
+	}
+	for _, pass := range commonPasswords {
+		p.Denylist[pass] = struct{}{}
+	}
+	return p
+}
+
+// LoadDenylist adds passwords from a file, one per line, to the denylist.
+func (p *PasswordPolicy) LoadDenylist(path string) error {
+	const op = "validation.PasswordPolicy.LoadDenylist"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	if p.Denylist == nil {
+		p.Denylist = make(map[string]struct{})
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if pass := strings.TrimSpace(scanner.Text()); pass != "" {
+			p.Denylist[strings.ToLower(pass)] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (p PasswordPolicy) Validate(password string, login string) []Violation {
+	const field = "password"
+
+	if password == "" {
+		return []Violation{{Field: field, Code: CodeRequired, Message: "password is required"}}
+	}
+
+	var violations []Violation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if len(password) > PasswordMaxLength {
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("password must be at most %d bytes long", PasswordMaxLength),
+		})
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeClasses,
+			Message: fmt.Sprintf("password must contain at least %d of: lower case letters, upper case letters, digits, symbols", p.MinClasses),
+		})
+	}
+	if _, ok := p.Denylist[strings.ToLower(password)]; ok {
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeDenylisted,
+			Message: "password is too common",
+		})
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		violations = append(violations, Violation{
+			Field:   field,
+			Code:    CodeSameLogin,
+			Message: "password must differ from login",
+		})
+	}
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
@@ -0,0 +1,18 @@
+package validation
+
+// Violation describes why a single field of a request was rejected.
+type Violation struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeRequired   = "required"
+	CodeTooShort   = "too_short"
+	CodeTooLong    = "too_long"
+	CodeInvalid    = "invalid_format"
+	CodeClasses    = "not_enough_character_classes"
+	CodeDenylisted = "too_common"
+	CodeSameLogin  = "same_as_login"
+)
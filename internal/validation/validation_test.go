@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func codes(violations []Violation) []string {
+	var c []string
+	for _, v := range violations {
+		c = append(c, v.Code)
+	}
+	return c
+}
+
+var loginTests = []struct {
+	name  string
+	login string
+	want  []string
+}{
+	{name: "Valid login", login: "john.doe@example", want: nil},
+	{name: "Empty login", login: "", want: []string{CodeRequired}},
+	{name: "Short login", login: "jd", want: []string{CodeTooShort}},
+	{name: "Long login", login: string(make([]byte, LoginMaxLength+1)), want: []string{CodeTooLong, CodeInvalid}},
+	{name: "Login with spaces", login: "john doe", want: []string{CodeInvalid}},
+}
+
+func TestValidateLogin(t *testing.T) {
+	for _, test := range loginTests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, codes(ValidateLogin(test.login)))
+		})
+	}
+}
+
+var passwordTests = []struct {
+	name     string
+	policy   PasswordPolicy
+	password string
+	login    string
+	want     []string
+}{
+	{name: "Valid password", policy: DefaultPasswordPolicy(), password: "correct horse", login: "john", want: nil},
+	{name: "Empty password", policy: DefaultPasswordPolicy(), password: "", login: "john", want: []string{CodeRequired}},
+	{name: "Short password", policy: DefaultPasswordPolicy(), password: "x7!k", login: "john", want: []string{CodeTooShort}},
+	{name: "Common password", policy: DefaultPasswordPolicy(), password: "Password1", login: "john", want: []string{CodeDenylisted}},
+	{name: "Password equals login", policy: DefaultPasswordPolicy(), password: "johnathan", login: "Johnathan", want: []string{CodeSameLogin}},
+	{
+		name:     "Not enough character classes",
+		policy:   PasswordPolicy{MinLength: 8, MinClasses: 3},
+		password: "lowercase1",
+		login:    "john",
+		want:     []string{CodeClasses},
+	},
+	{
+		name:     "Enough character classes",
+		policy:   PasswordPolicy{MinLength: 8, MinClasses: 3},
+		password: "Lowercase1",
+		login:    "john",
+		want:     nil,
+	},
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	for _, test := range passwordTests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, codes(test.policy.Validate(test.password, test.login)))
+		})
+	}
+}
+
+func TestPasswordPolicyLoadDenylist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("Gophermart2023\n\n  hunter22  \n"), 0o600))
+
+	p := PasswordPolicy{MinLength: 8}
+	assert.NoError(t, p.LoadDenylist(path))
+
+	assert.Equal(t, []string{CodeDenylisted}, codes(p.Validate("gophermart2023", "john")))
+	assert.Equal(t, []string{CodeDenylisted}, codes(p.Validate("hunter22", "john")))
+}
@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for destinations inside the network the
+// service runs in, which webhooks must not be able to reach.
+var ErrForbiddenAddress = errors.New("webhooks: address is not public")
+
+// reservedNetworks are not covered by the net.IP predicates.
+var reservedNetworks = []*net.IPNet{
+	cidr("0.0.0.0/8"),
+	cidr("100.64.0.0/10"),
+	cidr("192.0.0.0/24"),
+	cidr("198.18.0.0/15"),
+	cidr("240.0.0.0/4"),
+	// NAT64 embeds IPv4 addresses, private ones included.
+	cidr("64:ff9b::/96"),
+}
+
+func cidr(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// PublicIP reports whether webhooks may be sent to ip.
+func PublicIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL rejects a URL whose host is, or currently resolves to, an
+// address that isn't public. Names that don't resolve pass; the
+// dispatcher checks the address again when it connects.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// control is the net.Dialer hook refusing connections to addresses that
+// aren't public. It sees the address the name resolved to, so DNS
+// rebinding can't get around the check made when the webhook was created.
+func control(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package webhooks delivers domain events to the webhooks subscribed to
+// them. Schedule is subscribed to the event bus and records a delivery
+// per webhook; the Dispatcher sends them.
+//
+// Every request carries the event as JSON and an X-Gophermart-Signature
+// header of the form "t=<unix time>,v1=<hex HMAC-SHA256>", where the HMAC
+// of "<unix time>.<body>" is keyed with the webhook's secret. Receivers
+// should check the signature and reject stale timestamps.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/logger/sl"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderDelivery  = "X-Gophermart-Delivery"
+	HeaderSignature = "X-Gophermart-Signature"
+
+	maxErrorLength = 512
+)
+
+type Config struct {
+	// Interval is how often due deliveries are checked.
+	Interval  time.Duration
+	BatchSize int
+	// Concurrency limits simultaneous requests.
+	Concurrency int
+	// Timeout limits a single request.
+	Timeout time.Duration
+	// MaxAttempts is after how many failures a delivery is given up.
+	MaxAttempts int
+	// The wait between attempts doubles from MinBackoff up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// AllowPrivateAddresses lifts the restriction to public addresses,
+	// meant for tests.
+	AllowPrivateAddresses bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:    time.Second,
+		BatchSize:   100,
+		Concurrency: 8,
+		Timeout:     10 * time.Second,
+		MaxAttempts: 8,
+		MinBackoff:  10 * time.Second,
+		MaxBackoff:  time.Hour,
+	}
+}
+
+type Dispatcher struct {
+	db     storage.Storage
+	log    *slog.Logger
+	client *http.Client
+	cfg    Config
+}
+
+func NewDispatcher(db storage.Storage, log *slog.Logger, cfg Config) *Dispatcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateAddresses {
+		dialer.Control = control
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would be dialed instead of the webhook, and so checked in
+	// its place.
+	transport.Proxy = nil
+
+	return &Dispatcher{
+		db:  db,
+		log: log.With(slog.String("component", "webhooks")),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// A redirect is a failed delivery rather than a way to make
+			// us post events somewhere else.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+	}
+}
+
+// Run dispatches events until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	t := time.NewTicker(d.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+				d.log.Error("error dispatch webhooks", sl.Err(err))
+			}
+		}
+	}
+}
+
+// Dispatch sends the deliveries that are due.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	// The lease outlasts the request, so a delivery is only picked up
+	// again if this dispatcher dies before recording the outcome.
+	tasks, err := d.db.ClaimDeliveries(ctx, d.cfg.BatchSize, d.cfg.Timeout+time.Minute)
+	if err != nil {
+		return err
+	}
+
+	g := &errgroup.Group{}
+	g.SetLimit(d.cfg.Concurrency)
+	for _, t := range tasks {
+		t := t
+		g.Go(func() error {
+			a := d.deliver(ctx, t)
+			if err := d.db.RecordDelivery(ctx, a); err != nil {
+				d.log.Error("error record webhook delivery", slog.Int64("delivery", t.DeliveryID), sl.Err(err))
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, t *webhook.Task) webhook.Attempt {
+	a := webhook.Attempt{DeliveryID: t.DeliveryID, Status: webhook.DeliveryDelivered}
+
+	status, err := d.send(ctx, t)
+	if status != 0 {
+		a.ResponseStatus = &status
+	}
+	if err == nil && (status < 200 || status > 299) {
+		err = fmt.Errorf("unexpected status %d", status)
+	}
+	if err == nil {
+		return a
+	}
+
+	a.Error = err.Error()
+	if len(a.Error) > maxErrorLength {
+		a.Error = a.Error[:maxErrorLength]
+	}
+
+	attempts := t.Attempts + 1
+	if attempts >= d.cfg.MaxAttempts {
+		a.Status = webhook.DeliveryFailed
+		d.log.Error("webhook delivery failed", slog.Int64("delivery", t.DeliveryID), slog.Int("attempts", attempts), sl.Err(err))
+		return a
+	}
+	a.Status = webhook.DeliveryPending
+	a.RetryIn = Backoff(attempts, d.cfg.MinBackoff, d.cfg.MaxBackoff)
+	return a
+}
+
+func (d *Dispatcher) send(ctx context.Context, t *webhook.Task) (int, error) {
+	body, err := json.Marshal(t.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gophermart-webhooks")
+	req.Header.Set(HeaderEvent, t.Event.Type)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(t.DeliveryID, 10))
+	req.Header.Set(HeaderSignature, Sign(t.Webhook.Secret, time.Now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value of a body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	t := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait after the given number of failed attempts.
+func Backoff(attempts int, min time.Duration, max time.Duration) time.Duration {
+	wait := min
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
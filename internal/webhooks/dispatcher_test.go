@@ -0,0 +1,197 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/models/webhook"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// taskStorage hands out the tasks once and records the outcomes.
+type taskStorage struct {
+	storage.Storage
+
+	mu       sync.Mutex
+	tasks    []*webhook.Task
+	attempts []webhook.Attempt
+}
+
+func (s *taskStorage) ClaimDeliveries(context.Context, int, time.Duration) ([]*webhook.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := s.tasks
+	s.tasks = nil
+	return tasks, nil
+}
+
+func (s *taskStorage) RecordDelivery(_ context.Context, a webhook.Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, a)
+	return nil
+}
+
+func newTask(url string, attempts int) *webhook.Task {
+	return &webhook.Task{
+		DeliveryID: 7,
+		Attempts:   attempts,
+		Webhook:    webhook.Webhook{ID: 1, URL: url, Secret: "whsec_test"},
+		Event: event.Event{
+			ID:   42,
+			Type: event.TypeOrderProcessed,
+			User: "gopher",
+			Data: json.RawMessage(`{"number":"12345678903","status":"PROCESSED","accrual":500}`),
+		},
+	}
+}
+
+func newDispatcher(db storage.Storage) *Dispatcher {
+	cfg := DefaultConfig()
+	cfg.MaxAttempts = 3
+	cfg.AllowPrivateAddresses = true
+	return NewDispatcher(db, slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+}
+
+func TestDispatch(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	db := &taskStorage{tasks: []*webhook.Task{newTask(srv.URL, 0)}}
+	require.NoError(t, newDispatcher(db).Dispatch(context.Background()))
+
+	require.NotNil(t, received)
+	assert.Equal(t, event.TypeOrderProcessed, received.Header.Get(HeaderEvent))
+	assert.Equal(t, "7", received.Header.Get(HeaderDelivery))
+
+	var e event.Event
+	require.NoError(t, json.Unmarshal(body, &e))
+	assert.Equal(t, int64(42), e.ID)
+	assert.Equal(t, "gopher", e.User)
+
+	signature := received.Header.Get(HeaderSignature)
+	ts, _, _ := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, Sign("whsec_test", unix, body), signature)
+
+	require.Len(t, db.attempts, 1)
+	assert.Equal(t, webhook.DeliveryDelivered, db.attempts[0].Status)
+	assert.Equal(t, http.StatusNoContent, *db.attempts[0].ResponseStatus)
+}
+
+var failureTests = []struct {
+	name     string
+	attempts int
+	status   string
+	retryIn  time.Duration
+}{
+	{
+		name:     "First failure is retried",
+		attempts: 0,
+		status:   webhook.DeliveryPending,
+		retryIn:  10 * time.Second,
+	},
+	{
+		name:     "Wait doubles",
+		attempts: 1,
+		status:   webhook.DeliveryPending,
+		retryIn:  20 * time.Second,
+	},
+	{
+		name:     "Last attempt fails the delivery",
+		attempts: 2,
+		status:   webhook.DeliveryFailed,
+	},
+}
+
+func TestDispatchFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://example.com", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	for _, test := range failureTests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &taskStorage{tasks: []*webhook.Task{newTask(srv.URL, test.attempts)}}
+			require.NoError(t, newDispatcher(db).Dispatch(context.Background()))
+
+			require.Len(t, db.attempts, 1)
+			a := db.attempts[0]
+			assert.Equal(t, test.status, a.Status)
+			assert.Equal(t, test.retryIn, a.RetryIn)
+			assert.Equal(t, http.StatusFound, *a.ResponseStatus, "redirects are not followed")
+			assert.Contains(t, a.Error, "unexpected status 302")
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, Backoff(1, time.Second, time.Minute))
+	assert.Equal(t, 8*time.Second, Backoff(4, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, Backoff(10, time.Second, time.Minute))
+}
+
+func TestDispatchRefusesPrivateAddresses(t *testing.T) {
+	var called bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	db := &taskStorage{tasks: []*webhook.Task{newTask(srv.URL, 0)}}
+	cfg := DefaultConfig()
+	require.NoError(t, NewDispatcher(db, slog.New(slog.NewTextHandler(io.Discard, nil)), cfg).Dispatch(context.Background()))
+
+	assert.False(t, called)
+	require.Len(t, db.attempts, 1)
+	assert.Nil(t, db.attempts[0].ResponseStatus)
+	assert.Contains(t, db.attempts[0].Error, ErrForbiddenAddress.Error())
+}
+
+var checkURLTests = []struct {
+	name string
+	url  string
+	ok   bool
+}{
+	{name: "Public address", url: "https://93.184.216.34/hook", ok: true},
+	{name: "Loopback", url: "http://127.0.0.1:8080/hook"},
+	{name: "Loopback name", url: "http://localhost/hook"},
+	{name: "IPv6 loopback", url: "http://[::1]/hook"},
+	{name: "Cloud metadata", url: "http://169.254.169.254/latest/meta-data"},
+	{name: "Private network", url: "http://10.1.2.3/hook"},
+	{name: "Unspecified", url: "http://0.0.0.0/hook"},
+	{name: "IPv4-mapped private", url: "http://[::ffff:192.168.0.1]/hook"},
+	{name: "Carrier-grade NAT", url: "http://100.64.0.1/hook"},
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, test := range checkURLTests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckURL(context.Background(), test.url)
+
+			if test.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrForbiddenAddress)
+			}
+		})
+	}
+}
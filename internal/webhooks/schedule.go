@@ -0,0 +1,15 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/kholodmv/gophermart/internal/models/event"
+	"github.com/kholodmv/gophermart/internal/storage"
+)
+
+// Schedule returns an event handler which schedules a delivery of every
+// event to the webhooks subscribed to it.
+func Schedule(db storage.Storage) func(ctx context.Context, e event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		return db.AddWebhookDeliveries(ctx, e)
+	}
+}
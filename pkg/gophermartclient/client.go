@@ -0,0 +1,410 @@
+// Package gophermartclient is a Go client of the gophermart HTTP API.
+//
+// The client keeps the session token between calls and logs in again
+// with the last used credentials when the token expires or is revoked.
+// Responses are gzip-decoded, and idempotent calls are retried on
+// network errors and temporary server failures.
+package gophermartclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/models/withdraw"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The models are shared with the server.
+type (
+	Order       = order.Order
+	OrderNumber = order.Number
+	OrderStatus = order.Status
+	Withdrawal  = withdraw.Withdraw
+	Balance     = withdraw.Balance
+)
+
+const (
+	defaultRetries   = 3
+	defaultRetryWait = 100 * time.Millisecond
+	maxResponseSize  = 10 << 20
+)
+
+type Client struct {
+	baseURL      string
+	http         *http.Client
+	tokens       TokenStore
+	apiKey       string
+	retries      int
+	retryWait    time.Duration
+	gzipRequests bool
+
+	mu          sync.Mutex
+	credentials *credentials
+}
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type Option func(c *Client)
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+func WithTokenStore(s TokenStore) Option {
+	return func(c *Client) {
+		c.tokens = s
+	}
+}
+
+// WithAPIKey authenticates with an API key instead of a session token.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetries sets how many times idempotent calls are retried and the
+// initial wait, which doubles with every attempt. Zero disables retries.
+func WithRetries(n int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.retryWait = wait
+	}
+}
+
+// WithGzipRequests compresses request bodies.
+func WithGzipRequests() Option {
+	return func(c *Client) {
+		c.gzipRequests = true
+	}
+}
+
+// New returns a client of the API at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		http:      &http.Client{Timeout: 30 * time.Second},
+		tokens:    &MemoryTokenStore{},
+		retries:   defaultRetries,
+		retryWait: defaultRetryWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Register creates a user and logs in as it.
+func (c *Client) Register(ctx context.Context, login string, password string) error {
+	return c.authenticate(ctx, "/api/user/register", credentials{Login: login, Password: password})
+}
+
+func (c *Client) Login(ctx context.Context, login string, password string) error {
+	return c.authenticate(ctx, "/api/user/login", credentials{Login: login, Password: password})
+}
+
+// UploadOrder submits an order for accrual. It reports whether the order
+// is new; uploading an order again is not an error.
+func (c *Client) UploadOrder(ctx context.Context, number OrderNumber) (bool, error) {
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/api/user/orders",
+		contentType: "text/plain",
+		body:        []byte(number),
+		idempotent:  true,
+		auth:        true,
+	})
+	if err != nil {
+		return false, err
+	}
+	switch resp.status {
+	case http.StatusAccepted:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	}
+	return false, resp.err()
+}
+
+// Orders lists uploaded orders, newest first.
+func (c *Client) Orders(ctx context.Context) ([]Order, error) {
+	var orders []Order
+	if err := c.getJSON(ctx, "/api/user/orders", &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (c *Client) Balance(ctx context.Context) (*Balance, error) {
+	var b Balance
+	if err := c.getJSON(ctx, "/api/user/balance", &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Withdraw spends points on a new order. It is never retried, as the
+// outcome of a failed attempt is unknown.
+func (c *Client) Withdraw(ctx context.Context, number OrderNumber, sum float32) error {
+	body, err := json.Marshal(Withdrawal{Order: string(number), Sum: sum})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        "/api/user/balance/withdraw",
+		contentType: "application/json",
+		body:        body,
+		auth:        true,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.status != http.StatusOK {
+		return resp.err()
+	}
+	return nil
+}
+
+// Withdrawals lists withdrawals, newest first.
+func (c *Client) Withdrawals(ctx context.Context) ([]Withdrawal, error) {
+	var withdrawals []Withdrawal
+	if err := c.getJSON(ctx, "/api/user/withdrawals", &withdrawals); err != nil {
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+func (c *Client) authenticate(ctx context.Context, path string, creds credentials) error {
+	if err := c.login(ctx, path, creds); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.credentials = &creds
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) login(ctx context.Context, path string, creds credentials) error {
+	body, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        path,
+		contentType: "application/json",
+		body:        body,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.status != http.StatusOK {
+		return resp.err()
+	}
+	return nil
+}
+
+// relogin gets a new token with the remembered credentials.
+func (c *Client) relogin(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	creds := c.credentials
+	c.mu.Unlock()
+
+	if creds == nil {
+		return false, nil
+	}
+	if err := c.login(ctx, "/api/user/login", *creds); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getJSON fetches a list or an object; no content leaves v untouched.
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       path,
+		idempotent: true,
+		auth:       true,
+	})
+	if err != nil {
+		return err
+	}
+	switch resp.status {
+	case http.StatusOK:
+		return json.Unmarshal(resp.body, v)
+	case http.StatusNoContent:
+		return nil
+	}
+	return resp.err()
+}
+
+type request struct {
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	// idempotent requests are safe to retry.
+	idempotent bool
+	// auth requests need a session and trigger a new login on 401.
+	auth bool
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (r *response) err() error {
+	e := &Error{StatusCode: r.status}
+	if strings.HasPrefix(r.header.Get("Content-Type"), "application/problem+json") {
+		p := &Problem{}
+		if json.Unmarshal(r.body, p) == nil {
+			e.Problem = p
+		}
+	}
+	return e
+}
+
+func (c *Client) do(ctx context.Context, r request) (*response, error) {
+	resp, err := c.retry(ctx, r)
+	if err != nil || resp.status != http.StatusUnauthorized || !r.auth || c.apiKey != "" {
+		return resp, err
+	}
+
+	// The token expired or was revoked.
+	ok, err := c.relogin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gophermartclient: login again: %w", err)
+	}
+	if !ok {
+		return resp, nil
+	}
+	return c.retry(ctx, r)
+}
+
+func (c *Client) retry(ctx context.Context, r request) (*response, error) {
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, r)
+		if attempt >= c.retries || !r.idempotent || !temporary(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		d := wait
+		if resp != nil {
+			if s, convErr := strconv.Atoi(resp.header.Get("Retry-After")); convErr == nil {
+				d = time.Duration(s) * time.Second
+			}
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		wait *= 2
+	}
+}
+
+func temporary(resp *response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) send(ctx context.Context, r request) (*response, error) {
+	body := r.body
+	if body != nil && c.gzipRequests {
+		var err error
+		if body, err = compress(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, c.baseURL+r.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if body != nil && c.gzipRequests {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	// Set explicitly, so decoding doesn't depend on the transport.
+	req.Header.Set("Accept-Encoding", "gzip")
+	if r.auth {
+		if c.apiKey != "" {
+			req.Header.Set("X-API-Key", c.apiKey)
+		} else if token := c.tokens.Token(); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var reader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			// An empty body is sent compressed as nothing at all.
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			reader = bytes.NewReader(nil)
+		} else {
+			defer gz.Close()
+			reader = gz
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if token, ok := strings.CutPrefix(resp.Header.Get("Authorization"), "Bearer "); ok {
+		c.tokens.SetToken(token)
+	}
+
+	return &response{status: resp.StatusCode, header: resp.Header, body: data}, nil
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
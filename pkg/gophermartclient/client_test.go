@@ -0,0 +1,208 @@
+package gophermartclient
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/kholodmv/gophermart/internal/http-server/handlers"
+	"github.com/kholodmv/gophermart/internal/http-server/openapi"
+	"github.com/kholodmv/gophermart/internal/models/order"
+	"github.com/kholodmv/gophermart/internal/storage"
+	"github.com/kholodmv/gophermart/internal/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testLogin    = "gopher"
+	testPassword = "Tunnel-Digger-42"
+)
+
+func newServer(t *testing.T, db storage.Storage, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+	validator, err := openapi.NewValidator(doc, log, false)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	handlers.NewHandler(router, log, db, handlers.WithValidator(validator)).RegisterRoutes()
+
+	var h http.Handler = router
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	db := storagetest.NewMemory()
+	srv := newServer(t, db, nil)
+	c := New(srv.URL, WithGzipRequests())
+	ctx := context.Background()
+
+	require.NoError(t, c.Register(ctx, testLogin, testPassword))
+
+	orders, err := c.Orders(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+
+	created, err := c.UploadOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	created, err = c.UploadOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	orders, err = c.Orders(ctx)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, OrderNumber("12345678903"), orders[0].Number)
+	assert.Equal(t, order.StatusNew, orders[0].Status)
+
+	db.SetAccrual(testLogin, 500)
+
+	require.NoError(t, c.Withdraw(ctx, "2377225624", 120))
+
+	balance, err := c.Balance(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Balance{Current: 380, Withdrawn: 120}, *balance)
+
+	withdrawals, err := c.Withdrawals(ctx)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, "2377225624", withdrawals[0].Order)
+
+	err = c.Withdraw(ctx, "2377225624", 1000)
+	assert.True(t, IsCode(err, CodeInsufficientFunds), err)
+}
+
+var errorTests = []struct {
+	name   string
+	call   func(ctx context.Context, c *Client) error
+	status int
+	code   string
+}{
+	{
+		name: "Login taken",
+		call: func(ctx context.Context, c *Client) error {
+			return c.Register(ctx, testLogin, testPassword)
+		},
+		status: http.StatusConflict,
+		code:   CodeLoginTaken,
+	},
+	{
+		name: "Wrong password",
+		call: func(ctx context.Context, c *Client) error {
+			return c.Login(ctx, testLogin, "Not-The-Password-1")
+		},
+		status: http.StatusUnauthorized,
+		code:   CodeInvalidCredentials,
+	},
+	{
+		name: "Invalid order number",
+		call: func(ctx context.Context, c *Client) error {
+			_, err := c.UploadOrder(ctx, "12345678900")
+			return err
+		},
+		status: http.StatusUnprocessableEntity,
+		code:   CodeInvalidOrderNumber,
+	},
+	{
+		name: "Malformed order number",
+		call: func(ctx context.Context, c *Client) error {
+			_, err := c.UploadOrder(ctx, "12ab")
+			return err
+		},
+		status: http.StatusBadRequest,
+		code:   CodeValidationFailed,
+	},
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := newServer(t, storagetest.NewMemory(), nil)
+	ctx := context.Background()
+
+	c := New(srv.URL)
+	require.NoError(t, c.Register(ctx, testLogin, testPassword))
+
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call(ctx, c)
+
+			var apiErr *Error
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, test.status, apiErr.StatusCode)
+			assert.Equal(t, test.code, apiErr.Code())
+			assert.NotEmpty(t, apiErr.Problem.RequestID)
+		})
+	}
+}
+
+func TestClientLogsInAgain(t *testing.T) {
+	db := storagetest.NewMemory()
+	srv := newServer(t, db, nil)
+	ctx := context.Background()
+
+	tokens := &MemoryTokenStore{}
+	c := New(srv.URL, WithTokenStore(tokens))
+	require.NoError(t, c.Register(ctx, testLogin, testPassword))
+	first := tokens.Token()
+	require.NotEmpty(t, first)
+
+	db.RevokeSessions(testLogin)
+
+	_, err := c.Orders(ctx)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, tokens.Token())
+
+	// Without credentials the client can't recover.
+	db.RevokeSessions(testLogin)
+	other := New(srv.URL, WithTokenStore(tokens))
+	_, err = other.Orders(ctx)
+	assert.True(t, IsCode(err, "session_revoked"), err)
+}
+
+func TestClientRetries(t *testing.T) {
+	var failures atomic.Int32
+	unavailable := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failures.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv := newServer(t, storagetest.NewMemory(), unavailable)
+	ctx := context.Background()
+
+	c := New(srv.URL, WithRetries(2, time.Millisecond))
+	require.NoError(t, c.Register(ctx, testLogin, testPassword))
+
+	failures.Store(2)
+	_, err := c.Balance(ctx)
+	assert.NoError(t, err, "idempotent calls are retried")
+
+	failures.Store(3)
+	_, err = c.Balance(ctx)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+
+	failures.Store(1)
+	err = c.Withdraw(ctx, "2377225624", 1)
+	require.ErrorAs(t, err, &apiErr, "withdrawals are not retried")
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+}
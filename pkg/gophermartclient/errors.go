@@ -0,0 +1,52 @@
+package gophermartclient
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kholodmv/gophermart/internal/http-server/problem"
+	"net/http"
+)
+
+// Problem is the error body sent by the server.
+type Problem = problem.Problem
+
+// Codes of problems worth handling in clients.
+const (
+	CodeValidationFailed   = problem.CodeValidationFailed
+	CodeInvalidCredentials = problem.CodeInvalidCredentials
+	CodeOTPRequired        = problem.CodeOTPRequired
+	CodeTooManyAttempts    = problem.CodeTooManyAttempts
+	CodeLoginTaken         = problem.CodeLoginTaken
+	CodeInvalidOrderNumber = problem.CodeInvalidOrderNumber
+	CodeOrderExists        = problem.CodeOrderExists
+	CodeInsufficientFunds  = problem.CodeInsufficientFunds
+	CodeWithdrawalConflict = problem.CodeWithdrawalConflict
+)
+
+// Error is returned for unexpected response statuses.
+type Error struct {
+	StatusCode int
+	// Problem is nil if the response carried no problem details.
+	Problem *Problem
+}
+
+func (e *Error) Error() string {
+	if e.Problem != nil && e.Problem.Detail != "" {
+		return fmt.Sprintf("gophermartclient: %d %s: %s", e.StatusCode, e.Problem.Code, e.Problem.Detail)
+	}
+	return fmt.Sprintf("gophermartclient: unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Code returns the problem code, if any.
+func (e *Error) Code() string {
+	if e.Problem == nil {
+		return ""
+	}
+	return e.Problem.Code
+}
+
+// IsCode reports whether err is an API error with the problem code.
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code() == code
+}
@@ -0,0 +1,27 @@
+package gophermartclient
+
+import "sync"
+
+// TokenStore keeps the session token, e.g. to share it between clients
+// or to persist it across restarts.
+type TokenStore interface {
+	Token() string
+	SetToken(token string)
+}
+
+type MemoryTokenStore struct {
+	mu    sync.RWMutex
+	token string
+}
+
+func (s *MemoryTokenStore) Token() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.token
+}
+
+func (s *MemoryTokenStore) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}